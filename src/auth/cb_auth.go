@@ -59,12 +59,18 @@ type CBAuth struct {
 
 // NewAuthMap generates the authentication headers required for a given request
 func (c *CBAuth) NewAuthMap(requestMethod, requestBody, requestPath string) map[string]string {
+	return c.NewAuthMapAt(requestMethod, requestBody, requestPath, time.Now())
+}
+
+// NewAuthMapAt generates the authentication headers required for a given request, signed with the provided time
+// instead of the local clock (ie. corrected for clock skew)
+func (c *CBAuth) NewAuthMapAt(requestMethod, requestBody, requestPath string, now time.Time) map[string]string {
 
 	// Setup return object
 	headers := map[string]string{}
 
 	// Generate new timestamp for this call
-	timestamp := int(time.Now().Unix())
+	timestamp := int(now.Unix())
 
 	// Sign at the dotted line...
 	sigText := strconv.Itoa(timestamp) + requestMethod + requestPath + requestBody
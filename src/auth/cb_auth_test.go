@@ -42,4 +42,16 @@ func TestCBAuth(t *testing.T) {
 
 		assert.Equal(t, expectedSignature, actualSignature, "signatures should be the same")
 	})
+
+	t.Run("Signed with the provided time", func(t *testing.T) {
+		skewed := time.Unix(1435082571, 0)
+		skewedResp := auth.NewAuthMapAt(requestMethod, requestBody, requestPath, skewed)
+
+		sigText := "1435082571" + requestMethod + requestPath + requestBody
+		h := hmac.New(sha256.New, []byte(testSecretKey))
+		h.Write([]byte(sigText))
+
+		assert.Equal(t, "1435082571", skewedResp[CBAccessTimestamp], "should be the same")
+		assert.Equal(t, hex.EncodeToString(h.Sum(nil)), skewedResp[CBAccessSign], "signatures should be the same")
+	})
 }
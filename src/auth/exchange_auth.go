@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// CBAccessPassphrase is the passphrase chosen when the exchange API key was created
+	CBAccessPassphrase = "CB-ACCESS-PASSPHRASE"
+)
+
+// ExchangeAuth is the authentication object used to create a signature for a Coinbase Exchange (Advanced Trade)
+// request
+type ExchangeAuth struct {
+	APIKey     string
+	APISecret  string
+	Passphrase string
+}
+
+// Ref: https://docs.cloud.coinbase.com/exchange/docs/authorization-and-authentication
+// All REST requests must contain the following headers:
+//
+// CB-ACCESS-KEY API key as a string
+// CB-ACCESS-SIGN The base64-encoded signature (see below)
+// CB-ACCESS-TIMESTAMP A timestamp for your request
+// CB-ACCESS-PASSPHRASE The passphrase you specified when creating the API key
+//
+// The CB-ACCESS-SIGN header is generated by creating a sha256 HMAC using the base64-decoded secret key on the
+// prehash string timestamp + method + requestPath + body (where + represents string concatenation) and
+// base64-encode the output. The timestamp value is the same as the CB-ACCESS-TIMESTAMP header.
+//
+// Unlike the wallet API the secret is base64 encoded, and the signature is base64 rather than hex encoded.
+
+// NewAuthMap generates the authentication headers required for a given request, an error is returned when the
+// secret isn't valid base64
+func (e *ExchangeAuth) NewAuthMap(requestMethod, requestBody, requestPath string) (map[string]string, error) {
+	return e.NewAuthMapAt(requestMethod, requestBody, requestPath, time.Now())
+}
+
+// NewAuthMapAt generates the authentication headers required for a given request, signed with the provided time
+// instead of the local clock (ie. corrected for clock skew)
+func (e *ExchangeAuth) NewAuthMapAt(requestMethod, requestBody, requestPath string,
+	now time.Time) (map[string]string, error) {
+
+	secret, err := base64.StdEncoding.DecodeString(e.APISecret)
+	if err != nil {
+		return map[string]string{}, fmt.Errorf("exchange api secret isn't base64 encoded: %w", err)
+	}
+
+	// Generate new timestamp for this call
+	timestamp := int(now.Unix())
+
+	sigText := strconv.Itoa(timestamp) + requestMethod + requestPath + requestBody
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(sigText))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return map[string]string{
+		CBAccessKey:        e.APIKey,
+		CBAccessTimestamp:  fmt.Sprintf("%d", timestamp),
+		CBAccessSign:       signature,
+		CBAccessPassphrase: e.Passphrase,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeAuth(t *testing.T) {
+
+	// Setup Test specifics
+	requestBody := ""
+	requestMethod := "GET"
+	requestPath := "/fills?product_id=DOGE-USD"
+	testSecret := []byte("aReYoUnOtEnTeRtAiNeD")
+
+	auth := ExchangeAuth{
+		APIKey:     "SoMeThInGcRaZy",
+		APISecret:  base64.StdEncoding.EncodeToString(testSecret),
+		Passphrase: "OpEnSeSaMe",
+	}
+
+	actualResp, err := auth.NewAuthMap(requestMethod, requestBody, requestPath)
+
+	t.Run("Test return contents exist", func(t *testing.T) {
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, "SoMeThInGcRaZy", actualResp[CBAccessKey], "should be the same")
+		assert.Equal(t, "OpEnSeSaMe", actualResp[CBAccessPassphrase], "should be the same")
+		assert.Contains(t, actualResp, CBAccessSign)
+		assert.Contains(t, actualResp, CBAccessTimestamp)
+	})
+
+	t.Run("Validate signature was calculated correctly", func(t *testing.T) {
+		// The signature is over the decoded secret, and base64 encoded
+		sigText := actualResp[CBAccessTimestamp] + requestMethod + requestPath + requestBody
+		h := hmac.New(sha256.New, testSecret)
+		h.Write([]byte(sigText))
+		expectedSignature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+		assert.Equal(t, expectedSignature, actualResp[CBAccessSign], "signatures should be the same")
+	})
+
+	t.Run("Secret isn't base64", func(t *testing.T) {
+		badAuth := ExchangeAuth{APIKey: "SoMeThInGcRaZy", APISecret: "not base64!", Passphrase: "OpEnSeSaMe"}
+
+		headers, err := badAuth.NewAuthMap(requestMethod, requestBody, requestPath)
+
+		assert.NotNil(t, err, "should fail to decode the secret")
+		assert.Empty(t, headers, "no headers should be produced")
+	})
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+)
+
+var (
+	// KrakenAPIKey is the API key established in your kraken account settings
+	KrakenAPIKey = "API-Key"
+
+	// KrakenAPISign is the calculated signature used to authenticate the request
+	KrakenAPISign = "API-Sign"
+)
+
+// KrakenAuth is the authentication object used to create a signature for a kraken request
+type KrakenAuth struct {
+	APIKey    string
+	APISecret string
+}
+
+// Ref: https://docs.kraken.com/rest/#section/Authentication/Headers-and-Signature
+// Private REST requests must contain the following headers:
+//
+// API-Key The API key as a string
+// API-Sign Message signature (see below)
+//
+// The API-Sign header is generated by creating a sha512 HMAC using the base64-decoded secret key on the URI path
+// followed by the sha256 digest of the nonce and the POST data, base64-encoding the output:
+//
+//   HMAC-SHA512 of (URI path + SHA256(nonce + POST data)) and base64 decoded secret API key
+//
+// The POST data is the url-encoded body of the request, which must include the nonce. The nonce must always
+// increase for a given API key, rather than a timestamp kraken uses it to reject replayed requests.
+
+// NewAuthMap generates the authentication headers required for a given request, an error is returned when the
+// secret isn't valid base64
+func (k *KrakenAuth) NewAuthMap(requestPath, nonce, postData string) (map[string]string, error) {
+
+	secret, err := base64.StdEncoding.DecodeString(k.APISecret)
+	if err != nil {
+		return map[string]string{}, fmt.Errorf("kraken api secret isn't base64 encoded: %w", err)
+	}
+
+	digest := sha256.Sum256([]byte(nonce + postData))
+	h := hmac.New(sha512.New, secret)
+	h.Write(append([]byte(requestPath), digest[:]...))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return map[string]string{
+		KrakenAPIKey:  k.APIKey,
+		KrakenAPISign: signature,
+	}, nil
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKrakenAuth(t *testing.T) {
+
+	// The example from kraken's documentation
+	// Ref: https://docs.kraken.com/rest/#section/Authentication/Headers-and-Signature
+	auth := KrakenAuth{
+		APIKey:    "SoMeThInGcRaZy",
+		APISecret: "kQH5HW/8p1uGOVjbgWA7FunAmGO8lsSUXNsu3eow76sz84Q18fWxnyRzBHCd3pd5nE9qa99HAZtuZuj6F1huXg==",
+	}
+	nonce := "1616492376594"
+	postData := "nonce=1616492376594&ordertype=limit&pair=XBTUSD&price=37500&type=buy&volume=1.25"
+
+	headers, err := auth.NewAuthMap("/0/private/AddOrder", nonce, postData)
+
+	t.Run("Test return contents exist", func(t *testing.T) {
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, "SoMeThInGcRaZy", headers[KrakenAPIKey], "should be the same")
+	})
+
+	t.Run("Validate signature was calculated correctly", func(t *testing.T) {
+		assert.Equal(t, "4/dpxb3iT4tp/ZCVEwSnEsLxx0bqyhLpdfOpc6fn7OR8+UClSV5n9E6aSS8MPtnRfp32bAb0nmbRn6H8ndwLUQ==",
+			headers[KrakenAPISign], "signatures should be the same")
+	})
+
+	t.Run("Secret isn't base64", func(t *testing.T) {
+		badAuth := KrakenAuth{APIKey: "SoMeThInGcRaZy", APISecret: "not base64!"}
+
+		headers, err := badAuth.NewAuthMap("/0/private/Balance", nonce, "nonce="+nonce)
+
+		assert.NotNil(t, err, "should fail to decode the secret")
+		assert.Empty(t, headers, "no headers should be produced")
+	})
+}
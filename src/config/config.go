@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 	"warchest/src/query"
 )
 
@@ -42,12 +44,34 @@ type Config struct {
 	Transactions []Transaction `json:"coin_purchases"`
 }
 
-// Transaction is an individual transaction object used by warchest
+// Transaction is an individual transaction object used by warchest, the purchased price and fee are in Currency.
+// Transactions are buys unless Kind says otherwise, the purchased price of a disposal (ie. a sell) is its proceeds,
+// and LotIDs are the IDs of the transactions a disposal takes its coins from with the specific-id cost method.
 type Transaction struct {
-	CoinSymbol        string  `json:"coin_symbol"`
-	Amount            float64 `json:"amount"`
-	PurchasedPriceUSD float64 `json:"purchased_price_usd"`
-	TransactionFee    float64 `json:"transaction_fee"`
+	ID             string                `json:"id"`
+	Kind           query.TransactionKind `json:"kind"`
+	Timestamp      time.Time             `json:"timestamp"`
+	CoinSymbol     string                `json:"coin_symbol"`
+	Amount         query.Decimal         `json:"amount"`
+	PurchasedPrice query.Decimal         `json:"purchased_price"`
+	Currency       string                `json:"currency"`
+	TransactionFee query.Decimal         `json:"transaction_fee"`
+	LotIDs         []string              `json:"lot_ids"`
+
+	// PurchasedPriceUSD is read from configs written before the currency could be chosen
+	PurchasedPriceUSD query.Decimal `json:"purchased_price_usd"`
+}
+
+// Price returns the purchased price of the transaction along with the currency it was paid in, USD when a currency
+// isn't provided
+func (t *Transaction) Price() (query.Decimal, string) {
+	if t.PurchasedPrice.IsZero() && !t.PurchasedPriceUSD.IsZero() {
+		return t.PurchasedPriceUSD, "USD"
+	}
+	if t.Currency == "" {
+		return t.PurchasedPrice, query.DefaultCurrency
+	}
+	return t.PurchasedPrice, strings.ToUpper(t.Currency)
 }
 
 // Exists method that checks if the config file exists
@@ -120,7 +144,7 @@ func (c *LocalConfigFile) ToConfig() (Config, error) {
 }
 
 // ToWallet method that produces a wallet based on the config object
-func (c *Config) ToWallet() query.Wallet {
+func (c *Config) ToWallet() *query.Wallet {
 
 	coins := make(map[string]query.WarchestCoin)
 
@@ -132,8 +156,7 @@ func (c *Config) ToWallet() query.Wallet {
 		// Is Coin found?
 		coin, ok := coins[coinSymbol]
 		if !ok {
-			coinToInit := query.WarchestCoin{"", 0.0, 0.0, 0.0, query.CoinRates{},
-				coinSymbol, []query.CoinTransaction{}, ""}
+			coinToInit := query.WarchestCoin{Symbol: coinSymbol, Transactions: []query.CoinTransaction{}}
 
 			coins[configTransaction.CoinSymbol] = coinToInit
 
@@ -141,14 +164,22 @@ func (c *Config) ToWallet() query.Wallet {
 			coin = coinToInit
 		}
 
-		coinTransaction := query.CoinTransaction{configTransaction.Amount,
-			configTransaction.PurchasedPriceUSD, configTransaction.TransactionFee}
+		kind := configTransaction.Kind
+		if kind == "" {
+			kind = query.KindBuy
+		}
+
+		purchasedPrice, currency := configTransaction.Price()
+		coinTransaction := query.CoinTransaction{ID: configTransaction.ID, Kind: kind,
+			Timestamp: configTransaction.Timestamp, Currency: currency, NumCoins: configTransaction.Amount,
+			PurchasedPrice: purchasedPrice, TransactionFee: configTransaction.TransactionFee,
+			LotIDs: configTransaction.LotIDs}
 
 		coin.Transactions = append(coin.Transactions, coinTransaction)
 		coins[configTransaction.CoinSymbol] = coin
 	}
 
-	wallet := query.Wallet{map[string]query.WarchestCoin{}, 0.0}
+	wallet := &query.Wallet{Coins: map[string]query.WarchestCoin{}}
 	// Convert map to wallet
 	for _, coin := range coins {
 		// Create new coins from the collection above
@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"warchest/src/query"
 )
 
 func TestConfig(t *testing.T) {
@@ -18,15 +19,15 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, tmpConfig.Transactions[0].CoinSymbol, "ETH")
 
 		valueTests := []struct {
-			actualValue   float64
-			expectedValue float64
+			actualValue   query.Decimal
+			expectedValue query.Decimal
 		}{
-			{tmpConfig.Transactions[0].Amount, 10.1},
-			{tmpConfig.Transactions[0].PurchasedPriceUSD, 34.5},
-			{tmpConfig.Transactions[0].TransactionFee, 6.56},
-			{tmpConfig.Transactions[1].Amount, 5.0},
-			{tmpConfig.Transactions[1].PurchasedPriceUSD, 1.2},
-			{tmpConfig.Transactions[1].TransactionFee, 0.35},
+			{tmpConfig.Transactions[0].Amount, query.MustDecimal("10.1")},
+			{tmpConfig.Transactions[0].PurchasedPriceUSD, query.MustDecimal("34.5")},
+			{tmpConfig.Transactions[0].TransactionFee, query.MustDecimal("6.56")},
+			{tmpConfig.Transactions[1].Amount, query.NewDecimal(5)},
+			{tmpConfig.Transactions[1].PurchasedPriceUSD, query.MustDecimal("1.2")},
+			{tmpConfig.Transactions[1].TransactionFee, query.MustDecimal("0.35")},
 		}
 
 		// Validate the rest of the imported values
@@ -50,6 +51,43 @@ func TestConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("Convert config with currencies to wallet", func(t *testing.T) {
+		testConfigFile := LocalConfigFile{Filepath: "./testdata/CoinConfigCurrencies.json"}
+		tmpConfig, err := testConfigFile.ToConfig()
+		assert.Nil(t, err, "Should not fail loading string")
+
+		wallet := tmpConfig.ToWallet()
+		eth := wallet.Coins["ETH"].Transactions
+		algo := wallet.Coins["ALGO"].Transactions
+
+		assert.Equal(t, 2, len(eth), "should be the same")
+		assert.Equal(t, "CHF", eth[0].Currency, "the currency should be normalized")
+		assert.Equal(t, query.NewDecimal(3100), eth[0].PurchasedPrice, "should be the same")
+		assert.Equal(t, query.DefaultCurrency, eth[1].Currency, "the default currency should be used")
+		assert.Equal(t, "USD", algo[0].Currency, "purchased_price_usd should still be read as USD")
+		assert.Equal(t, query.MustDecimal("1.2"), algo[0].PurchasedPrice, "should be the same")
+	})
+
+	t.Run("Convert config with disposals to wallet", func(t *testing.T) {
+		testConfigFile := LocalConfigFile{Filepath: "./testdata/CoinConfigLots.json"}
+		tmpConfig, err := testConfigFile.ToConfig()
+		assert.Nil(t, err, "Should not fail loading string")
+
+		eth := tmpConfig.ToWallet().Coins["ETH"]
+		assert.Equal(t, query.KindBuy, eth.Transactions[0].Kind, "transactions should be buys by default")
+		assert.Equal(t, query.KindSell, eth.Transactions[3].Kind, "should be the same")
+		assert.Equal(t, []string{"eth-2", "eth-3"}, eth.Transactions[3].LotIDs, "should be the same")
+
+		eth.CostMethod = query.MethodSpecificID
+		eth.UpdateCost()
+
+		assert.Equal(t, 2, len(eth.Disposals), "should be the same")
+		assert.Equal(t, "eth-2", eth.Disposals[0].LotID, "the sale should take the lots it lists")
+		assert.Equal(t, "eth-3", eth.Disposals[1].LotID, "should be the same")
+		assert.Equal(t, query.MustDecimal("0.5"), eth.Disposals[1].NumCoins, "should be the same")
+		assert.Equal(t, query.NewDecimal(1000+600), eth.Cost, "eth-1 and half of eth-3 should be left")
+	})
+
 	// Cloudy Path
 	t.Run("Test file existence", func(t *testing.T) {
 
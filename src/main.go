@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
-	"sync"
+	"text/tabwriter"
 	"time"
 	"warchest/src/auth"
 	"warchest/src/config"
@@ -25,6 +29,12 @@ const FailedRetrievingData = 3
 // FailedCalculatingWallet Return code for failing calculation of wallet
 const FailedCalculatingWallet = 4
 
+// InvalidCommandRC Return code for a command or command flags that aren't valid
+const InvalidCommandRC = 5
+
+// FailedWritingReportRC Return code for failing to write a report
+const FailedWritingReportRC = 6
+
 //
 // Env Variables
 ////////////////////
@@ -35,6 +45,30 @@ const CbAPIKey = "CB_API_KEY"
 // CbAPISecret is the secret associated with the cbAPIKey
 const CbAPISecret = "CB_API_SECRET"
 
+// CbAPIURL overrides the coinbase API url, useful for pointing at the sandbox or a local fake server
+const CbAPIURL = "CB_API_URL"
+
+// CbExchangeAPIKey is the api key for the coinbase exchange (Advanced Trade)
+const CbExchangeAPIKey = "CB_EXCHANGE_API_KEY"
+
+// CbExchangeAPISecret is the base64 encoded secret associated with the CbExchangeAPIKey
+const CbExchangeAPISecret = "CB_EXCHANGE_API_SECRET"
+
+// CbExchangePassphrase is the passphrase chosen when the CbExchangeAPIKey was created
+const CbExchangePassphrase = "CB_EXCHANGE_PASSPHRASE"
+
+// CbExchangeAPIURL overrides the coinbase exchange API url, useful for pointing at the sandbox
+const CbExchangeAPIURL = "CB_EXCHANGE_API_URL"
+
+// KrakenAPIKey is the api key established via your kraken account settings
+const KrakenAPIKey = "KRAKEN_API_KEY"
+
+// KrakenAPISecret is the base64 encoded private key associated with the KrakenAPIKey
+const KrakenAPISecret = "KRAKEN_API_SECRET"
+
+// KrakenAPIURL overrides the kraken API url, useful for pointing at a local fake server
+const KrakenAPIURL = "KRAKEN_API_URL"
+
 // DemoConfig is the internal config that is used for demoing
 const DemoConfig = "./src/config/testdata/CoinConfig.json"
 
@@ -44,12 +78,41 @@ const LogFile = "./logs/warchest.log"
 // WarchestStaticPath is the env var that defines where public/static files are being served from
 const WarchestStaticPath = "WARCHEST_STATIC_PATH"
 
+// WarchestPriceCacheEnv is the env var that defines where historical spot prices are saved
+const WarchestPriceCacheEnv = "WARCHEST_PRICE_CACHE"
+
+// DefaultPriceCache is where historical spot prices are saved when WARCHEST_PRICE_CACHE isn't set
+const DefaultPriceCache = "./cache/spot_prices.json"
+
 // WarchestConfigEnv is the environment variable that will point to coin transactions used by Warchest
 const WarchestConfigEnv = "WARCHEST_CONFIG"
 
 var (
-	once           sync.Once
-	warchestWallet *query.Wallet
+	cbClient          *query.CoinbaseClient
+	providers         []query.Provider
+	walletService     *query.WalletService
+	walletConcurrency = query.DefaultConcurrency
+	rateCache         = query.NewRateCache(query.DefaultRateTTL)
+
+	// coinFilter decides which accounts make up the wallet, every non-fiat account by default
+	coinFilter = query.CoinFilter{}
+
+	// baseCurrency is the currency cost and profit are calculated in
+	baseCurrency = query.DefaultCurrency
+
+	// costMethod decides which lots the coins disposed of are taken from
+	costMethod = query.DefaultCostMethod
+
+	// beforeFees shows cost and profit without transaction fees, which are still reported on their own
+	beforeFees = false
+
+	// skippedAccounts are the accounts the coinFilter left out of the wallet
+	skippedAccounts = []query.SkippedAccount{}
+
+	// syncClock enables signing requests with a timestamp corrected by the offset to coinbase's clock
+	syncClock    = false
+	clockRefresh = query.DefaultClockRefresh
+	skewClock    *query.SkewClock
 )
 
 // IsDemoMode is a helper method to determine if CbAPIKey is set to demo (case insensitive)
@@ -63,79 +126,329 @@ func IsDemoMode() bool {
 	return false
 }
 
-// GetWalletSingleton will retrieve the wallet singleton used by the application
-// TODO: this should take in a new flag to specify whether or not to use local config for the transaction
-//       base
-func GetWalletSingleton() *query.Wallet {
-
-	// TODO: Could this be a singleton?
+// NewCoinbaseClient creates the coinbase client used by the application from the environment
+func NewCoinbaseClient() *query.CoinbaseClient {
 	client := http.Client{
 		Timeout: time.Second * 10,
 	}
-	var absClient query.HTTPClient
-	absClient = &client
+
 	apiKey := os.Getenv(CbAPIKey)
 	apiSecret := os.Getenv(CbAPISecret)
-	demoMode := IsDemoMode()
-	cbAuth := auth.CBAuth{apiKey, apiSecret}
-
-	// Instantiate the object since it doesn't exist
-	once.Do(func() {
-		log.Printf("Wallet is being instantiated now")
-
-		// Initialize the singleton object
-		warchestWallet = &query.Wallet{Coins: map[string]query.WarchestCoin{}, NetProfit: 0.0}
-
-		// Query Coinbase to build a Warchest Wallet
-		if !demoMode {
-			// Retreive coins for account
-			coins, err := query.GetWarchestCoins(cbAuth, absClient, demoMode)
-			if err != nil {
-				log.Printf("Failed to retrieve Warchest Coins: %s\n", err)
-				return
-			}
+	cbClient := query.NewCoinbaseClient(auth.CBAuth{APIKey: apiKey, APISecret: apiSecret},
+		query.NewRetryClient(&client))
+	cbClient.RateCache = rateCache
 
-			log.Printf("There are %d coins in this wallet", len(coins))
+	priceCachePath, ok := os.LookupEnv(WarchestPriceCacheEnv)
+	if !ok {
+		priceCachePath = DefaultPriceCache
+	}
+	spotPrices, err := query.NewSpotPriceCache(priceCachePath)
+	if err != nil {
+		log.Printf("Failed loading spot prices from %s, starting with an empty cache: %s", priceCachePath, err)
+	}
+	cbClient.SpotPrices = spotPrices
 
-			warchestWallet.Coins = coins
-			// Only use internal transactions to build wallet
-		} else {
-			demoConfig := config.LocalConfigFile{Filepath: DemoConfig}
-			demoConfig.Load()
-			demoConfig.ToConfig()
+	if syncClock {
+		skewClock = query.NewSkewClock(cbClient.ServerTime, clockRefresh)
+		cbClient.Clock = skewClock
+	}
 
-			demoWallet := demoConfig.WarchestConfig.ToWallet()
+	if apiURL, ok := os.LookupEnv(CbAPIURL); ok {
+		log.Printf("CB_API_URL is set to: %s", apiURL)
+		cbClient.BaseURL = strings.TrimSuffix(apiURL, "/")
+	}
 
-			// TODO: Bandaid *hack* to update coins, instead the struct needs to be revisited so that copying
-			//       between structs is much easier
-			for coinSymbol, coin := range demoWallet.Coins {
-				coin.Update(cbAuth, absClient, demoMode)
-				warchestWallet.Coins[coinSymbol] = coin
-			}
+	return cbClient
+}
+
+// NewExchangeClient creates the coinbase exchange client used by the application from the environment, nil is
+// returned when exchange credentials aren't provided
+func NewExchangeClient() *query.ExchangeClient {
+	apiKey, keyOk := os.LookupEnv(CbExchangeAPIKey)
+	apiSecret, secretOk := os.LookupEnv(CbExchangeAPISecret)
+	if !(keyOk && secretOk) {
+		return nil
+	}
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	exchangeAuth := auth.ExchangeAuth{APIKey: apiKey, APISecret: apiSecret,
+		Passphrase: os.Getenv(CbExchangePassphrase)}
+	exchangeClient := query.NewExchangeClient(exchangeAuth, query.NewRetryClient(&client))
+
+	if apiURL, ok := os.LookupEnv(CbExchangeAPIURL); ok {
+		log.Printf("CB_EXCHANGE_API_URL is set to: %s", apiURL)
+		exchangeClient.BaseURL = strings.TrimSuffix(apiURL, "/")
+	}
+
+	// The exchange is signed against the same coinbase clock
+	exchangeClient.Clock = skewClock
+
+	// The exchange doesn't value coins deposited into it, coinbase's spot prices do
+	exchangeClient.Prices = cbClient
+
+	return exchangeClient
+}
+
+// NewKrakenClient creates the kraken client used by the application from the environment, nil is returned when
+// kraken credentials aren't provided
+func NewKrakenClient() *query.KrakenClient {
+	apiKey, keyOk := os.LookupEnv(KrakenAPIKey)
+	apiSecret, secretOk := os.LookupEnv(KrakenAPISecret)
+	if !(keyOk && secretOk) {
+		return nil
+	}
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	krakenClient := query.NewKrakenClient(auth.KrakenAuth{APIKey: apiKey, APISecret: apiSecret},
+		query.NewKrakenRetryClient(&client))
+
+	// Kraken's ledger doesn't value deposits and rewards, coinbase's spot prices do
+	krakenClient.Prices = cbClient
+
+	if apiURL, ok := os.LookupEnv(KrakenAPIURL); ok {
+		log.Printf("KRAKEN_API_URL is set to: %s", apiURL)
+		krakenClient.BaseURL = strings.TrimSuffix(apiURL, "/")
+	}
+
+	return krakenClient
+}
+
+// Providers lists the providers feeding the wallet, coinbase always comes first so that it quotes the rates
+func Providers() []query.Provider {
+	providers := []query.Provider{cbClient}
+	if exchangeClient := NewExchangeClient(); exchangeClient != nil {
+		providers = append(providers, exchangeClient)
+	}
+	if krakenClient := NewKrakenClient(); krakenClient != nil {
+		providers = append(providers, krakenClient)
+	}
+	return providers
+}
+
+// LoadWallet builds the wallet used by the application from every provider, or from the demo config in demo mode
+// TODO: this should take in a new flag to specify whether or not to use local config for the transaction
+//       base
+func LoadWallet(ctx context.Context) (*query.Wallet, error) {
+
+	demoMode := IsDemoMode()
+	log.Printf("Wallet is being loaded now")
+	defer SavePrices()
+
+	warchestWallet := &query.Wallet{Coins: map[string]query.WarchestCoin{},
+		Concurrency: walletConcurrency, Currency: baseCurrency, CostMethod: costMethod}
+
+	// Query Coinbase to build a Warchest Wallet
+	if !demoMode {
+		// Retreive coins for every provider, holdings of the same coin are aggregated
+		coins, skipped, err := query.GetWarchestCoins(ctx, providers, demoMode, walletConcurrency,
+			baseCurrency, costMethod, coinFilter)
+
+		// Coins that failed to update are still part of the wallet, the coins of failed providers are missing
+		var loadErrs query.LoadErrors
+		var updateErrs query.UpdateErrors
+		if errors.As(err, &loadErrs) {
+			log.Printf("Some providers failed to retrieve their coins: %s\n", err)
+			warchestWallet.SetProviderErrors(loadErrs.Providers)
+		} else if errors.As(err, &updateErrs) {
+			log.Printf("Some Warchest Coins failed to update: %s\n", err)
+		} else if err != nil {
+			log.Printf("Failed to retrieve Warchest Coins: %s\n", DescribeError(err))
+			return nil, err
+		}
+
+		log.Printf("There are %d coins in this wallet", len(coins))
+
+		skippedAccounts = skipped
+		warchestWallet.SetCoins(coins)
+		// Only use internal transactions to build wallet
+	} else {
+		demoConfig := config.LocalConfigFile{Filepath: DemoConfig}
+		demoConfig.Load()
+		demoConfig.ToConfig()
+
+		demoWallet := demoConfig.WarchestConfig.ToWallet()
+
+		// TODO: Bandaid *hack* to update coins, instead the struct needs to be revisited so that copying
+		//       between structs is much easier
+		coins := map[string]query.WarchestCoin{}
+		for coinSymbol, coin := range demoWallet.Coins {
+			coin.Currency = baseCurrency
+			coin.CostMethod = costMethod
+			coin.Update(ctx, cbClient, demoMode)
+			coins[coinSymbol] = coin
 		}
-	})
+		warchestWallet.SetCoins(coins)
+	}
 
-	warchestWallet.UpdateNetProfit(cbAuth, absClient, demoMode)
+	// The coins were just updated, only the totals had to be worked out
+	rateCache.LogStats()
+	return warchestWallet, nil
+}
 
-	return warchestWallet
+// RefreshWallet brings the transactions, rates and profit of the wallet's coins up to date from every provider
+func RefreshWallet(ctx context.Context, warchestWallet *query.Wallet) error {
+	_, err := warchestWallet.Refresh(ctx, providers, IsDemoMode())
+	rateCache.LogStats()
+	SavePrices()
+	return err
 }
 
-// GetWallet API Endpoint to retrieve a wallet
-func GetWallet(c *gin.Context) {
-	warchestWallet := GetWalletSingleton()
-	if warchestWallet == nil {
-		log.Printf("Warchest wallet must be instantiated at runtime prior to this call!")
-		c.IndentedJSON(http.StatusInternalServerError, warchestWallet)
+// SavePrices saves the spot prices retrieved while loading or refreshing the wallet, so the next run doesn't retrieve
+// them again
+func SavePrices() {
+	if cbClient == nil || cbClient.SpotPrices == nil {
+		return
+	}
+	if err := cbClient.SpotPrices.Save(); err != nil {
+		log.Printf("Failed saving spot prices to %s: %s", cbClient.SpotPrices.Filepath, err)
+	}
+}
+
+// DescribeError produces a user friendly description of an error returned while querying coinbase
+func DescribeError(err error) string {
+	switch {
+	case errors.Is(err, query.ErrExpiredTimestamp):
+		return "coinbase rejected the request timestamp, check that the system clock is correct or run with -sync-clock"
+	case errors.Is(err, query.ErrInvalidCredentials):
+		return "coinbase rejected the credentials, check CB_API_KEY and CB_API_SECRET"
+	case errors.Is(err, query.ErrRateLimited):
+		return "coinbase rate limited the request, try again later"
 	}
+	return err.Error()
+}
+
+// PrintCoins prints a table of every coin's holdings and performance in the base currency, sorted by symbol
+func PrintCoins(out io.Writer, wallet *query.Wallet) {
+	symbols := make([]string, 0, len(wallet.Coins))
+	for symbol := range wallet.Coins {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	fmt.Fprintf(out, "\tPrices and amounts in %s, ROI and share of the wallet in percent:\n", baseCurrency)
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "\tCoin\tAmount\tAvg Entry\tBreak-Even\tRate\tMarket Value\tROI\tShare\tRealized\t"+
+		"Unrealized\tTotal Return\tFees\t")
+	for _, symbol := range symbols {
+		coin := wallet.Coins[symbol]
+		fmt.Fprintf(table, "\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", symbol, coin.Amount,
+			coin.AverageEntryPrice.Round(8), coin.BreakEvenPrice.Round(8), coin.Rates.Rate(coin.BaseCurrency()).Round(8),
+			coin.MarketValue.StringFixed(2), coin.ROIPercent.StringFixed(2), coin.PortfolioShare.StringFixed(2),
+			coin.RealizedGain.StringFixed(2), coin.UnrealizedGain.StringFixed(2), coin.TotalReturn.StringFixed(2),
+			coin.Fees.StringFixed(2))
+	}
+	table.Flush()
+}
 
+// GetWallet API Endpoint to retrieve the latest snapshot of the wallet, which is refreshed in the background
+func GetWallet(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
 
+	warchestWallet, err := walletService.Wallet()
+	if err != nil {
+		log.Printf("Wallet requested before it was loaded: %s", err)
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Profit is after fees unless asked otherwise, ie. /api/wallet?fees=before
+	fees := !beforeFees
+	switch c.Query("fees") {
+	case "before":
+		fees = false
+	case "after":
+		fees = true
+	}
+	if !fees {
+		warchestWallet = warchestWallet.WithFees(false)
+	}
+
 	c.IndentedJSON(http.StatusOK, warchestWallet)
 }
 
+// GetStats API Endpoint to retrieve the application's statistics
+func GetStats(c *gin.Context) {
+	stats := gin.H{
+		"rate_cache": rateCache.Stats(),
+		"wallet":     walletService.Status(),
+	}
+	if skewClock != nil {
+		stats["clock"] = skewClock.Diagnostics()
+	}
+	c.IndentedJSON(http.StatusOK, stats)
+}
+
+// PrintDiagnostics prints the offset between the local clock and coinbase's clock, measuring it when clock skew
+// correction isn't enabled
+func PrintDiagnostics(ctx context.Context) {
+	clock := skewClock
+	if clock == nil {
+		clock = query.NewSkewClock(NewCoinbaseClient().ServerTime, clockRefresh)
+		clock.Sync(ctx)
+	}
+
+	diagnostics := clock.Diagnostics()
+	if diagnostics.LastError != "" {
+		fmt.Printf("Clock offset: failed measuring (%s)\n", diagnostics.LastError)
+		return
+	}
+	fmt.Printf("Clock offset: %s (measured at %s, correction enabled: %t)\n", diagnostics.Offset,
+		diagnostics.MeasuredAt.Format(time.RFC3339), syncClock)
+}
+
+// RunCommand runs the command given after the flags and returns the exit code, the only command is
+// report tax [--year YYYY] [--output FILE] which writes the tax report of the coins sold in the year as a CSV
+func RunCommand(ctx context.Context, args []string) int {
+	if len(args) < 2 || args[0] != "report" || args[1] != "tax" {
+		fmt.Printf("Unknown command %q, expected: report tax [--year YYYY] [--output FILE]\n", strings.Join(args, " "))
+		return InvalidCommandRC
+	}
+
+	taxFlags := flag.NewFlagSet("report tax", flag.ContinueOnError)
+	yearPtr := taxFlags.Int("year", time.Now().Year()-1, "the year coins were sold in, 0 reports every year")
+	outputPtr := taxFlags.String("output", "", "the file to write the CSV to (default: stdout)")
+	if err := taxFlags.Parse(args[2:]); err != nil {
+		return InvalidCommandRC
+	}
+
+	wallet, err := LoadWallet(ctx)
+	if err != nil {
+		fmt.Printf("Failed calculating the wallet: %s\n", DescribeError(err))
+		return FailedCalculatingWallet
+	}
+	if wallet.Partial {
+		fmt.Fprintf(os.Stderr, "NOTE: some coins are stale or failed to update, the report may be incomplete\n")
+	}
+
+	out := os.Stdout
+	if *outputPtr != "" {
+		file, err := os.Create(*outputPtr)
+		if err != nil {
+			fmt.Printf("Failed creating %s: %s\n", *outputPtr, err)
+			return FailedWritingReportRC
+		}
+		defer file.Close()
+		out = file
+	}
+
+	report := query.NewTaxReport(wallet.Coins, *yearPtr, baseCurrency)
+	log.Printf("Writing the tax report of %d sale(s) in %d by %s", len(report.Rows), *yearPtr, costMethod)
+	if err := report.WriteCSV(out); err != nil {
+		fmt.Printf("Failed writing the tax report: %s\n", err)
+		return FailedWritingReportRC
+	}
+	return 0
+}
+
 func setLogger() {
 	// TODO: Consider logrus in the future to get JSON based loggin
 	file, err := os.OpenFile(LogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
@@ -151,27 +464,53 @@ func main() {
 	serverPtr := flag.Bool("server", false, "whether or not to start server (default port: 8080)")
 	savePtr := flag.Bool("save", true, "whether or not to save a list of transactions")
 	transactionTypePtr := flag.String("transaction-type", "all", "the type of coin to parse transactions against")
+	rateTTLPtr := flag.Duration("rate-ttl", query.DefaultRateTTL, "how long exchange rates are cached for")
+	concurrencyPtr := flag.Int("concurrency", query.DefaultConcurrency, "the number of coins to update at the same time")
+	syncClockPtr := flag.Bool("sync-clock", false, "whether or not to correct request timestamps by coinbase's clock")
+	clockRefreshPtr := flag.Duration("clock-refresh", query.DefaultClockRefresh, "how often coinbase's clock is checked")
+	coinsPtr := flag.String("coins", "", "comma separated list of the only coins to include (default: all non-fiat coins)")
+	denyCoinsPtr := flag.String("deny-coins", "", "comma separated list of coins to exclude")
+	skipZeroBalancePtr := flag.Bool("skip-zero-balance", false, "whether or not to exclude accounts without coins")
+	skipInterestPtr := flag.Bool("skip-interest", false, "whether or not to exclude interest bearing accounts")
+	currencyPtr := flag.String("currency", query.DefaultCurrency, "the currency cost and profit are calculated in")
+	costMethodPtr := flag.String("cost-method", string(query.DefaultCostMethod),
+		"which lots sold coins are taken from: fifo, lifo, hifo, average or specific-id")
+	beforeFeesPtr := flag.Bool("before-fees", false, "whether or not to show cost and profit before transaction fees")
+	diagnosticsPtr := flag.Bool("diagnostics", false, "whether or not to print diagnostics such as the clock offset")
+	refreshPtr := flag.Duration("refresh-interval", query.DefaultRefreshInterval,
+		"how often the server refreshes the wallet")
 
 	// Parse the argument flags
 	flag.Parse()
+	walletConcurrency = *concurrencyPtr
+	rateCache.TTL = *rateTTLPtr
+	syncClock = *syncClockPtr
+	clockRefresh = *clockRefreshPtr
+	baseCurrency = strings.ToUpper(*currencyPtr)
+	method, err := query.ParseCostMethod(*costMethodPtr)
+	if err != nil {
+		fmt.Printf("Invalid -cost-method %q: %s\n", *costMethodPtr, err)
+		os.Exit(FailedLoadConfigRC)
+	}
+	costMethod = method
+	beforeFees = *beforeFeesPtr
+	coinFilter = query.CoinFilter{
+		Allow:               query.ParseCoinList(*coinsPtr),
+		Deny:                query.ParseCoinList(*denyCoinsPtr),
+		SkipZeroBalance:     *skipZeroBalancePtr,
+		SkipInterestBearing: *skipInterestPtr,
+	}
 
 	// Establish logger
 	setLogger()
 
-	// Setup Client to use
-	client := http.Client{
-		Timeout: time.Second * 10,
-	}
-	var absClient query.HTTPClient
-	absClient = &client
-
 	log.Println("Server enabled:", *serverPtr)
 	log.Println("Save enabled:", *savePtr)
 	log.Println("Transaction type:", *transactionTypePtr)
 
 	// Setup Application specifics
-	apiKey, keyOk := os.LookupEnv(CbAPIKey)
-	apiSecret, secretOk := os.LookupEnv(CbAPISecret)
+	_, keyOk := os.LookupEnv(CbAPIKey)
+	_, secretOk := os.LookupEnv(CbAPISecret)
 	_, configOk := os.LookupEnv(WarchestConfigEnv)
 	demoMode := IsDemoMode()
 	configOnly := false
@@ -190,9 +529,21 @@ func main() {
 		os.Exit(FailedRetrievingData)
 	}
 
+	cbClient = NewCoinbaseClient()
+	providers = Providers()
+
+	// Commands are run instead of the server or the wallet summary, ie. warchest report tax --year 2025
+	if flag.NArg() > 0 {
+		os.Exit(RunCommand(context.Background(), flag.Args()))
+	}
+
 	// Setup server
 	if *serverPtr {
 
+		// Load the wallet in the background, retrying until it succeeds, and keep it up to date from then on
+		walletService = query.NewWalletService(LoadWallet, RefreshWallet, *refreshPtr)
+		go walletService.Run(context.Background())
+
 		// Establish the static path, defaulting to public folder in current execution path
 		// NOTE: this is mostly used for testing/developing locally
 		staticPath, ok := os.LookupEnv(WarchestStaticPath)
@@ -219,26 +570,72 @@ func main() {
 		// Setup Basic call to retrieve wallet
 		router.GET("/api/wallet", GetWallet)
 
+		// Statistics such as rate cache hits and misses
+		router.GET("/api/stats", GetStats)
+
 		router.Run()
 	} else {
-		// Establish auth
-		cbAuth := auth.CBAuth{apiKey, apiSecret}
-
-		wallet := GetWalletSingleton()
+		ctx := context.Background()
+		wallet, err := LoadWallet(ctx)
+		if err != nil {
+			fmt.Printf("Failed calculating the wallet: %s\n", DescribeError(err))
+			os.Exit(FailedCalculatingWallet)
+		}
+		if beforeFees {
+			wallet = wallet.WithFees(false)
+		}
 
 		// Retrieve all available wallets for the account associated with the provided API Key
 		if demoMode {
 			fmt.Printf("There are %d Coins in the demo wallet: \n", len(wallet.Coins))
 		} else {
-			accountsResp, _ := query.CBRetrieveAccounts(cbAuth, absClient)
-			fmt.Printf("There are %d Accounts for coins, %d that are supported: \n", len(accountsResp.Accounts), len(wallet.Coins))
+			fmt.Printf("There are %d Coins in the wallet, %d account(s) were skipped: \n", len(wallet.Coins),
+				len(skippedAccounts))
+			for _, skippedAccount := range skippedAccounts {
+				fmt.Printf("\tSkipped %s %s account %s: %s\n", skippedAccount.Provider, skippedAccount.Symbol,
+					skippedAccount.AccountID, skippedAccount.Reason)
+			}
 		}
 
+		PrintCoins(os.Stdout, wallet)
+
 		for coinSymbol, coin := range wallet.Coins {
-			fmt.Printf("\t%s Net Profit: %.6f\n", coinSymbol, coin.Profit)
+			// Be upfront about figures that are out of date or missing
+			if coin.Status == query.StatusStale || coin.Status == query.StatusFailed {
+				fmt.Printf("\t\t%s is %s: %s\n", coinSymbol, coin.Status, coin.Error)
+			}
+
+			// Break down coins held in more than one account
+			if len(coin.Accounts) > 1 {
+				for _, account := range coin.Accounts {
+					fmt.Printf("\t\t%s account %s: %s %s, Net Profit: %s %s\n", account.Provider,
+						account.AccountID, account.Amount, coinSymbol, account.Profit.StringFixed(6), baseCurrency)
+				}
+			}
+		}
+
+		fmt.Printf("Total Market Value: %s %s\n", wallet.MarketValue.StringFixed(6), baseCurrency)
+		fmt.Printf("Total ROI: %s%%\n", wallet.ROIPercent.StringFixed(2))
+		fmt.Printf("Total Realized Gain: %s %s\n", wallet.RealizedGain.StringFixed(6), baseCurrency)
+		fmt.Printf("Total Unrealized Gain: %s %s\n", wallet.UnrealizedGain.StringFixed(6), baseCurrency)
+		fmt.Printf("Total Return: %s %s\n", wallet.TotalReturn.StringFixed(6), baseCurrency)
+		if wallet.Error != "" {
+			fmt.Printf("NOTE: the wallet is %s\n", wallet.Error)
+		}
+		if wallet.Partial {
+			fmt.Printf("NOTE: some coins are stale, failed to update or are missing, the totals are incomplete\n")
+		}
+		fmt.Printf("Total Fees Paid: %s %s\n", wallet.TotalFees.StringFixed(6), baseCurrency)
+		if wallet.BeforeFees {
+			fmt.Printf("NOTE: cost and profit are before fees, run without -before-fees to include them\n")
 		}
 
-		fmt.Printf("Total Net Profit: %.6f\n", wallet.NetProfit)
+		stats := rateCache.Stats()
+		fmt.Printf("Rate cache: %d hit(s), %d miss(es), %d shared\n", stats.Hits, stats.Misses, stats.Shared)
+
+		if *diagnosticsPtr {
+			PrintDiagnostics(ctx)
+		}
 	}
 }
 
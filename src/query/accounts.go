@@ -1,32 +1,18 @@
 package query
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"context"
+	"strconv"
 	"time"
-	"warchest/src/auth"
 )
 
 // CBAccountsURL is the path to the GET accounts API call
 const CBAccountsURL = "/v2/accounts"
 
 // CBAccountsResp is the response object returned by retreiving all accounts
-// TODO: need to handle pagination if there are that many accounts
 type CBAccountsResp struct {
-	Pagination struct {
-		EndingBefore         interface{} `json:"ending_before"`
-		StartingAfter        interface{} `json:"starting_after"`
-		PreviousEndingBefore interface{} `json:"previous_ending_before"`
-		NextStartingAfter    string      `json:"next_starting_after"`
-		Limit                int         `json:"limit"`
-		Order                string      `json:"order"`
-		PreviousURI          interface{} `json:"previous_uri"`
-		NextURI              string      `json:"next_uri"`
-	} `json:"pagination"`
-	Accounts []CBAccount `json:"data"`
+	Pagination CBPagination `json:"pagination"`
+	Accounts   []CBAccount  `json:"data"`
 }
 
 // CBAccount is an individual account object
@@ -47,7 +33,7 @@ type CBAccount struct {
 		Slug         string `json:"slug"`
 	} `json:"currency"`
 	Balance struct {
-		Amount   float64 `json:"amount,string"`
+		Amount   Decimal `json:"amount"`
 		Currency string  `json:"currency"`
 	} `json:"balance"`
 	CreatedAt        time.Time `json:"created_at"`
@@ -56,47 +42,81 @@ type CBAccount struct {
 	ResourcePath     string    `json:"resource_path"`
 	AllowDeposits    bool      `json:"allow_deposits"`
 	AllowWithdrawals bool      `json:"allow_withdrawals"`
+	Rewards          struct {
+		APY          string `json:"apy"`
+		FormattedAPY string `json:"formatted_apy"`
+		Label        string `json:"label"`
+	} `json:"rewards"`
 }
 
-// CBRetrieveAccounts is a function that will retrieve all accounts associated with the account
-func CBRetrieveAccounts(cbAuth auth.CBAuth, client HTTPClient) (CBAccountsResp, error) {
+// IsFiat determines if the account holds a fiat currency
+func (a *CBAccount) IsFiat() bool {
+	return a.Type == "fiat" || a.Currency.Type == "fiat"
+}
 
-	url := CBBaseURL + CBAccountsURL
-	authHeaders := cbAuth.NewAuthMap("GET", "", CBAccountsURL)
-	req, err := http.NewRequest("GET", url, nil)
+// IsInterestBearing determines if the account earns interest or rewards on its balance
+func (a *CBAccount) IsInterestBearing() bool {
+	apy, err := strconv.ParseFloat(a.Rewards.APY, 64)
+	return err == nil && apy > 0
+}
 
-	// Set auth headers
-	for key, value := range authHeaders {
-		log.Printf("Setting %s to %s", key, value)
-		req.Header.Add(key, value)
+// pagination returns the pagination object for the page of accounts
+func (r *CBAccountsResp) pagination() CBPagination {
+	return r.Pagination
+}
+
+// ToHolding will take a CBAccount and convert it into a Holding, the balance is rounded to the currency's exponent
+// when it's known
+func (a *CBAccount) ToHolding() Holding {
+	balance := a.Balance.Amount
+	if a.Currency.Exponent > 0 {
+		balance = balance.Round(a.Currency.Exponent)
 	}
 
-	// per https://developers.coinbase.com/api/v2?shell#versioning
-	t := time.Now()
-	utcDate := fmt.Sprintf("%d-%d-%d", t.Year(), t.Month(), t.Day())
-	req.Header.Add("CB-VERSION", utcDate)
+	return Holding{
+		Provider:        CBProviderName,
+		AccountID:       a.ID,
+		Symbol:          a.Currency.Code,
+		Balance:         balance,
+		Exponent:        a.Currency.Exponent,
+		Fiat:            a.IsFiat(),
+		InterestBearing: a.IsInterestBearing(),
+	}
+}
 
-	// Retrieve response
-	resp, err := client.Do(req)
+// Holdings will retrieve all accounts associated with the api key as holdings
+func (c *CoinbaseClient) Holdings(ctx context.Context) ([]Holding, error) {
 
+	accountsResp, err := c.RetrieveAccounts(ctx)
 	if err != nil {
-		log.Printf("Hit error on retrieval: %s", err)
-		return CBAccountsResp{}, ErrConnection
+		return []Holding{}, err
+	}
+
+	holdings := make([]Holding, 0, len(accountsResp.Accounts))
+	for _, account := range accountsResp.Accounts {
+		holdings = append(holdings, account.ToHolding())
 	}
-	defer resp.Body.Close()
+	return holdings, nil
+}
+
+// RetrieveAccounts will retrieve all accounts associated with the api key, following every page of the response
+func (c *CoinbaseClient) RetrieveAccounts(ctx context.Context) (CBAccountsResp, error) {
 
-	cResp := CBAccountsResp{}
-	bodyAsStr, err := io.ReadAll(resp.Body)
+	pages := []*CBAccountsResp{}
+	err := c.getPages(ctx, CBAccountsURL, func() cbPage {
+		page := &CBAccountsResp{}
+		pages = append(pages, page)
+		return page
+	})
 	if err != nil {
-		log.Printf("Failed to read body of error: %s", err)
-		return CBAccountsResp{}, ErrDecoding
+		return CBAccountsResp{}, err
 	}
 
-	if err := json.Unmarshal([]byte(bodyAsStr), &cResp); err != nil {
-		log.Printf("Body as string: \n%s\n", bodyAsStr)
-		log.Printf("Couldn't unmarshall: %s", err)
-		return CBAccountsResp{}, ErrOnUnmarshall
+	accountsResp := CBAccountsResp{}
+	for _, page := range pages {
+		accountsResp.Pagination = page.Pagination
+		accountsResp.Accounts = append(accountsResp.Accounts, page.Accounts...)
 	}
 
-	return cResp, err
+	return accountsResp, nil
 }
@@ -1,6 +1,8 @@
 package query
 
 import (
+	"context"
+	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/gock.v1"
 	"net/http"
@@ -9,15 +11,14 @@ import (
 	auth2 "warchest/src/auth"
 )
 
-func TestCBRetrieveAccounts(t *testing.T) {
+func TestRetrieveAccounts(t *testing.T) {
 
-	cbAuth := auth2.CBAuth{"TestKey", "TestSecret"}
+	cbAuth := auth2.CBAuth{APIKey: "TestKey", APISecret: "TestSecret"}
 	client := http.Client{
 		Timeout: time.Second * 10,
 	}
 
-	var absClient HTTPClient
-	absClient = &client
+	cb := NewCoinbaseClient(cbAuth, &client)
 
 	t.Run("Happy Path", func(t *testing.T) {
 		// Establish Mock
@@ -27,7 +28,7 @@ func TestCBRetrieveAccounts(t *testing.T) {
 			Reply(200).
 			BodyString(validJSON)
 
-		accountResp, err := CBRetrieveAccounts(cbAuth, absClient)
+		accountResp, err := cb.RetrieveAccounts(context.Background())
 
 		assert.Nil(t, err, "This is a happy path test, this shouldn't have had an err")
 		assert.Equal(t, "58542935-67b5-56e1-a3f9-42686e07fa40", accountResp.Accounts[0].ID, "should be the same")
@@ -35,15 +36,15 @@ func TestCBRetrieveAccounts(t *testing.T) {
 		assert.Equal(t, false, accountResp.Accounts[0].Primary, "should be the same")
 		assert.Equal(t, "vault", accountResp.Accounts[0].Type, "should be the same")
 		assert.Equal(t, "CTSI", accountResp.Accounts[0].Currency.Code, "should be the same")
-		assert.Equal(t, 0.00000000, accountResp.Accounts[0].Balance.Amount, "should be the same")
+		assert.Equal(t, NewDecimal(0), accountResp.Accounts[0].Balance.Amount, "should be the same")
 		assert.Equal(t, "CTSI", accountResp.Accounts[0].Balance.Currency, "should be the same")
 	})
 
 	t.Run("Rainy Day connectivity!", func(t *testing.T) {
 
-		mockClient := &MockClient{}
+		mockCB := NewCoinbaseClient(cbAuth, &MockClient{})
 
-		accounts, err := CBRetrieveAccounts(cbAuth, mockClient)
+		accounts, err := mockCB.RetrieveAccounts(context.Background())
 
 		// There should have been a connection error
 		assert.Equal(t, CBAccountsResp{}, accounts)
@@ -59,13 +60,50 @@ func TestCBRetrieveAccounts(t *testing.T) {
 			Reply(200).
 			BodyString(`[asdf,[],!}`)
 
-		accounts, err := CBRetrieveAccounts(cbAuth, absClient)
+		accounts, err := cb.RetrieveAccounts(context.Background())
 
 		// There should have been a connection error
 		assert.Equal(t, CBAccountsResp{}, accounts)
 		assert.Equal(t, ErrOnUnmarshall, err, "This call should have produced a JSON parse error")
 	})
 
+	t.Run("Multiple pages", func(t *testing.T) {
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", CBBaseURL+CBAccountsURL, "limit=25",
+			httpmock.NewStringResponder(200, accountsPageOneJSON))
+		httpmock.RegisterResponderWithQuery("GET", CBBaseURL+CBAccountsURL, "limit=25&starting_after=account-2",
+			httpmock.NewStringResponder(200, accountsPageTwoJSON))
+
+		accountResp, err := cb.RetrieveAccounts(context.Background())
+
+		assert.Nil(t, err, "all pages were mocked, this shouldn't have had an err")
+		assert.Equal(t, 3, len(accountResp.Accounts), "accounts from every page should be returned")
+		assert.Equal(t, "account-1", accountResp.Accounts[0].ID, "should be the same")
+		assert.Equal(t, "account-3", accountResp.Accounts[2].ID, "should be the same")
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "there should be one request per page")
+	})
+
+	t.Run("Configurable page size and page cap", func(t *testing.T) {
+
+		pagedCB := NewCoinbaseClient(cbAuth, &client)
+		pagedCB.PageLimit, pagedCB.MaxPages = 2, 1
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", CBBaseURL+CBAccountsURL, "limit=2",
+			httpmock.NewStringResponder(200, accountsPageOneJSON))
+
+		accounts, err := pagedCB.RetrieveAccounts(context.Background())
+
+		assert.Equal(t, ErrTooManyPages, err, "the second page should have exceeded the page cap")
+		assert.Equal(t, CBAccountsResp{}, accounts)
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), "no request should be made past the page cap")
+	})
+
 	// TODO: Still having problems forcing failure with io.ReadAll need to look into this
 	t.Run("Failure to read response body check", func(t *testing.T) {
 
@@ -76,11 +114,30 @@ func TestCBRetrieveAccounts(t *testing.T) {
 			Reply(200).
 			SetHeader("Content-Length", "1")
 
-		accounts, err := CBRetrieveAccounts(cbAuth, absClient)
+		accounts, err := cb.RetrieveAccounts(context.Background())
 
 		assert.NotNil(t, err, "This call should have produced a read error for the response body")
 		assert.Equal(t, CBAccountsResp{}, accounts)
 	})
 }
 
-const validJSON = `{"pagination":{"ending_before":null,"starting_after":null,"previous_ending_before":null,"next_starting_after":"8e52c96f-bda7-508a-8876-972158d5e5c4","limit":25,"order":"desc","previous_uri":null,"next_uri":"/v2/accounts?starting_after=8e52c96f-bda7-508a-8876-972158d5e5c4"},"data":[{"id":"58542935-67b5-56e1-a3f9-42686e07fa40","name":"My Vault","primary":false,"type":"vault","currency":{"code":"CTSI","name":"Cartesi","color":"#1A1B1D","sort_index":173,"exponent":8,"type":"crypto","address_regex":"^(?:0x)?[0-9a-fA-F]{40}$","asset_id":"test-some-thing-else","slug":"cartesi"},"balance":{"amount":"0.00000000","currency":"CTSI"},"created_at":"2021-10-20T23:34:36Z","updated_at":"2021-10-27T01:47:08Z","resource":"account","resource_path":"/v2/accounts/some-thing-really-long-and-annoying","allow_deposits":true,"allow_withdrawals":true}]}`
+const validJSON = `{"pagination":{"ending_before":null,"starting_after":null,"previous_ending_before":null,"next_starting_after":null,"limit":25,"order":"desc","previous_uri":null,"next_uri":null},"data":[{"id":"58542935-67b5-56e1-a3f9-42686e07fa40","name":"My Vault","primary":false,"type":"vault","currency":{"code":"CTSI","name":"Cartesi","color":"#1A1B1D","sort_index":173,"exponent":8,"type":"crypto","address_regex":"^(?:0x)?[0-9a-fA-F]{40}$","asset_id":"test-some-thing-else","slug":"cartesi"},"balance":{"amount":"0.00000000","currency":"CTSI"},"created_at":"2021-10-20T23:34:36Z","updated_at":"2021-10-27T01:47:08Z","resource":"account","resource_path":"/v2/accounts/some-thing-really-long-and-annoying","allow_deposits":true,"allow_withdrawals":true}]}`
+
+const accountsPageOneJSON = `{"pagination":{"next_starting_after":"account-2","limit":25,"order":"desc","next_uri":"/v2/accounts?limit=25&starting_after=account-2"},"data":[{"id":"account-1","name":"DOGE Wallet","type":"wallet","currency":{"code":"DOGE"},"balance":{"amount":"10.0","currency":"DOGE"}},{"id":"account-2","name":"SHIB Wallet","type":"wallet","currency":{"code":"SHIB"},"balance":{"amount":"20.0","currency":"SHIB"}}]}`
+
+const accountsPageTwoJSON = `{"pagination":{"next_starting_after":null,"limit":25,"order":"desc","next_uri":null},"data":[{"id":"account-3","name":"ETH Wallet","type":"wallet","currency":{"code":"ETH"},"balance":{"amount":"1.5","currency":"ETH"}}]}`
+
+func TestCBAccount_ToHolding(t *testing.T) {
+
+	account := CBAccount{ID: "shib-wallet"}
+	account.Currency.Code = "SHIB"
+	account.Balance.Amount = MustDecimal("123456789.1234567891")
+
+	account.Currency.Exponent = 8
+	assert.Equal(t, MustDecimal("123456789.12345679"), account.ToHolding().Balance,
+		"the balance should be rounded to the currency's smallest unit")
+	assert.Equal(t, 8, account.ToHolding().Exponent, "should be the same")
+
+	account.Currency.Exponent = 0
+	assert.Equal(t, account.Balance.Amount, account.ToHolding().Balance, "an unknown exponent should be left alone")
+}
@@ -0,0 +1,253 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"warchest/src/auth"
+)
+
+// CBVersion is the default API version sent with every request
+// Ref: https://developers.coinbase.com/api/v2#versioning
+const CBVersion = "2021-11-08"
+
+// CBUserAgent is the default user agent sent with every request
+const CBUserAgent = "warchest"
+
+// CBProviderName identifies coinbase as a Provider
+const CBProviderName = "coinbase"
+
+// CoinbaseClient is the client used for all coinbase API calls
+type CoinbaseClient struct {
+	BaseURL    string
+	HTTPClient HTTPClient
+	Auth       auth.CBAuth
+	Version    string
+	UserAgent  string
+	PageLimit  int
+	MaxPages   int
+
+	// RateCache is shared by every exchange rate lookup when set
+	RateCache *RateCache
+
+	// SpotPrices keeps historical spot prices when set
+	SpotPrices *SpotPriceCache
+
+	// Clock corrects the timestamp requests are signed with for clock skew when set
+	Clock *SkewClock
+
+	// trades keeps the completed buys and sells retrieved, keyed by path
+	tradesMu sync.Mutex
+	trades   map[string]CBTrade
+}
+
+// Name identifies coinbase as a Provider
+func (c *CoinbaseClient) Name() string {
+	return CBProviderName
+}
+
+// cbPage is implemented by every paginated coinbase response object
+type cbPage interface {
+	pagination() CBPagination
+}
+
+// NewCoinbaseClient creates a client for the production coinbase API using the provided auth and HTTPClient
+func NewCoinbaseClient(cbAuth auth.CBAuth, client HTTPClient) *CoinbaseClient {
+	return &CoinbaseClient{
+		BaseURL:    CBBaseURL,
+		HTTPClient: client,
+		Auth:       cbAuth,
+		Version:    CBVersion,
+		UserAgent:  CBUserAgent,
+		PageLimit:  CBPageLimit,
+		MaxPages:   CBMaxPages,
+	}
+}
+
+// newRequest builds a GET request for the given path, signing it when the call requires authentication
+func (c *CoinbaseClient) newRequest(ctx context.Context, path string, authenticated bool) (*http.Request, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set auth headers
+	if authenticated {
+		for key, value := range c.Auth.NewAuthMapAt("GET", "", path, c.now(ctx)) {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if c.Version != "" {
+		req.Header.Add("CB-VERSION", c.Version)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	return req, nil
+}
+
+// get retrieves the given path and unmarshalls the response body into respObj, unsuccessful responses are returned
+// as an *APIError
+func (c *CoinbaseClient) get(ctx context.Context, path string, authenticated bool, respObj interface{}) error {
+
+	build := func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, path, authenticated)
+		if err != nil {
+			log.Printf("Failed to build request for %s: %s", path, err)
+			return nil, ErrConnection
+		}
+		return req, nil
+	}
+
+	// Only signed requests can be rejected for their timestamp
+	clock := c.Clock
+	if !authenticated {
+		clock = nil
+	}
+
+	_, err := doSigned(ctx, CBProviderName, c.HTTPClient, clock, build, path, respObj)
+	return err
+}
+
+// now returns the time requests are signed with, corrected by the Clock when one is set
+func (c *CoinbaseClient) now(ctx context.Context) time.Time {
+	if c.Clock != nil {
+		return c.Clock.Now(ctx)
+	}
+	return time.Now()
+}
+
+// builderClient is implemented by HTTPClients that build the request again for every attempt, ie. RetryClient
+type builderClient interface {
+	DoBuilder(ctx context.Context, build RequestBuilder) (*http.Response, error)
+}
+
+// buildError is a failure to build a request, which is returned to the caller as is
+type buildError struct {
+	err error
+}
+
+func (b *buildError) Error() string {
+	return b.err.Error()
+}
+
+// send sends the request built by build, clients that retry build it again for every attempt
+func send(ctx context.Context, client HTTPClient, build RequestBuilder) (*http.Response, error) {
+	wrapped := func() (*http.Request, error) {
+		req, err := build()
+		if err != nil {
+			return nil, &buildError{err}
+		}
+		return req, nil
+	}
+
+	if retrier, ok := client.(builderClient); ok {
+		return retrier.DoBuilder(ctx, wrapped)
+	}
+	req, err := wrapped()
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// doRequest sends the request built by build and unmarshalls the response body into respObj, returning the response
+// headers. Unsuccessful responses are returned as an *APIError, and failures to build the request as build returned
+// them.
+func doRequest(ctx context.Context, client HTTPClient, build RequestBuilder, path string,
+	respObj interface{}) (http.Header, error) {
+
+	// Retrieve response
+	resp, err := send(ctx, client, build)
+	var buildErr *buildError
+	if errors.As(err, &buildErr) {
+		return nil, buildErr.err
+	}
+	if err != nil {
+		log.Printf("Hit error on retrieval of %s: %s", path, err)
+		// Cancellations and deadlines are reported as is so callers can tell them apart
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, ErrConnection
+	}
+	defer resp.Body.Close()
+
+	bodyAsStr, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Failed to read body of %s: %s", path, err)
+		return resp.Header, ErrDecoding
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := newAPIError(resp.StatusCode, path, bodyAsStr)
+		log.Printf("%s", apiErr)
+		return resp.Header, apiErr
+	}
+
+	if err := json.Unmarshal(bodyAsStr, respObj); err != nil {
+		log.Printf("Couldn't unmarshall %s: %s", path, err)
+		log.Printf("Body as string: \n%s\n", bodyAsStr)
+		return resp.Header, ErrOnUnmarshall
+	}
+
+	return resp.Header, nil
+}
+
+// doSigned sends the request built by build like doRequest, setting the provider of unsuccessful responses. When the
+// provider rejects the request's timestamp and a clock is set, the clock may have drifted since its offset was
+// measured, so it is measured again and the request is signed and sent once more.
+func doSigned(ctx context.Context, provider string, client HTTPClient, clock *SkewClock, build RequestBuilder,
+	path string, respObj interface{}) (http.Header, error) {
+
+	var signedAt time.Time
+	timedBuild := func() (*http.Request, error) {
+		req, err := build()
+		signedAt = time.Now()
+		return req, err
+	}
+
+	headers, err := doRequest(ctx, client, timedBuild, path, respObj)
+	if clock != nil && errors.Is(err, ErrExpiredTimestamp) {
+		log.Printf("%s rejected the timestamp for %s, measuring the clock offset again", provider, path)
+		if syncErr := clock.Resync(ctx, signedAt); syncErr == nil {
+			headers, err = doRequest(ctx, client, timedBuild, path, respObj)
+		}
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		apiErr.Provider = provider
+	}
+	return headers, err
+}
+
+// getPages retrieves every page of a paginated path, nextPage is called to provide the object each page is
+// unmarshalled into
+func (c *CoinbaseClient) getPages(ctx context.Context, path string, nextPage func() cbPage) error {
+
+	pagePath := cbFirstPage(path, c.PageLimit)
+
+	for numPages := 0; pagePath != ""; numPages++ {
+		if numPages >= c.MaxPages {
+			log.Printf("%s spans more than %d pages, giving up", path, c.MaxPages)
+			return ErrTooManyPages
+		}
+
+		page := nextPage()
+		if err := c.get(ctx, pagePath, true, page); err != nil {
+			return err
+		}
+		pagePath = cbNextPage(pagePath, page.pagination())
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"context"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestCoinbaseClient(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cbAuth := auth.CBAuth{APIKey: "TestKey", APISecret: "TestSecret"}
+
+	t.Run("Defaults", func(t *testing.T) {
+		cb := NewCoinbaseClient(cbAuth, &client)
+
+		assert.Equal(t, CBBaseURL, cb.BaseURL, "should default to production coinbase")
+		assert.Equal(t, CBVersion, cb.Version, "should be the same")
+		assert.Equal(t, CBUserAgent, cb.UserAgent, "should be the same")
+		assert.Equal(t, CBPageLimit, cb.PageLimit, "should be the same")
+		assert.Equal(t, CBMaxPages, cb.MaxPages, "should be the same")
+	})
+
+	t.Run("Custom base url and headers", func(t *testing.T) {
+		fakeURL := "http://localhost:9999"
+		cb := NewCoinbaseClient(cbAuth, &client)
+		cb.BaseURL = fakeURL
+		cb.Version = "2020-01-01"
+		cb.UserAgent = "warchest-test"
+
+		// Establish Mock, capturing the requests sent
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		requests := map[string]*http.Request{}
+		capture := func(body string) httpmock.Responder {
+			return func(req *http.Request) (*http.Response, error) {
+				requests[req.URL.Path] = req
+				return httpmock.NewStringResponse(200, body), nil
+			}
+		}
+		httpmock.RegisterResponder("GET", fakeURL+CBUserURL, capture(`{"data":{"id":"user-1"}}`))
+		httpmock.RegisterResponder("GET", fakeURL+CBExchangeRateURL, capture(`{"data":{"currency":"ETH","rates":{"USD":"1.0"}}}`))
+
+		userID, err := cb.RetrieveUserID(context.Background())
+		assert.Nil(t, err, "request should have been sent to the fake server")
+		assert.Equal(t, "user-1", userID, "should be the same")
+
+		_, err = cb.RetrieveCoinRates(context.Background(), "ETH")
+		assert.Nil(t, err, "request should have been sent to the fake server")
+
+		userReq := requests[CBUserURL]
+		assert.Equal(t, "2020-01-01", userReq.Header.Get("CB-VERSION"), "should be the same")
+		assert.Equal(t, "warchest-test", userReq.Header.Get("User-Agent"), "should be the same")
+		assert.Equal(t, "TestKey", userReq.Header.Get(auth.CBAccessKey), "authenticated calls should be signed")
+		assert.NotEmpty(t, userReq.Header.Get(auth.CBAccessSign), "authenticated calls should be signed")
+
+		ratesReq := requests[CBExchangeRateURL]
+		assert.Equal(t, "2020-01-01", ratesReq.Header.Get("CB-VERSION"), "should be the same")
+		assert.Empty(t, ratesReq.Header.Get(auth.CBAccessKey), "public calls should not be signed")
+	})
+
+	t.Run("Cancellation reaches the request", func(t *testing.T) {
+		cb := NewCoinbaseClient(cbAuth, &client)
+
+		// Establish Mock that never responds before the deadline
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", CBBaseURL+CBAccountsURL, func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := cb.RetrieveAccounts(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err, "the deadline should have been reported")
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, err = cb.RetrieveAccounts(ctx)
+		assert.Equal(t, context.Canceled, err, "the cancellation should have been reported")
+	})
+}
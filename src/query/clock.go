@@ -0,0 +1,173 @@
+package query
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// CBTimeURL is the url path for retrieving coinbase's server time
+const CBTimeURL = "/v2/time"
+
+// DefaultClockRefresh is how often the clock offset is measured again
+const DefaultClockRefresh = 15 * time.Minute
+
+// CBTimeResp is the unmarshalled response object for a server time request
+// Ref: https://developers.coinbase.com/api/v2#get-current-time
+type CBTimeResp struct {
+	Data struct {
+		ISO   time.Time `json:"iso"`
+		Epoch int64     `json:"epoch"`
+	} `json:"data"`
+}
+
+// ServerTime will return coinbase's current server time
+func (c *CoinbaseClient) ServerTime(ctx context.Context) (time.Time, error) {
+
+	timeResp := CBTimeResp{}
+	if err := c.get(ctx, CBTimeURL, false, &timeResp); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(timeResp.Data.Epoch, 0), nil
+}
+
+// ClockDiagnostics describes the offset between the local clock and coinbase's clock
+type ClockDiagnostics struct {
+	Offset        string    `json:"offset"`
+	OffsetSeconds float64   `json:"offset_seconds"`
+	MeasuredAt    time.Time `json:"measured_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// serverTimeFetcher retrieves the server's current time
+type serverTimeFetcher func(ctx context.Context) (time.Time, error)
+
+// SkewClock corrects the local clock by the offset to coinbase's clock, so requests are signed with a timestamp
+// coinbase accepts even when the local clock drifts. The offset is measured on first use and again every Refresh.
+type SkewClock struct {
+	Refresh time.Duration
+
+	fetch serverTimeFetcher
+	now   func() time.Time
+
+	// syncMu makes sure only one measurement is made at a time, mu guards the measurement itself
+	syncMu     sync.Mutex
+	mu         sync.Mutex
+	offset     time.Duration
+	measuredAt time.Time
+	lastErr    error
+}
+
+// NewSkewClock creates a clock that measures its offset with fetch every refresh
+func NewSkewClock(fetch serverTimeFetcher, refresh time.Duration) *SkewClock {
+	return &SkewClock{
+		Refresh: refresh,
+		fetch:   fetch,
+		now:     time.Now,
+	}
+}
+
+// Now returns the local time corrected by the measured offset, measuring the offset first when it is missing or
+// stale. A failed measurement keeps the previous offset.
+func (s *SkewClock) Now(ctx context.Context) time.Time {
+	if s.stale() {
+		s.syncStale(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now().Add(s.offset)
+}
+
+// stale determines if the offset needs to be measured
+func (s *SkewClock) stale() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.measuredAt.IsZero() || s.now().Sub(s.measuredAt) >= s.Refresh
+}
+
+// Sync measures the offset to the server's clock now, even when the offset isn't stale
+func (s *SkewClock) Sync(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	return s.measure(ctx)
+}
+
+// syncStale measures the offset if it is still stale once the measurement in progress (if any) finished, so that
+// requests waiting on a stale offset share a single measurement
+func (s *SkewClock) syncStale(ctx context.Context) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.stale() {
+		s.measure(ctx)
+	}
+}
+
+// Resync measures the offset again if it hasn't been measured since the given time, ie. after coinbase rejected a
+// timestamp signed before then
+func (s *SkewClock) Resync(ctx context.Context, since time.Time) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	s.mu.Lock()
+	measuredAt := s.measuredAt
+	s.mu.Unlock()
+	if measuredAt.After(since) {
+		return nil
+	}
+
+	return s.measure(ctx)
+}
+
+// measure retrieves the server time, the caller must hold syncMu
+func (s *SkewClock) measure(ctx context.Context) error {
+	before := s.now()
+	serverTime, err := s.fetch(ctx)
+	after := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+	if err != nil {
+		log.Printf("Failed measuring clock offset, keeping offset of %s: %s", s.offset, err)
+		// Don't retry on every request, wait for the next refresh
+		s.measuredAt = after
+		return err
+	}
+
+	// The server time was taken somewhere during the round trip, assume half way through
+	localTime := before.Add(after.Sub(before) / 2)
+	s.offset = serverTime.Sub(localTime).Round(time.Millisecond)
+	s.measuredAt = after
+	log.Printf("Clock offset to coinbase is %s", s.offset)
+
+	return nil
+}
+
+// Offset returns the measured offset between the local clock and the server's clock
+func (s *SkewClock) Offset() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset
+}
+
+// Diagnostics describes the current offset and when it was measured
+func (s *SkewClock) Diagnostics() ClockDiagnostics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	diagnostics := ClockDiagnostics{
+		Offset:        s.offset.String(),
+		OffsetSeconds: s.offset.Seconds(),
+		MeasuredAt:    s.measuredAt,
+	}
+	if s.lastErr != nil {
+		diagnostics.LastError = s.lastErr.Error()
+	}
+	return diagnostics
+}
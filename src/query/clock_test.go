@@ -0,0 +1,163 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestServerTime(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+CBTimeURL,
+		httpmock.NewStringResponder(200, `{"data":{"iso":"2015-06-23T18:02:51Z","epoch":1435082571}}`))
+
+	serverTime, err := cb.ServerTime(context.Background())
+
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, int64(1435082571), serverTime.Unix(), "should be the same")
+}
+
+func TestSkewClock(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Unix(1435082571, 0)
+
+	t.Run("Offset is measured once per refresh", func(t *testing.T) {
+		fetches := 0
+		skew := 45 * time.Second
+		clock := NewSkewClock(func(ctx context.Context) (time.Time, error) {
+			fetches++
+			return now.Add(skew), nil
+		}, time.Minute)
+		clock.now = func() time.Time { return now }
+
+		assert.Equal(t, now.Add(skew), clock.Now(ctx), "should be corrected by the offset")
+		assert.Equal(t, now.Add(skew), clock.Now(ctx), "should be corrected by the offset")
+		assert.Equal(t, 1, fetches, "the offset should only be measured once")
+		assert.Equal(t, skew, clock.Offset(), "should be the same")
+
+		now = now.Add(time.Minute)
+		skew = -10 * time.Second
+		assert.Equal(t, now.Add(skew), clock.Now(ctx), "should be corrected by the new offset")
+		assert.Equal(t, 2, fetches, "the offset should have been measured again")
+
+		diagnostics := clock.Diagnostics()
+		assert.Equal(t, "-10s", diagnostics.Offset, "should be the same")
+		assert.Equal(t, -10.0, diagnostics.OffsetSeconds, "should be the same")
+		assert.Equal(t, now, diagnostics.MeasuredAt, "should be the same")
+	})
+
+	t.Run("Concurrent requests share a measurement", func(t *testing.T) {
+		var fetches int32
+		release := make(chan struct{})
+		clock := NewSkewClock(func(ctx context.Context) (time.Time, error) {
+			atomic.AddInt32(&fetches, 1)
+			<-release
+			return now.Add(time.Second), nil
+		}, time.Minute)
+		clock.now = func() time.Time { return now }
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				clock.Now(ctx)
+			}()
+		}
+		// Let every request find the offset stale before the measurement finishes
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "the offset should only be measured once")
+		assert.Equal(t, time.Second, clock.Offset(), "should be the same")
+	})
+
+	t.Run("Failures keep the previous offset", func(t *testing.T) {
+		fail := false
+		clock := NewSkewClock(func(ctx context.Context) (time.Time, error) {
+			if fail {
+				return time.Time{}, ErrConnection
+			}
+			return now.Add(time.Minute), nil
+		}, time.Minute)
+		clock.now = func() time.Time { return now }
+
+		assert.Nil(t, clock.Sync(ctx), "should not fail")
+
+		fail = true
+		assert.Equal(t, ErrConnection, clock.Sync(ctx), "should be the same")
+		assert.Equal(t, time.Minute, clock.Offset(), "the previous offset should be kept")
+		assert.Equal(t, ErrConnection.Error(), clock.Diagnostics().LastError, "should be the same")
+	})
+}
+
+func TestCoinbaseClient_Clock(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{APIKey: "TestKey", APISecret: "TestSecret"}, &client)
+	cb.Clock = NewSkewClock(cb.ServerTime, time.Hour)
+	skew := 2 * time.Minute
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+CBTimeURL, func(req *http.Request) (*http.Response, error) {
+		epoch := time.Now().Add(skew).Unix()
+		return httpmock.NewStringResponse(200, `{"data":{"epoch":`+strconv.FormatInt(epoch, 10)+`}}`), nil
+	})
+
+	timestamps := []int64{}
+	userResponses := []httpmock.Responder{
+		httpmock.NewStringResponder(401, `{"errors":[{"id":"authentication_error","message":"request timestamp expired"}]}`),
+		httpmock.NewStringResponder(200, `{"data":{"id":"user-1"}}`),
+	}
+	httpmock.RegisterResponder("GET", CBBaseURL+CBUserURL, func(req *http.Request) (*http.Response, error) {
+		timestamp, _ := strconv.ParseInt(req.Header.Get(auth.CBAccessTimestamp), 10, 64)
+		timestamps = append(timestamps, timestamp)
+		respond := userResponses[0]
+		userResponses = userResponses[1:]
+		return respond(req)
+	})
+
+	userID, err := cb.RetrieveUserID(context.Background())
+
+	assert.Nil(t, err, "the rejected timestamp should have been retried")
+	assert.Equal(t, "user-1", userID, "should be the same")
+	assert.Equal(t, 2, httpmock.GetCallCountInfo()["GET "+CBBaseURL+CBTimeURL],
+		"the offset should have been measured again after the rejection")
+	assert.Equal(t, 2, len(timestamps), "should be the same")
+	for _, timestamp := range timestamps {
+		assert.InDelta(t, time.Now().Add(skew).Unix(), timestamp, 2, "should be signed with the corrected time")
+	}
+
+	t.Run("Unauthenticated calls don't need the clock", func(t *testing.T) {
+		unsynced := NewCoinbaseClient(auth.CBAuth{}, &MockClient{})
+		unsynced.Clock = NewSkewClock(func(ctx context.Context) (time.Time, error) {
+			return time.Time{}, errors.New("should not be called")
+		}, time.Hour)
+
+		_, err := unsynced.RetrieveCoinRates(context.Background(), "ETH")
+		assert.Equal(t, ErrConnection, err, "should be the same")
+		assert.Equal(t, "", unsynced.Clock.Diagnostics().LastError, "the clock should not have been used")
+	})
+}
@@ -0,0 +1,225 @@
+package query
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DecimalPlaces is the number of decimal places a Decimal keeps, enough for the smallest unit of any coin (ie. wei)
+const DecimalPlaces = 18
+
+// decimalScale is the number of units in 1
+var decimalScale = new(big.Int).Exp(big.NewInt(10), big.NewInt(DecimalPlaces), nil)
+
+// Decimal is an exact fixed-point number used for amounts, prices and profit, so that amounts like hundreds of
+// millions of SHIB at a fraction of a cent add up without rounding errors. Results with more than DecimalPlaces
+// decimal places (ie. a third) are rounded half away from zero. The zero value is 0, and a Decimal is never modified
+// once created so it can be copied freely.
+type Decimal struct {
+	// units is the value in 10^-DecimalPlaces, nil is 0 so that equal decimals are always equal structs
+	units *big.Int
+}
+
+// newDecimal creates a decimal from an amount of units
+func newDecimal(units *big.Int) Decimal {
+	if units.Sign() == 0 {
+		return Decimal{}
+	}
+	return Decimal{units: units}
+}
+
+// NewDecimal creates a decimal from a whole number
+func NewDecimal(value int64) Decimal {
+	return newDecimal(new(big.Int).Mul(big.NewInt(value), decimalScale))
+}
+
+// ParseDecimal parses a decimal from its string form, ie. "0.00001234" or "1.5e-3"
+func ParseDecimal(value string) (Decimal, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || strings.Contains(value, "/") {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	return newDecimal(divRound(new(big.Int).Mul(rat.Num(), decimalScale), rat.Denom())), nil
+}
+
+// MustDecimal parses a decimal that is known to be valid, it panics otherwise
+func MustDecimal(value string) Decimal {
+	decimal, err := ParseDecimal(value)
+	if err != nil {
+		panic(err)
+	}
+	return decimal
+}
+
+// NewDecimalFromFloat creates a decimal from the shortest representation of a float, NaN and infinities are 0
+func NewDecimalFromFloat(value float64) Decimal {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Decimal{}
+	}
+	decimal, _ := ParseDecimal(strconv.FormatFloat(value, 'f', -1, 64))
+	return decimal
+}
+
+// divRound divides x by y rounding half away from zero
+func divRound(x, y *big.Int) *big.Int {
+	quo, rem := new(big.Int).QuoRem(x, y, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(new(big.Int).Abs(y)) >= 0 {
+		if x.Sign() == y.Sign() {
+			quo.Add(quo, big.NewInt(1))
+		} else {
+			quo.Sub(quo, big.NewInt(1))
+		}
+	}
+	return quo
+}
+
+// value returns the decimal's units
+func (d Decimal) value() *big.Int {
+	if d.units == nil {
+		return new(big.Int)
+	}
+	return d.units
+}
+
+// Add returns d + other
+func (d Decimal) Add(other Decimal) Decimal {
+	return newDecimal(new(big.Int).Add(d.value(), other.value()))
+}
+
+// Sub returns d - other
+func (d Decimal) Sub(other Decimal) Decimal {
+	return newDecimal(new(big.Int).Sub(d.value(), other.value()))
+}
+
+// Mul returns d * other
+func (d Decimal) Mul(other Decimal) Decimal {
+	return newDecimal(divRound(new(big.Int).Mul(d.value(), other.value()), decimalScale))
+}
+
+// Div returns d / other, dividing by 0 returns 0
+func (d Decimal) Div(other Decimal) Decimal {
+	if other.IsZero() {
+		return Decimal{}
+	}
+	return newDecimal(divRound(new(big.Int).Mul(d.value(), decimalScale), other.value()))
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return newDecimal(new(big.Int).Neg(d.value()))
+}
+
+// Abs returns the absolute value of d
+func (d Decimal) Abs() Decimal {
+	return newDecimal(new(big.Int).Abs(d.value()))
+}
+
+// Cmp compares d and other, returning -1, 0 or +1 when d is less than, equal to or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	return d.value().Cmp(other.value())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d
+func (d Decimal) Sign() int {
+	return d.value().Sign()
+}
+
+// IsZero determines if d is 0
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Round returns d rounded half away from zero to the given number of decimal places (ie. a currency's exponent)
+func (d Decimal) Round(places int) Decimal {
+	if places >= DecimalPlaces || places < 0 {
+		return d
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(DecimalPlaces-places)), nil)
+	return newDecimal(new(big.Int).Mul(divRound(d.value(), factor), factor))
+}
+
+// Float64 returns the nearest float to d, for when exactness no longer matters
+func (d Decimal) Float64() float64 {
+	value, _ := new(big.Rat).SetFrac(d.value(), decimalScale).Float64()
+	return value
+}
+
+// String returns d in full without trailing zeros, ie. "0.00001234"
+func (d Decimal) String() string {
+	fixed := d.StringFixed(DecimalPlaces)
+	if strings.Contains(fixed, ".") {
+		fixed = strings.TrimRight(strings.TrimRight(fixed, "0"), ".")
+	}
+	return fixed
+}
+
+// StringFixed returns d rounded to the given number of decimal places, padded with zeros
+func (d Decimal) StringFixed(places int) string {
+	if places > DecimalPlaces {
+		places = DecimalPlaces
+	}
+	if places < 0 {
+		places = 0
+	}
+
+	units := d.Round(places).value()
+	digits := new(big.Int).Abs(units).String()
+	if len(digits) <= DecimalPlaces {
+		digits = strings.Repeat("0", DecimalPlaces-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-DecimalPlaces]
+	fraction := digits[len(digits)-DecimalPlaces:][:places]
+
+	sign := ""
+	if units.Sign() < 0 {
+		sign = "-"
+	}
+	if places == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// GoString shows d as the code creating it, so that %#v (ie. in test failures) shows the value instead of a pointer
+func (d Decimal) GoString() string {
+	return `query.MustDecimal("` + d.String() + `")`
+}
+
+// MarshalJSON writes d as a JSON number without losing any precision
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads d from a JSON number or string, coinbase and kraken send amounts as strings to keep them exact.
+// null and empty strings are 0.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*d = Decimal{}
+		return nil
+	}
+
+	value := string(data)
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return ErrInvalidDecimal
+		}
+		value = unquoted
+	}
+	if value == "" {
+		*d = Decimal{}
+		return nil
+	}
+
+	decimal, err := ParseDecimal(value)
+	if err != nil {
+		return err
+	}
+	*d = decimal
+	return nil
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+
+	parseTests := []struct {
+		value    string
+		expected string
+	}{
+		{"0.00001234", "0.00001234"},
+		{"1.50", "1.5"},
+		{"-0.5", "-0.5"},
+		{"1.5e-3", "0.0015"},
+		{" 42 ", "42"},
+		{"0", "0"},
+		{"0.0000000000000000005", "0.000000000000000001"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.value, func(t *testing.T) {
+			decimal, err := ParseDecimal(tt.value)
+			assert.Nil(t, err, "should not fail")
+			assert.Equal(t, tt.expected, decimal.String(), "should be the same")
+		})
+	}
+
+	for _, value := range []string{"", "abc", "1/3", "1.2.3"} {
+		t.Run("Invalid "+value, func(t *testing.T) {
+			_, err := ParseDecimal(value)
+			assert.Equal(t, ErrInvalidDecimal, err, "should be the same")
+		})
+	}
+}
+
+func TestDecimal_Arithmetic(t *testing.T) {
+
+	t.Run("Amounts add up exactly", func(t *testing.T) {
+		// Floats turn 0.1 + 0.2 into 0.30000000000000004
+		assert.Equal(t, MustDecimal("0.3"), MustDecimal("0.1").Add(MustDecimal("0.2")), "should be the same")
+
+		// Hundreds of millions of SHIB at a fraction of a cent
+		value := MustDecimal("123456789.123").Mul(MustDecimal("0.00001234"))
+		assert.Equal(t, "1523.45677777782", value.String(), "should be the same")
+
+		total := Decimal{}
+		for i := 0; i < 1000; i++ {
+			total = total.Add(value)
+		}
+		assert.Equal(t, "1523456.77777782", total.String(), "should be the same")
+	})
+
+	t.Run("Results are rounded half away from zero", func(t *testing.T) {
+		assert.Equal(t, "0.333333333333333333", NewDecimal(1).Div(NewDecimal(3)).String(), "should be the same")
+		assert.Equal(t, "0.666666666666666667", NewDecimal(2).Div(NewDecimal(3)).String(), "should be the same")
+		assert.Equal(t, "-0.666666666666666667", NewDecimal(-2).Div(NewDecimal(3)).String(), "should be the same")
+		assert.Equal(t, MustDecimal("2.35"), MustDecimal("2.345").Round(2), "should be the same")
+		assert.Equal(t, MustDecimal("-2.35"), MustDecimal("-2.345").Round(2), "should be the same")
+		assert.Equal(t, "1.00", MustDecimal("0.995").StringFixed(2), "should be the same")
+		assert.Equal(t, "-0.000001", MustDecimal("-0.0000005").StringFixed(6), "should be the same")
+	})
+
+	t.Run("Dividing by 0 is 0", func(t *testing.T) {
+		assert.True(t, NewDecimal(10).Div(Decimal{}).IsZero(), "should be 0")
+	})
+
+	t.Run("Equal decimals are equal values", func(t *testing.T) {
+		assert.Equal(t, Decimal{}, NewDecimal(5).Sub(NewDecimal(5)), "zero should always be the zero value")
+		assert.Equal(t, NewDecimal(5), MustDecimal("5.000"), "should be the same")
+		assert.Equal(t, 0, MustDecimal("1.10").Cmp(MustDecimal("1.1")), "should be the same")
+		assert.Equal(t, -1, MustDecimal("-3").Sign(), "should be the same")
+		assert.Equal(t, MustDecimal("3"), MustDecimal("-3").Abs(), "should be the same")
+	})
+
+	t.Run("Floats", func(t *testing.T) {
+		assert.Equal(t, MustDecimal("0.1"), NewDecimalFromFloat(0.1), "should use the shortest representation")
+		assert.Equal(t, 1523.45677777782, MustDecimal("1523.45677777782").Float64(), "should be the same")
+	})
+}
+
+func TestDecimal_JSON(t *testing.T) {
+
+	var amounts struct {
+		String Decimal `json:"string"`
+		Number Decimal `json:"number"`
+		Null   Decimal `json:"null"`
+		Empty  Decimal `json:"empty"`
+	}
+
+	err := json.Unmarshal([]byte(`{"string":"0.00001234","number":1523.45677777782,"null":null,"empty":""}`), &amounts)
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, MustDecimal("0.00001234"), amounts.String, "should be the same")
+	assert.Equal(t, MustDecimal("1523.45677777782"), amounts.Number, "the number shouldn't go through a float")
+	assert.True(t, amounts.Null.IsZero(), "null should be 0")
+	assert.True(t, amounts.Empty.IsZero(), "an empty string should be 0")
+
+	data, err := json.Marshal(amounts)
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, `{"string":0.00001234,"number":1523.45677777782,"null":0,"empty":0}`, string(data),
+		"should be the same")
+
+	assert.Equal(t, ErrInvalidDecimal, json.Unmarshal([]byte(`"abc"`), &amounts.String), "should be the same")
+}
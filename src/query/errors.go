@@ -0,0 +1,145 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrInvalidCredentials occurs when coinbase rejects the api key or signature of a request
+	ErrInvalidCredentials = Error("invalid api credentials")
+
+	// ErrExpiredTimestamp occurs when a request was signed more than 30 seconds before coinbase received it
+	ErrExpiredTimestamp = Error("request timestamp expired")
+
+	// ErrNotFound occurs when the requested resource doesn't exist
+	ErrNotFound = Error("resource not found")
+
+	// ErrRateLimited occurs when too many requests have been made with the api key
+	ErrRateLimited = Error("rate limit exceeded")
+
+	// ErrMissingTradeDetails occurs when the buy or sell behind some of the transactions couldn't be retrieved, the
+	// transactions are still returned with their fee left in their price
+	ErrMissingTradeDetails = Error("missing the fee of some buys or sells")
+
+	// ErrUnpricedTransactions occurs when some of the transactions couldn't be valued, the transactions are still
+	// returned without a value
+	ErrUnpricedTransactions = Error("missing the value of some transactions")
+
+	// ErrUnknownCurrency occurs when there's no exchange rate into the base currency
+	ErrUnknownCurrency = Error("no exchange rate for currency")
+
+	// ErrUnknownCostMethod occurs when a cost method isn't one of CostMethods
+	ErrUnknownCostMethod = Error("unknown cost method")
+
+	// ErrInvalidDecimal occurs when an amount or price isn't a valid decimal number
+	ErrInvalidDecimal = Error("invalid decimal")
+
+	// ErrWalletNotLoaded occurs when a wallet is requested before it was loaded for the first time
+	ErrWalletNotLoaded = Error("wallet hasn't been loaded yet")
+)
+
+// CBErrorResp is the error envelope returned by coinbase for unsuccessful requests
+// Ref: https://developers.coinbase.com/api/v2#errors
+type CBErrorResp struct {
+	Errors []CBError `json:"errors"`
+}
+
+// exchangeErrorResp is the error body returned by the coinbase exchange, which only includes a message
+// Ref: https://docs.cloud.coinbase.com/exchange/docs/requests#errors
+type exchangeErrorResp struct {
+	Message string `json:"message"`
+}
+
+// CBError is an individual error returned by coinbase
+type CBError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+}
+
+// APIError is returned when coinbase responds with an unsuccessful status code. The first error in coinbase's
+// error envelope (if any) is exposed through ID and Message.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	ID         string
+	Message    string
+	Path       string
+	Errors     []CBError
+}
+
+// newAPIError builds an APIError from an unsuccessful response's status code and body
+func newAPIError(statusCode int, path string, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode, Path: path}
+
+	// The body isn't guaranteed to be the error envelope (ie. proxies and load balancers)
+	errResp := CBErrorResp{}
+	if err := json.Unmarshal(body, &errResp); err == nil && len(errResp.Errors) > 0 {
+		apiErr.Errors = errResp.Errors
+		apiErr.ID = errResp.Errors[0].ID
+		apiErr.Message = errResp.Errors[0].Message
+		return apiErr
+	}
+
+	// The exchange only reports a message, keep it as an error so the message is still checked
+	exchangeResp := exchangeErrorResp{}
+	if err := json.Unmarshal(body, &exchangeResp); err == nil && exchangeResp.Message != "" {
+		apiErr.Errors = []CBError{{Message: exchangeResp.Message}}
+		apiErr.Message = exchangeResp.Message
+	}
+
+	return apiErr
+}
+
+// Error describes the failed request
+func (e *APIError) Error() string {
+	provider := e.Provider
+	if provider == "" {
+		provider = CBProviderName
+	}
+
+	if e.ID == "" && e.Message == "" {
+		return fmt.Sprintf("%s returned %d %s for %s", provider, e.StatusCode, http.StatusText(e.StatusCode), e.Path)
+	}
+	if e.ID == "" {
+		return fmt.Sprintf("%s returned %d for %s: %s", provider, e.StatusCode, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s returned %d for %s: %s (%s)", provider, e.StatusCode, e.Path, e.Message, e.ID)
+}
+
+// Is allows errors.Is to match an APIError against ErrInvalidCredentials, ErrExpiredTimestamp, ErrNotFound and
+// ErrRateLimited
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrExpiredTimestamp:
+		return e.isExpiredTimestamp()
+	case ErrInvalidCredentials:
+		if e.isExpiredTimestamp() {
+			return false
+		}
+		switch e.ID {
+		case "authentication_error", "invalid_token", "revoked_token", "expired_token", "invalid_signature":
+			return true
+		}
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.ID == "not_found" || e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.ID == "rate_limit_exceeded" || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// isExpiredTimestamp determines if coinbase rejected the request because of its CB-ACCESS-TIMESTAMP
+func (e *APIError) isExpiredTimestamp() bool {
+	for _, cbErr := range e.Errors {
+		message := strings.ToLower(cbErr.Message)
+		if strings.Contains(message, "timestamp") && strings.Contains(message, "expired") {
+			return true
+		}
+	}
+	return false
+}
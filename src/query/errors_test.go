@@ -0,0 +1,81 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestAPIError(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{APIKey: "TestKey", APISecret: "TestSecret"}, &client)
+
+	errorTests := []struct {
+		name       string
+		status     int
+		body       string
+		expectedID string
+		matches    error
+		notMatches []error
+	}{
+		{"Invalid credentials", 401, `{"errors":[{"id":"authentication_error","message":"invalid api key"}]}`,
+			"authentication_error", ErrInvalidCredentials, []error{ErrExpiredTimestamp, ErrNotFound, ErrRateLimited}},
+		{"Invalid signature", 401, `{"errors":[{"id":"invalid_signature","message":"invalid signature"}]}`,
+			"invalid_signature", ErrInvalidCredentials, []error{ErrExpiredTimestamp}},
+		{"Expired timestamp", 401, `{"errors":[{"id":"authentication_error","message":"request timestamp expired"}]}`,
+			"authentication_error", ErrExpiredTimestamp, []error{ErrInvalidCredentials, ErrNotFound}},
+		{"Not found", 404, `{"errors":[{"id":"not_found","message":"Not found"}]}`,
+			"not_found", ErrNotFound, []error{ErrInvalidCredentials, ErrRateLimited}},
+		{"Rate limited", 429, `{"errors":[{"id":"rate_limit_exceeded","message":"Too many requests"}]}`,
+			"rate_limit_exceeded", ErrRateLimited, []error{ErrInvalidCredentials, ErrNotFound}},
+		{"Exchange invalid credentials", 401, `{"message":"Invalid API Key"}`,
+			"", ErrInvalidCredentials, []error{ErrExpiredTimestamp, ErrNotFound}},
+		{"Exchange expired timestamp", 400, `{"message":"request timestamp expired"}`,
+			"", ErrExpiredTimestamp, []error{ErrInvalidCredentials, ErrNotFound}},
+		{"Not the error envelope", 401, `<html>Unauthorized</html>`,
+			"", ErrInvalidCredentials, []error{ErrExpiredTimestamp, ErrOnUnmarshall}},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Establish Mock
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			httpmock.RegisterResponder("GET", CBBaseURL+CBAccountsURL, httpmock.NewStringResponder(tt.status, tt.body))
+
+			accounts, err := cb.RetrieveAccounts(context.Background())
+			assert.Equal(t, CBAccountsResp{}, accounts, "an error response should not produce accounts")
+
+			var apiErr *APIError
+			assert.True(t, errors.As(err, &apiErr), "should have been an APIError")
+			assert.Equal(t, tt.status, apiErr.StatusCode, "should be the same")
+			assert.Equal(t, tt.expectedID, apiErr.ID, "should be the same")
+			assert.Equal(t, CBAccountsURL+"?limit=25", apiErr.Path, "should be the same")
+
+			assert.True(t, errors.Is(err, tt.matches), "should match %s", tt.matches)
+			for _, notMatch := range tt.notMatches {
+				assert.False(t, errors.Is(err, notMatch), "should not match %s", notMatch)
+			}
+		})
+	}
+
+	t.Run("Error message", func(t *testing.T) {
+		apiErr := newAPIError(404, "/v2/user", []byte(`{"errors":[{"id":"not_found","message":"Not found"}]}`))
+		assert.Equal(t, "coinbase returned 404 for /v2/user: Not found (not_found)", apiErr.Error())
+
+		apiErr = newAPIError(502, "/v2/user", []byte(`Bad Gateway`))
+		assert.Equal(t, "coinbase returned 502 Bad Gateway for /v2/user", apiErr.Error())
+
+		apiErr = newAPIError(401, "/fills", []byte(`{"message":"Invalid API Key"}`))
+		assert.Equal(t, "coinbase returned 401 for /fills: Invalid API Key", apiErr.Error())
+	})
+}
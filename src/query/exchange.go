@@ -0,0 +1,471 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"warchest/src/auth"
+)
+
+// ExchangeBaseURL is the default baseurl for all coinbase exchange (Advanced Trade) API calls
+const ExchangeBaseURL = "https://api.exchange.coinbase.com"
+
+// ExchangeAccountsURL is the path to the GET accounts exchange API call
+const ExchangeAccountsURL = "/accounts"
+
+// ExchangeFillsURL is the path to the GET fills exchange API call
+const ExchangeFillsURL = "/fills"
+
+// ExchangeTransfersURL is the path to the GET account transfers exchange API call, the deposits into and withdrawals
+// out of an account
+const ExchangeTransfersURL = "/accounts/:account_id/transfers"
+
+// ExchangeProductsURL is the path to the GET products exchange API call
+const ExchangeProductsURL = "/products"
+
+// ExchangeProductsRefresh is how long the list of products is kept before it is retrieved again
+const ExchangeProductsRefresh = 24 * time.Hour
+
+// ExchangeTickerURL is the path to the GET product ticker exchange API call
+const ExchangeTickerURL = "/products/:product_id/ticker"
+
+// ExchangeProviderName identifies the coinbase exchange as a Provider
+const ExchangeProviderName = "coinbase_exchange"
+
+// ExchangePageLimit is the default number of fills requested per page (the exchange allows 1-1000)
+const ExchangePageLimit = 100
+
+// ExchangeFiat is the default fiat currency coins are traded against on the exchange
+const ExchangeFiat = "USD"
+
+// ExchangeAfterHeader is the response header holding the cursor for the next (older) page of results
+// Ref: https://docs.cloud.coinbase.com/exchange/docs/pagination
+const ExchangeAfterHeader = "CB-AFTER"
+
+//
+// Response Objects
+////////////////////
+
+// ExchangeAccount is an individual exchange account, there is one per currency
+type ExchangeAccount struct {
+	ID             string  `json:"id"`
+	Currency       string  `json:"currency"`
+	Balance        Decimal `json:"balance"`
+	Hold           Decimal `json:"hold"`
+	Available      Decimal `json:"available"`
+	ProfileID      string  `json:"profile_id"`
+	TradingEnabled bool    `json:"trading_enabled"`
+}
+
+// ExchangeFill is a (partially) filled order, unlike wallet transactions the price and fee are always included
+// Ref: https://docs.cloud.coinbase.com/exchange/reference/exchangerestapi_getfills
+type ExchangeFill struct {
+	CreatedAt time.Time `json:"created_at"`
+	TradeID   int64     `json:"trade_id"`
+	ProductID string    `json:"product_id"`
+	OrderID   string    `json:"order_id"`
+	UserID    string    `json:"user_id"`
+	ProfileID string    `json:"profile_id"`
+	Liquidity string    `json:"liquidity"`
+	Price     Decimal   `json:"price"`
+	Size      Decimal   `json:"size"`
+	Fee       Decimal   `json:"fee"`
+	Side      string    `json:"side"`
+	Settled   bool      `json:"settled"`
+	USDVolume Decimal   `json:"usd_volume"`
+}
+
+// ExchangeTransfer is a deposit into or a withdrawal out of an exchange account, either from or to a coinbase account
+// (CoinbaseAccountID is set) or from or to another address
+// Ref: https://docs.cloud.coinbase.com/exchange/reference/exchangerestapi_getaccounttransfers
+type ExchangeTransfer struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CanceledAt  *time.Time `json:"canceled_at"`
+	Amount      Decimal    `json:"amount"`
+	Details     struct {
+		CoinbaseAccountID     string `json:"coinbase_account_id"`
+		CoinbaseTransactionID string `json:"coinbase_transaction_id"`
+		CryptoAddress         string `json:"crypto_address"`
+	} `json:"details"`
+}
+
+// ExchangeProduct is a market on the exchange, ie. DOGE-USD trades DOGE against USD
+type ExchangeProduct struct {
+	ID            string `json:"id"`
+	BaseCurrency  string `json:"base_currency"`
+	QuoteCurrency string `json:"quote_currency"`
+	Status        string `json:"status"`
+}
+
+// ExchangeTicker is the last trade of a product
+type ExchangeTicker struct {
+	TradeID int64     `json:"trade_id"`
+	Price   Decimal   `json:"price"`
+	Size    Decimal   `json:"size"`
+	Bid     Decimal   `json:"bid"`
+	Ask     Decimal   `json:"ask"`
+	Volume  Decimal   `json:"volume"`
+	Time    time.Time `json:"time"`
+}
+
+// ToCoinTransaction will take an ExchangeFill and convert it into a CoinTransaction. The fee is paid in fiat on top
+// of the purchased price, which like wallet buys and sells is the value of the coins before the fee.
+func (f *ExchangeFill) ToCoinTransaction() CoinTransaction {
+	subtotal := f.Price.Mul(f.Size)
+
+	transaction := CoinTransaction{
+		ID:             fmt.Sprintf("%s-%d", f.ProductID, f.TradeID),
+		Kind:           KindBuy,
+		Status:         StatusCompleted,
+		Provider:       ExchangeProviderName,
+		Currency:       f.QuoteCurrency(),
+		Timestamp:      f.CreatedAt,
+		NumCoins:       f.Size,
+		PurchasedPrice: subtotal,
+		TransactionFee: f.Fee,
+		Subtotal:       subtotal,
+		UnitPrice:      f.Price,
+	}
+
+	if f.Side == "sell" {
+		transaction.Kind = KindSell
+	}
+
+	// Unsettled fills can still be reversed
+	if !f.Settled {
+		transaction.Status = "pending"
+	}
+
+	return transaction
+}
+
+// ToCoinTransaction will take an ExchangeTransfer and convert it into a CoinTransaction. Coins moved from or to
+// coinbase (or another profile) are transfers between the user's own accounts, which move their lots along, while
+// coins moved from or to another address are received or sent.
+func (t *ExchangeTransfer) ToCoinTransaction() CoinTransaction {
+	ownAccount := t.Details.CoinbaseAccountID != "" || strings.HasPrefix(t.Type, "internal_")
+
+	transaction := CoinTransaction{
+		ID:        t.ID,
+		Kind:      KindUnknown,
+		Status:    "pending",
+		Provider:  ExchangeProviderName,
+		Timestamp: t.CreatedAt,
+		NumCoins:  t.Amount.Abs(),
+	}
+
+	switch {
+	case strings.HasSuffix(t.Type, "deposit") && ownAccount:
+		transaction.Kind = KindTransferIn
+	case strings.HasSuffix(t.Type, "deposit"):
+		transaction.Kind = KindReceive
+	case strings.HasSuffix(t.Type, "withdraw") && ownAccount:
+		transaction.Kind = KindTransferOut
+	case strings.HasSuffix(t.Type, "withdraw"):
+		transaction.Kind = KindSend
+	}
+
+	switch {
+	case t.CanceledAt != nil:
+		transaction.Status = "canceled"
+	case t.CompletedAt != nil:
+		transaction.Status = StatusCompleted
+	}
+
+	return transaction
+}
+
+// QuoteCurrency is the currency the fill was priced in, ie. USD for DOGE-USD
+func (f *ExchangeFill) QuoteCurrency() string {
+	return f.ProductID[strings.LastIndex(f.ProductID, "-")+1:]
+}
+
+//
+// Client
+////////////////////
+
+// ExchangeClient is the client used for all coinbase exchange (Advanced Trade) API calls
+type ExchangeClient struct {
+	BaseURL    string
+	HTTPClient HTTPClient
+	Auth       auth.ExchangeAuth
+	UserAgent  string
+	PageLimit  int
+	MaxPages   int
+
+	// Fiat is the currency rates are quoted in, ie. DOGE-USD
+	Fiat string
+
+	// Clock corrects the timestamp requests are signed with for clock skew when set
+	Clock *SkewClock
+
+	// Prices values the coins deposited into an account at their spot price on the day, they are left without a cost
+	// when unset
+	Prices SpotPricer
+
+	// products keeps the products listed on the exchange, until they are ExchangeProductsRefresh old
+	productsMu        sync.Mutex
+	products          []ExchangeProduct
+	productsFetchedAt time.Time
+}
+
+// NewExchangeClient creates a client for the production coinbase exchange API using the provided auth and HTTPClient
+func NewExchangeClient(exchangeAuth auth.ExchangeAuth, client HTTPClient) *ExchangeClient {
+	return &ExchangeClient{
+		BaseURL:    ExchangeBaseURL,
+		HTTPClient: client,
+		Auth:       exchangeAuth,
+		UserAgent:  CBUserAgent,
+		PageLimit:  ExchangePageLimit,
+		MaxPages:   CBMaxPages,
+		Fiat:       ExchangeFiat,
+	}
+}
+
+// newRequest builds a signed GET request for the given path, ErrInvalidCredentials is returned when the request
+// can't be signed with the api secret
+func (e *ExchangeClient) newRequest(ctx context.Context, path string) (*http.Request, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", e.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	signedAt := time.Now()
+	if e.Clock != nil {
+		signedAt = e.Clock.Now(ctx)
+	}
+
+	headers, err := e.Auth.NewAuthMapAt("GET", "", path, signedAt)
+	if err != nil {
+		log.Printf("Failed to sign request for %s: %s", path, err)
+		return nil, ErrInvalidCredentials
+	}
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
+
+	if e.UserAgent != "" {
+		req.Header.Set("User-Agent", e.UserAgent)
+	}
+
+	return req, nil
+}
+
+// get retrieves the given path and unmarshalls the response body into respObj, returning the response headers
+func (e *ExchangeClient) get(ctx context.Context, path string, respObj interface{}) (http.Header, error) {
+
+	build := func() (*http.Request, error) {
+		req, err := e.newRequest(ctx, path)
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Failed to build request for %s: %s", path, err)
+			return nil, ErrConnection
+		}
+		return req, nil
+	}
+
+	return doSigned(ctx, ExchangeProviderName, e.HTTPClient, e.Clock, build, path, respObj)
+}
+
+// RetrieveAccounts will retrieve all exchange accounts associated with the api key
+func (e *ExchangeClient) RetrieveAccounts(ctx context.Context) ([]ExchangeAccount, error) {
+
+	accounts := []ExchangeAccount{}
+	if _, err := e.get(ctx, ExchangeAccountsURL, &accounts); err != nil {
+		return []ExchangeAccount{}, err
+	}
+
+	return accounts, nil
+}
+
+// RetrieveProducts will return every product listed on the exchange, the list is only retrieved again once it is
+// ExchangeProductsRefresh old
+func (e *ExchangeClient) RetrieveProducts(ctx context.Context) ([]ExchangeProduct, error) {
+
+	e.productsMu.Lock()
+	defer e.productsMu.Unlock()
+
+	if e.products != nil && time.Since(e.productsFetchedAt) < ExchangeProductsRefresh {
+		return e.products, nil
+	}
+
+	products := []ExchangeProduct{}
+	if _, err := e.get(ctx, ExchangeProductsURL, &products); err != nil {
+		log.Printf("Failed retrieving exchange products: %s", err)
+		return []ExchangeProduct{}, err
+	}
+
+	e.products = products
+	e.productsFetchedAt = time.Now()
+	return products, nil
+}
+
+// RetrieveFills will return every fill for the given product (ie. DOGE-USD), following the CB-AFTER cursor through
+// every page of the response
+func (e *ExchangeClient) RetrieveFills(ctx context.Context, productID string) ([]ExchangeFill, error) {
+
+	fills := []ExchangeFill{}
+	firstPage := cbFirstPage(ExchangeFillsURL+"?product_id="+url.QueryEscape(productID), e.PageLimit)
+	err := e.getPages(ctx, firstPage, "fills for "+productID, func(pagePath string) (http.Header, int, error) {
+		page := []ExchangeFill{}
+		headers, err := e.get(ctx, pagePath, &page)
+		fills = append(fills, page...)
+		return headers, len(page), err
+	})
+	if err != nil {
+		return []ExchangeFill{}, err
+	}
+
+	return fills, nil
+}
+
+// RetrieveTransfers will return every deposit into and withdrawal out of the given exchange account, following the
+// CB-AFTER cursor through every page of the response
+func (e *ExchangeClient) RetrieveTransfers(ctx context.Context, accountID string) ([]ExchangeTransfer, error) {
+
+	transfers := []ExchangeTransfer{}
+	path := strings.Replace(ExchangeTransfersURL, ":account_id", url.PathEscape(accountID), -1)
+	firstPage := cbFirstPage(path, e.PageLimit)
+	err := e.getPages(ctx, firstPage, "transfers of "+accountID, func(pagePath string) (http.Header, int, error) {
+		page := []ExchangeTransfer{}
+		headers, err := e.get(ctx, pagePath, &page)
+		transfers = append(transfers, page...)
+		return headers, len(page), err
+	})
+	if err != nil {
+		return []ExchangeTransfer{}, err
+	}
+
+	return transfers, nil
+}
+
+// getPages retrieves firstPage and every older page after it by following the CB-AFTER cursor, retrievePage
+// retrieves a single page and returns its headers along with the number of results in it
+func (e *ExchangeClient) getPages(ctx context.Context, firstPage string, what string,
+	retrievePage func(pagePath string) (http.Header, int, error)) error {
+
+	for pagePath, numPages := firstPage, 0; pagePath != ""; numPages++ {
+		if numPages >= e.MaxPages {
+			log.Printf("The %s span more than %d pages, giving up", what, e.MaxPages)
+			return ErrTooManyPages
+		}
+
+		headers, results, err := retrievePage(pagePath)
+		if err != nil {
+			log.Printf("Failed retrieving %s: %s", what, err)
+			return err
+		}
+
+		// The last page is empty, or has no cursor
+		pagePath = ""
+		if after := headers.Get(ExchangeAfterHeader); after != "" && results > 0 {
+			pagePath = firstPage + "&after=" + url.QueryEscape(after)
+		}
+	}
+	return nil
+}
+
+// Name identifies the coinbase exchange as a Provider
+func (e *ExchangeClient) Name() string {
+	return ExchangeProviderName
+}
+
+// Holdings will retrieve all exchange accounts associated with the api key as holdings
+func (e *ExchangeClient) Holdings(ctx context.Context) ([]Holding, error) {
+
+	accounts, err := e.RetrieveAccounts(ctx)
+	if err != nil {
+		log.Printf("Failed to retrieve exchange accounts: %s", err)
+		return []Holding{}, err
+	}
+
+	holdings := make([]Holding, 0, len(accounts))
+	for _, account := range accounts {
+		holdings = append(holdings, Holding{
+			Provider:  ExchangeProviderName,
+			AccountID: account.ID,
+			Symbol:    account.Currency,
+			Balance:   account.Balance,
+			Fiat:      containsSymbol(fiatCurrencies, account.Currency),
+		})
+	}
+	return holdings, nil
+}
+
+// Transactions will return the fills of the holding's coin in every product it is traded in (ie. DOGE-USD and
+// DOGE-EUR), each priced in the product's quote currency, along with the coins deposited into and withdrawn out of
+// the holding's account. Coins deposited are valued at their spot price on the day.
+func (e *ExchangeClient) Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error) {
+
+	products, err := e.RetrieveProducts(ctx)
+	if err != nil {
+		return []CoinTransaction{}, err
+	}
+
+	fills := []ExchangeFill{}
+	for _, product := range products {
+		if !strings.EqualFold(product.BaseCurrency, holding.Symbol) {
+			continue
+		}
+
+		productFills, err := e.RetrieveFills(ctx, product.ID)
+		if err != nil {
+			return []CoinTransaction{}, err
+		}
+		fills = append(fills, productFills...)
+	}
+
+	transfers, err := e.RetrieveTransfers(ctx, holding.AccountID)
+	if err != nil {
+		return []CoinTransaction{}, err
+	}
+
+	log.Printf("There are %d exchange fills and %d transfers for %s\n", len(fills), len(transfers), holding.Symbol)
+
+	transactions := []CoinTransaction{}
+	for _, fill := range fills {
+		transactions = append(transactions, fill.ToCoinTransaction())
+	}
+
+	unpriced := 0
+	for _, transfer := range transfers {
+		transaction := transfer.ToCoinTransaction()
+		if transaction.IsCompleted() && (transaction.Kind == KindReceive || transaction.Kind == KindTransferIn) &&
+			!valueAtSpotPrice(ctx, e.Prices, holding.Symbol, e.Fiat, &transaction) {
+			log.Printf("Exchange transfer %s for %s has no fiat value", transfer.ID, holding.Symbol)
+			unpriced++
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if unpriced > 0 {
+		return transactions, ErrUnpricedTransactions
+	}
+	return transactions, nil
+}
+
+// Rates quotes the last traded price of a symbol against the client's Fiat currency, which is the only
+// currency quoted
+func (e *ExchangeClient) Rates(ctx context.Context, symbol string) (CoinRates, error) {
+
+	tickerPath := strings.Replace(ExchangeTickerURL, ":product_id", url.PathEscape(symbol+"-"+e.Fiat), -1)
+
+	ticker := ExchangeTicker{}
+	if _, err := e.get(ctx, tickerPath, &ticker); err != nil {
+		return CoinRates{}, err
+	}
+
+	return CoinRates{strings.ToUpper(e.Fiat): ticker.Price}, nil
+}
@@ -0,0 +1,416 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+// exchangeFixture is a test helper that responds with a recorded exchange response, setting the CB-AFTER cursor when
+// one is provided
+func exchangeFixture(t *testing.T, path, after string) httpmock.Responder {
+	byteValue, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "fixture should exist")
+
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewBytesResponse(200, byteValue)
+		if after != "" {
+			resp.Header.Set(ExchangeAfterHeader, after)
+		}
+		return resp, nil
+	}
+}
+
+func TestExchangeClient(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	exchangeAuth := auth.ExchangeAuth{
+		APIKey:     "TestKey",
+		APISecret:  base64.StdEncoding.EncodeToString([]byte("TestSecret")),
+		Passphrase: "TestPassphrase",
+	}
+	ex := NewExchangeClient(exchangeAuth, &client)
+	fillsURL := ExchangeBaseURL + ExchangeFillsURL
+
+	t.Run("Accounts", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeAccountsURL,
+			exchangeFixture(t, "./testdata/exchange_accounts.json", ""))
+
+		accounts, err := ex.RetrieveAccounts(context.Background())
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 3, len(accounts), "should be the same")
+		assert.Equal(t, "DOGE", accounts[0].Currency, "should be the same")
+		assert.Equal(t, NewDecimal(1200), accounts[0].Balance, "should be the same")
+	})
+
+	t.Run("Signed with the passphrase", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		var headers http.Header
+		httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeAccountsURL,
+			func(req *http.Request) (*http.Response, error) {
+				headers = req.Header
+				return httpmock.NewStringResponse(200, "[]"), nil
+			})
+
+		_, err := ex.RetrieveAccounts(context.Background())
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, "TestKey", headers.Get(auth.CBAccessKey), "should be the same")
+		assert.Equal(t, "TestPassphrase", headers.Get(auth.CBAccessPassphrase), "should be the same")
+		_, err = base64.StdEncoding.DecodeString(headers.Get(auth.CBAccessSign))
+		assert.Nil(t, err, "the signature should be base64 encoded")
+	})
+
+	t.Run("Secret isn't base64", func(t *testing.T) {
+		badEx := NewExchangeClient(auth.ExchangeAuth{APIKey: "TestKey", APISecret: "not base64!"}, &client)
+
+		accounts, err := badEx.RetrieveAccounts(context.Background())
+
+		assert.Empty(t, accounts, "no accounts should be returned")
+		assert.Equal(t, ErrInvalidCredentials, err, "should be the same")
+	})
+
+	t.Run("Fills across pages", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100",
+			exchangeFixture(t, "./testdata/exchange_fills_page1.json", "74320553"))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100&after=74320553",
+			exchangeFixture(t, "./testdata/exchange_fills_page2.json", "73500001"))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100&after=73500001",
+			httpmock.NewStringResponder(200, "[]"))
+
+		fills, err := ex.RetrieveFills(context.Background(), "DOGE-USD")
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 4, len(fills), "should be the same")
+		assert.Equal(t, 3, httpmock.GetTotalCallCount(), "the empty page should end pagination")
+		assert.Equal(t, int64(73500001), fills[3].TradeID, "should be the same")
+		assert.Equal(t, MustDecimal("2.5"), fills[3].Fee, "should be the same")
+	})
+
+	t.Run("Fills in every product", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeProductsURL,
+			exchangeFixture(t, "./testdata/exchange_products.json", ""))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100",
+			exchangeFixture(t, "./testdata/exchange_fills_page2.json", ""))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-EUR&limit=100",
+			httpmock.NewStringResponder(200, `[{"trade_id":12,"product_id":"DOGE-EUR","price":"0.4","size":"50",`+
+				`"fee":"0.1","side":"buy","settled":true}]`))
+		httpmock.RegisterResponderWithQuery("GET", ExchangeBaseURL+"/accounts/doge-account/transfers", "limit=100",
+			httpmock.NewStringResponder(200, "[]"))
+
+		productsEx := NewExchangeClient(exchangeAuth, &client)
+		holding := Holding{Provider: ExchangeProviderName, AccountID: "doge-account", Symbol: "DOGE"}
+		transactions, err := productsEx.Transactions(context.Background(), holding)
+		assert.Nil(t, err, "should not fail")
+		_, err = productsEx.Transactions(context.Background(), holding)
+		assert.Nil(t, err, "should not fail")
+
+		assert.Equal(t, 2, len(transactions), "should be the same")
+		assert.Equal(t, "USD", transactions[0].Currency, "should be the same")
+		assert.Equal(t, "EUR", transactions[1].Currency, "should be the same")
+		assert.Equal(t, NewDecimal(20), transactions[1].PurchasedPrice, "should be the same")
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+ExchangeBaseURL+ExchangeProductsURL],
+			"products should only be retrieved once")
+	})
+
+	t.Run("Transfers", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeProductsURL,
+			exchangeFixture(t, "./testdata/exchange_products.json", ""))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100",
+			httpmock.NewStringResponder(200, "[]"))
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-EUR&limit=100",
+			httpmock.NewStringResponder(200, "[]"))
+		httpmock.RegisterResponderWithQuery("GET", ExchangeBaseURL+"/accounts/doge-account/transfers", "limit=100",
+			exchangeFixture(t, "./testdata/exchange_transfers.json", ""))
+
+		transfersEx := NewExchangeClient(exchangeAuth, &client)
+		transfers, err := transfersEx.RetrieveTransfers(context.Background(), "doge-account")
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 4, len(transfers), "should be the same")
+		assert.Equal(t, "2b760113-fbba-5600-ac74-36482c130768", transfers[1].Details.CoinbaseAccountID,
+			"should be the same")
+
+		holding := Holding{Provider: ExchangeProviderName, AccountID: "doge-account", Symbol: "DOGE"}
+		transactions, err := transfersEx.Transactions(context.Background(), holding)
+		assert.Equal(t, ErrUnpricedTransactions, err, "deposits can't be valued without spot prices")
+		assert.Equal(t, 4, len(transactions), "should be the same")
+
+		transfersEx.Prices = stubSpotPricer{"DOGE": MustDecimal("0.4")}
+		transactions, err = transfersEx.Transactions(context.Background(), holding)
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, Decimal{}, transactions[0].PurchasedPrice, "coins withdrawn shouldn't be valued")
+		assert.Equal(t, NewDecimal(160), transactions[1].PurchasedPrice, "should be valued at the spot price")
+		assert.Equal(t, NewDecimal(40), transactions[2].PurchasedPrice, "should be valued at the spot price")
+		assert.Equal(t, Decimal{}, transactions[3].PurchasedPrice, "canceled deposits shouldn't be valued")
+		assert.Equal(t, "USD", transactions[1].Currency, "should be the same")
+	})
+
+	t.Run("Secret isn't base64 for fills", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		badEx := NewExchangeClient(auth.ExchangeAuth{APIKey: "TestKey", APISecret: "not base64!"}, &client)
+		fills, err := badEx.RetrieveFills(context.Background(), "DOGE-USD")
+
+		assert.Empty(t, fills, "no fills should be returned")
+		assert.Equal(t, ErrInvalidCredentials, err, "should be the same")
+		assert.Equal(t, 0, httpmock.GetTotalCallCount(), "unsigned requests shouldn't be sent")
+	})
+
+	t.Run("Bad base url", func(t *testing.T) {
+		badEx := NewExchangeClient(exchangeAuth, &client)
+		badEx.BaseURL = "://not a url"
+
+		accounts, err := badEx.RetrieveAccounts(context.Background())
+
+		assert.Empty(t, accounts, "no accounts should be returned")
+		assert.Equal(t, ErrConnection, err, "the credentials aren't at fault")
+	})
+
+	t.Run("Fills error", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100",
+			httpmock.NewStringResponder(401, `{"message":"invalid signature"}`))
+
+		fills, err := ex.RetrieveFills(context.Background(), "DOGE-USD")
+
+		assert.Empty(t, fills, "no fills should be returned")
+		assert.True(t, errors.Is(err, ErrInvalidCredentials), "should be an invalid credentials error")
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr), "should be an APIError")
+		assert.Equal(t, ExchangeProviderName, apiErr.Provider, "should be the same")
+		assert.Contains(t, err.Error(), ExchangeProviderName+" returned 401", "should be the same")
+	})
+}
+
+func TestExchangeFill_ToCoinTransaction(t *testing.T) {
+
+	fillTests := []struct {
+		name     string
+		fill     ExchangeFill
+		expected CoinTransaction
+	}{
+		{"Buy", ExchangeFill{TradeID: 1, ProductID: "DOGE-USD", Price: MustDecimal("0.5"), Size: NewDecimal(1000), Fee: MustDecimal("2.5"), Side: "buy",
+			Settled: true},
+			CoinTransaction{ID: "DOGE-USD-1", Kind: KindBuy, Status: StatusCompleted,
+				Provider: ExchangeProviderName, Currency: "USD", NumCoins: NewDecimal(1000),
+				PurchasedPrice: NewDecimal(500), TransactionFee: MustDecimal("2.5"), Subtotal: NewDecimal(500), UnitPrice: MustDecimal("0.5")}},
+		{"Sell", ExchangeFill{TradeID: 2, ProductID: "DOGE-USD", Price: MustDecimal("0.4"), Size: NewDecimal(300), Fee: MustDecimal("0.6"), Side: "sell",
+			Settled: true},
+			CoinTransaction{ID: "DOGE-USD-2", Kind: KindSell, Status: StatusCompleted,
+				Provider: ExchangeProviderName, Currency: "USD", NumCoins: NewDecimal(300),
+				PurchasedPrice: NewDecimal(120), TransactionFee: MustDecimal("0.6"), Subtotal: NewDecimal(120), UnitPrice: MustDecimal("0.4")}},
+		{"Unsettled", ExchangeFill{TradeID: 3, ProductID: "DOGE-USD", Price: NewDecimal(1), Size: NewDecimal(1), Fee: NewDecimal(0), Side: "buy"},
+			CoinTransaction{ID: "DOGE-USD-3", Kind: KindBuy, Status: "pending",
+				Provider: ExchangeProviderName, Currency: "USD", NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(1),
+				Subtotal: NewDecimal(1), UnitPrice: NewDecimal(1)}},
+	}
+
+	for _, tt := range fillTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := tt.fill.ToCoinTransaction()
+			assert.Equal(t, tt.expected, actual, "should be the same")
+		})
+	}
+}
+
+func TestExchangeTransfer_ToCoinTransaction(t *testing.T) {
+
+	created := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	completed := created.Add(time.Minute)
+	transfer := func(kind, coinbaseAccountID string, completedAt, canceledAt *time.Time) ExchangeTransfer {
+		transfer := ExchangeTransfer{ID: kind + "-1", Type: kind, CreatedAt: created, CompletedAt: completedAt,
+			CanceledAt: canceledAt, Amount: NewDecimal(10)}
+		transfer.Details.CoinbaseAccountID = coinbaseAccountID
+		return transfer
+	}
+	expected := func(kind TransactionKind, transferType, status string) CoinTransaction {
+		return CoinTransaction{ID: transferType + "-1", Kind: kind, Status: status, Provider: ExchangeProviderName,
+			Timestamp: created, NumCoins: NewDecimal(10)}
+	}
+
+	transferTests := []struct {
+		name     string
+		transfer ExchangeTransfer
+		expected CoinTransaction
+	}{
+		{"Deposited from coinbase", transfer("deposit", "coinbase-doge", &completed, nil),
+			expected(KindTransferIn, "deposit", StatusCompleted)},
+		{"Deposited from an address", transfer("deposit", "", &completed, nil),
+			expected(KindReceive, "deposit", StatusCompleted)},
+		{"Withdrawn to coinbase", transfer("withdraw", "coinbase-doge", &completed, nil),
+			expected(KindTransferOut, "withdraw", StatusCompleted)},
+		{"Withdrawn to an address", transfer("withdraw", "", &completed, nil),
+			expected(KindSend, "withdraw", StatusCompleted)},
+		{"Deposited from another profile", transfer("internal_deposit", "", &completed, nil),
+			expected(KindTransferIn, "internal_deposit", StatusCompleted)},
+		{"Withdrawn to another profile", transfer("internal_withdraw", "", &completed, nil),
+			expected(KindTransferOut, "internal_withdraw", StatusCompleted)},
+		{"Pending", transfer("deposit", "coinbase-doge", nil, nil),
+			expected(KindTransferIn, "deposit", "pending")},
+		{"Canceled", transfer("deposit", "coinbase-doge", nil, &completed),
+			expected(KindTransferIn, "deposit", "canceled")},
+	}
+
+	for _, tt := range transferTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := tt.transfer.ToCoinTransaction()
+			assert.Equal(t, tt.expected, actual, "should be the same")
+		})
+	}
+}
+
+func TestExchangeTransfer_MovesLots(t *testing.T) {
+
+	day := func(day int) time.Time {
+		return time.Date(2021, 5, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	// Coins bought on coinbase, moved to the exchange and sold there
+	buy := CoinTransaction{ID: "buy-1", Kind: KindBuy, Status: StatusCompleted, Provider: CBProviderName,
+		AccountID: "coinbase-doge", Timestamp: day(1), NumCoins: NewDecimal(100), PurchasedPrice: NewDecimal(10)}
+	deposit := CBTransaction{ID: "exchange-deposit-1", Type: "exchange_deposit", Status: StatusCompleted,
+		CreatedAt: day(2)}
+	deposit.Amount.Amount = NewDecimal(-60)
+	deposit.NativeAmount.Amount = NewDecimal(-30)
+	transferOut := deposit.ToCoinTransaction()
+	transferOut.Provider, transferOut.AccountID = CBProviderName, "coinbase-doge"
+
+	completed := day(2)
+	transfer := ExchangeTransfer{ID: "deposit-1", Type: "deposit", CreatedAt: day(2), CompletedAt: &completed,
+		Amount: NewDecimal(60)}
+	transfer.Details.CoinbaseAccountID = "coinbase-doge"
+	transferIn := transfer.ToCoinTransaction()
+	transferIn.AccountID, transferIn.PurchasedPrice = "exchange-doge", NewDecimal(30)
+
+	fill := ExchangeFill{TradeID: 1, ProductID: "DOGE-USD", CreatedAt: day(3), Price: MustDecimal("0.5"),
+		Size: NewDecimal(40), Side: "sell", Settled: true}
+	sell := fill.ToCoinTransaction()
+	sell.AccountID = "exchange-doge"
+
+	testCoin := WarchestCoin{Symbol: "DOGE", CostMethod: MethodFIFO, Rates: CoinRates{"USD": MustDecimal("0.5")},
+		Accounts: []CoinAccount{{Provider: CBProviderName, AccountID: "coinbase-doge"},
+			{Provider: ExchangeProviderName, AccountID: "exchange-doge"}},
+		Transactions: []CoinTransaction{sell, transferIn, transferOut, buy}}
+
+	testCoin.UpdateCost()
+
+	assert.Equal(t, KindTransferOut, transferOut.Kind, "should be the same")
+	assert.Equal(t, 1, len(testCoin.Disposals), "should be the same")
+	assert.Equal(t, "buy-1", testCoin.Disposals[0].LotID, "the sale should take the lot moved to the exchange")
+	assert.Equal(t, NewDecimal(4), testCoin.Disposals[0].Cost, "the lot should keep its cost")
+	assert.Equal(t, NewDecimal(60), testCoin.Amount, "the coins moved shouldn't be counted twice")
+	assert.Equal(t, NewDecimal(6), testCoin.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(40), testCoin.Accounts[0].Amount, "should be the same")
+	assert.Equal(t, NewDecimal(20), testCoin.Accounts[1].Amount, "should be the same")
+	assert.Equal(t, NewDecimal(2), testCoin.Accounts[1].Cost, "should be the same")
+}
+
+func TestExchangeClient_Provider(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	exchangeAuth := auth.ExchangeAuth{
+		APIKey:     "TestKey",
+		APISecret:  base64.StdEncoding.EncodeToString([]byte("TestSecret")),
+		Passphrase: "TestPassphrase",
+	}
+	ex := NewExchangeClient(exchangeAuth, &client)
+	fillsURL := ExchangeBaseURL + ExchangeFillsURL
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeAccountsURL,
+		exchangeFixture(t, "./testdata/exchange_accounts.json", ""))
+	httpmock.RegisterResponder("GET", ExchangeBaseURL+ExchangeProductsURL,
+		exchangeFixture(t, "./testdata/exchange_products.json", ""))
+	httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-EUR&limit=100",
+		httpmock.NewStringResponder(200, "[]"))
+	httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100",
+		exchangeFixture(t, "./testdata/exchange_fills_page1.json", "74320553"))
+	httpmock.RegisterResponderWithQuery("GET", fillsURL, "product_id=DOGE-USD&limit=100&after=74320553",
+		exchangeFixture(t, "./testdata/exchange_fills_page2.json", ""))
+	httpmock.RegisterResponderWithQuery("GET",
+		ExchangeBaseURL+"/accounts/71452118-efc7-4cc4-8780-a5e22d4baa53/transfers", "limit=100",
+		httpmock.NewStringResponder(200, "[]"))
+	httpmock.RegisterResponder("GET", ExchangeBaseURL+"/products/DOGE-USD/ticker",
+		httpmock.NewStringResponder(200, `{"trade_id":74400003,"price":"0.50000000","size":"10.00000000"}`))
+
+	coins, skipped, err := GetWarchestCoins(context.Background(), []Provider{ex}, false, 2, DefaultCurrency, DefaultCostMethod,
+		CoinFilter{SkipZeroBalance: true})
+
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, 1, len(coins), "fiat and zero balance accounts should be skipped")
+	assert.Equal(t, []SkippedAccount{
+		{Provider: ExchangeProviderName, AccountID: "e316cb9a-0808-4fd7-8914-97829c1925de", Symbol: "USD",
+			Reason: SkipFiat},
+		{Provider: ExchangeProviderName, AccountID: "e1ee6e4b-9a4f-4e56-b8b5-9a7a4d2a0f11", Symbol: "BTC",
+			Reason: SkipZeroBalance},
+	}, skipped, "should be the same")
+
+	doge := coins["DOGE"]
+	assert.Equal(t, []string{ExchangeProviderName}, doge.Providers, "should be the same")
+	assert.Equal(t, "71452118-efc7-4cc4-8780-a5e22d4baa53", doge.AccountID, "should be the same")
+	assert.Equal(t, 4, len(doge.Transactions), "should be the same")
+	assert.Equal(t, NewDecimal(1200), doge.Amount, "the pending fill should not count")
+	assert.Equal(t, MustDecimal("522.6"), doge.Cost, "should be the same")
+	assert.Equal(t, MustDecimal("3.85"), doge.Fees, "should be the same")
+	assert.Equal(t, MustDecimal("0.5"), doge.Rates["USD"], "should be quoted by the exchange")
+	assert.Equal(t, MustDecimal("0.5").Mul(NewDecimal(1200)).Sub(MustDecimal("522.6")), doge.Profit, "should be the same")
+}
+
+func TestWallet_MergeCoins(t *testing.T) {
+
+	wallet := Wallet{Coins: map[string]WarchestCoin{
+		"DOGE": {AccountID: "wallet-doge", Symbol: "DOGE", Providers: []string{CBProviderName},
+			Accounts:     []CoinAccount{{Provider: CBProviderName, AccountID: "wallet-doge"}},
+			Transactions: []CoinTransaction{{ID: "wallet-1"}}},
+	}}
+
+	wallet.MergeCoins(map[string]WarchestCoin{
+		"DOGE": {AccountID: "exchange-doge", Symbol: "DOGE", Providers: []string{ExchangeProviderName},
+			Accounts:     []CoinAccount{{Provider: ExchangeProviderName, AccountID: "exchange-doge"}},
+			Transactions: []CoinTransaction{{ID: "exchange-1"}}},
+		"SHIB": {AccountID: "exchange-shib", Symbol: "SHIB", Transactions: []CoinTransaction{{ID: "exchange-2"}}},
+	})
+
+	assert.Equal(t, "wallet-doge", wallet.Coins["DOGE"].AccountID, "the wallet account should be kept")
+	assert.Equal(t, []CoinTransaction{{ID: "wallet-1"}, {ID: "exchange-1"}}, wallet.Coins["DOGE"].Transactions,
+		"should be the same")
+	assert.Equal(t, []string{CBProviderName, ExchangeProviderName}, wallet.Coins["DOGE"].Providers,
+		"should be the same")
+	assert.Equal(t, []CoinAccount{{Provider: CBProviderName, AccountID: "wallet-doge"},
+		{Provider: ExchangeProviderName, AccountID: "exchange-doge"}}, wallet.Coins["DOGE"].Accounts,
+		"both accounts should be kept")
+	assert.Equal(t, "exchange-shib", wallet.Coins["SHIB"].AccountID, "should be the same")
+}
@@ -0,0 +1,104 @@
+package query
+
+import (
+	"strings"
+)
+
+// SkipReason describes why an account isn't included in the wallet
+type SkipReason string
+
+const (
+	// SkipFiat is a fiat currency account, which has no profit to calculate
+	SkipFiat SkipReason = "fiat currency"
+
+	// SkipDenied is an account for a coin on the deny list
+	SkipDenied SkipReason = "on the deny list"
+
+	// SkipNotAllowed is an account for a coin missing from the allow list
+	SkipNotAllowed SkipReason = "not on the allow list"
+
+	// SkipZeroBalance is an account that doesn't hold any coins
+	SkipZeroBalance SkipReason = "zero balance"
+
+	// SkipInterestBearing is an account earning interest or rewards
+	SkipInterestBearing SkipReason = "interest bearing"
+)
+
+// fiatCurrencies are the fiat currencies used to recognize exchange accounts, which aren't typed like wallet accounts
+var fiatCurrencies = []string{"USD", "EUR", "GBP", "CAD", "AUD", "JPY", "CHF"}
+
+// SkippedAccount is an account left out of the wallet and the reason why
+type SkippedAccount struct {
+	Provider  string     `json:"provider,omitempty"`
+	AccountID string     `json:"account_id"`
+	Symbol    string     `json:"symbol"`
+	Reason    SkipReason `json:"reason"`
+}
+
+// CoinFilter decides which accounts make up the wallet. The zero value includes every non-fiat account, setting Allow
+// only includes the listed coins, and Deny always excludes the listed coins. Fiat accounts are never included.
+type CoinFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// SkipZeroBalance excludes accounts that don't hold coins anymore, note this also hides their past profit
+	SkipZeroBalance bool `json:"skip_zero_balance"`
+
+	// SkipInterestBearing excludes accounts earning interest or rewards
+	SkipInterestBearing bool `json:"skip_interest_bearing"`
+}
+
+// ParseCoinList is a helper that turns a comma separated list of coins (ie. "doge, shib") into symbols
+func ParseCoinList(coins string) []string {
+	symbols := []string{}
+	for _, symbol := range strings.Split(coins, ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// containsSymbol is an internal helper that determines if the symbol is in the list, ignoring case
+func containsSymbol(symbols []string, symbol string) bool {
+	for _, listed := range symbols {
+		if strings.EqualFold(listed, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipHolding returns why the holding should be left out of the wallet, an empty reason includes it
+func (f *CoinFilter) SkipHolding(holding Holding) SkipReason {
+	switch {
+	case holding.Fiat:
+		return SkipFiat
+	case containsSymbol(f.Deny, holding.Symbol):
+		return SkipDenied
+	case len(f.Allow) > 0 && !containsSymbol(f.Allow, holding.Symbol):
+		return SkipNotAllowed
+	case f.SkipZeroBalance && holding.Balance.IsZero():
+		return SkipZeroBalance
+	case f.SkipInterestBearing && holding.InterestBearing:
+		return SkipInterestBearing
+	}
+	return ""
+}
+
+// FilterHoldings splits the holdings into the ones included in the wallet and the ones skipped
+func (f *CoinFilter) FilterHoldings(holdings []Holding) ([]Holding, []SkippedAccount) {
+	included := []Holding{}
+	skipped := []SkippedAccount{}
+
+	for _, holding := range holdings {
+		if reason := f.SkipHolding(holding); reason != "" {
+			skipped = append(skipped, SkippedAccount{Provider: holding.Provider, AccountID: holding.AccountID,
+				Symbol: holding.Symbol, Reason: reason})
+			continue
+		}
+		included = append(included, holding)
+	}
+
+	return included, skipped
+}
@@ -0,0 +1,75 @@
+package query
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCoinFilter(t *testing.T) {
+
+	byteValue, err := ioutil.ReadFile("./testdata/account_types.json")
+	assert.Nil(t, err, "fixture should exist")
+
+	var accountsResp CBAccountsResp
+	assert.Nil(t, json.Unmarshal(byteValue, &accountsResp), "fixture should be valid")
+
+	filterTests := []struct {
+		name     string
+		filter   CoinFilter
+		included []string
+		skipped  map[string]SkipReason
+	}{
+		{"All non-fiat accounts", CoinFilter{},
+			[]string{"DOGE", "SHIB", "ETH", "USDC"},
+			map[string]SkipReason{"USD": SkipFiat, "EUR": SkipFiat}},
+		{"Allow list", CoinFilter{Allow: []string{"doge", "SHIB", "USD"}},
+			[]string{"DOGE", "SHIB"},
+			map[string]SkipReason{"ETH": SkipNotAllowed, "USDC": SkipNotAllowed, "USD": SkipFiat, "EUR": SkipFiat}},
+		{"Deny list", CoinFilter{Deny: []string{"SHIB"}},
+			[]string{"DOGE", "ETH", "USDC"},
+			map[string]SkipReason{"SHIB": SkipDenied, "USD": SkipFiat, "EUR": SkipFiat}},
+		{"Deny wins over allow", CoinFilter{Allow: []string{"DOGE", "SHIB"}, Deny: []string{"DOGE"}},
+			[]string{"SHIB"},
+			map[string]SkipReason{"DOGE": SkipDenied, "ETH": SkipNotAllowed, "USDC": SkipNotAllowed, "USD": SkipFiat,
+				"EUR": SkipFiat}},
+		{"Zero balance and interest bearing", CoinFilter{SkipZeroBalance: true, SkipInterestBearing: true},
+			[]string{"DOGE", "SHIB"},
+			map[string]SkipReason{"ETH": SkipZeroBalance, "USDC": SkipInterestBearing, "USD": SkipFiat,
+				"EUR": SkipFiat}},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			holdings := []Holding{}
+			for _, account := range accountsResp.Accounts {
+				holdings = append(holdings, account.ToHolding())
+			}
+			included, skipped := tt.filter.FilterHoldings(holdings)
+
+			includedSymbols := []string{}
+			for _, holding := range included {
+				includedSymbols = append(includedSymbols, holding.Symbol)
+			}
+			skippedReasons := map[string]SkipReason{}
+			for _, skippedAccount := range skipped {
+				skippedReasons[skippedAccount.Symbol] = skippedAccount.Reason
+			}
+
+			assert.Equal(t, tt.included, includedSymbols, "should be the same")
+			assert.Equal(t, tt.skipped, skippedReasons, "should be the same")
+		})
+	}
+
+	t.Run("Deny is case insensitive", func(t *testing.T) {
+		filter := CoinFilter{Deny: []string{"doge"}}
+		assert.Equal(t, SkipDenied, filter.SkipHolding(Holding{Symbol: "DOGE"}), "should be the same")
+		assert.Equal(t, SkipReason(""), filter.SkipHolding(Holding{Symbol: "SHIB"}), "should be included")
+	})
+}
+
+func TestParseCoinList(t *testing.T) {
+	assert.Equal(t, []string{"DOGE", "SHIB"}, ParseCoinList(" doge, SHIB,,"), "should be the same")
+	assert.Equal(t, []string{}, ParseCoinList(""), "should be the same")
+}
@@ -0,0 +1,84 @@
+package query
+
+// TransactionKind describes what a transaction did to a coin's holdings
+type TransactionKind string
+
+const (
+	// KindBuy is a purchase of coins with fiat
+	KindBuy TransactionKind = "buy"
+
+	// KindSell is a sale of coins for fiat
+	KindSell TransactionKind = "sell"
+
+	// KindSend is coins sent out of the account
+	KindSend TransactionKind = "send"
+
+	// KindReceive is coins received into the account
+	KindReceive TransactionKind = "receive"
+
+	// KindTradeIn is coins received by converting another coin
+	KindTradeIn TransactionKind = "trade_in"
+
+	// KindTradeOut is coins given up by converting them into another coin
+	KindTradeOut TransactionKind = "trade_out"
+
+	// KindInterest is coins earned as interest
+	KindInterest TransactionKind = "interest"
+
+	// KindStakingReward is coins earned by staking
+	KindStakingReward TransactionKind = "staking_reward"
+
+	// KindReward is coins earned through other rewards (ie. airdrops, inflation and earn payouts)
+	KindReward TransactionKind = "reward"
+
+	// KindFiatDeposit is fiat deposited into the account
+	KindFiatDeposit TransactionKind = "fiat_deposit"
+
+	// KindFiatWithdrawal is fiat withdrawn from the account
+	KindFiatWithdrawal TransactionKind = "fiat_withdrawal"
+
+	// KindTransferOut is coins moved out of the account into another account of the same coin (ie. into a vault or
+	// staking), they take their lots along
+	KindTransferOut TransactionKind = "transfer_out"
+
+	// KindTransferIn is coins moved into the account from another account of the same coin, they keep the
+	// acquisition date and cost of the lots they were moved with
+	KindTransferIn TransactionKind = "transfer_in"
+
+	// KindUnknown is a transaction warchest doesn't know how to account for
+	KindUnknown TransactionKind = "unknown"
+)
+
+// StatusCompleted is the status of a transaction that has settled
+const StatusCompleted = "completed"
+
+// IsAcquisition determines if the transaction adds coins to the holdings. Transactions without a kind (ie. from the
+// config file) are treated as buys.
+func (k TransactionKind) IsAcquisition() bool {
+	switch k {
+	case "", KindBuy, KindReceive, KindTradeIn, KindInterest, KindStakingReward, KindReward:
+		return true
+	}
+	return false
+}
+
+// IsDisposal determines if the transaction removes coins from the holdings
+func (k TransactionKind) IsDisposal() bool {
+	switch k {
+	case KindSell, KindSend, KindTradeOut:
+		return true
+	}
+	return false
+}
+
+// IsSale determines if the disposal turns the coins into money or another coin, which realizes a gain or loss.
+// Coins sent elsewhere are still owned, so sending them doesn't.
+func (k TransactionKind) IsSale() bool {
+	return k == KindSell || k == KindTradeOut
+}
+
+// IsCompleted determines if the transaction has settled, transactions without a status (ie. from the config file)
+// are considered completed
+func (c *CoinTransaction) IsCompleted() bool {
+	return c.Status == "" || c.Status == StatusCompleted
+}
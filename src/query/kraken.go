@@ -0,0 +1,602 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"warchest/src/auth"
+)
+
+// KrakenBaseURL is the default baseurl for all kraken API calls
+const KrakenBaseURL = "https://api.kraken.com"
+
+// KrakenBalanceURL is the path to the POST balance kraken API call
+const KrakenBalanceURL = "/0/private/Balance"
+
+// KrakenLedgersURL is the path to the POST ledgers kraken API call
+const KrakenLedgersURL = "/0/private/Ledgers"
+
+// KrakenTickerURL is the path to the GET ticker kraken API call
+const KrakenTickerURL = "/0/public/Ticker"
+
+// KrakenProviderName identifies kraken as a Provider
+const KrakenProviderName = "kraken"
+
+// KrakenFiat is the default fiat currency coins are traded against on kraken
+const KrakenFiat = "USD"
+
+// KrakenRequestsPerSecond keeps requests under kraken's starter tier limit for private calls. Its counter of 15 decays
+// by 0.33 a second and a ledger request costs 2, so a ledger request can be made every 6 seconds.
+// Ref: https://docs.kraken.com/rest/#section/Rate-Limits/REST-API-Rate-Limits
+const KrakenRequestsPerSecond = 0.33 / 2
+
+// KrakenBurst is the number of requests that can be made back to back before the counter runs out
+const KrakenBurst = 7
+
+// KrakenLedgerRefresh is how long the ledger is shared between holdings before the entries added since are retrieved
+const KrakenLedgerRefresh = time.Minute
+
+// krakenAssetSymbols maps kraken's legacy asset codes to the symbols used everywhere else, newer assets already use
+// their symbol
+var krakenAssetSymbols = map[string]string{
+	"XXBT": "BTC", "XBT": "BTC", "XXDG": "DOGE", "XDG": "DOGE", "XETH": "ETH", "XETC": "ETC", "XLTC": "LTC",
+	"XMLN": "MLN", "XREP": "REP", "XXLM": "XLM", "XXMR": "XMR", "XXRP": "XRP", "XZEC": "ZEC", "ZUSD": "USD",
+	"ZEUR": "EUR", "ZGBP": "GBP", "ZCAD": "CAD", "ZAUD": "AUD", "ZJPY": "JPY", "ZCHF": "CHF",
+}
+
+// krakenFiatAssets are the fiat asset codes trades are paired against
+var krakenFiatAssets = []string{"ZUSD", "ZEUR", "ZGBP", "ZCAD", "ZAUD", "ZJPY", "ZCHF"}
+
+// krakenTransferSubtypes are the ledger subtypes of coins moved between spot, staking and futures
+var krakenTransferSubtypes = []string{"spottostaking", "stakingfromspot", "stakingtospot", "spotfromstaking",
+	"spottofutures", "spotfromfutures", "migration", "allocation", "deallocation", "autoallocation"}
+
+// krakenSymbol converts a kraken asset code into its symbol, staked and earning assets (ie. DOT.S) are reported as
+// interest bearing
+func krakenSymbol(asset string) (string, bool) {
+	interestBearing := false
+	if i := strings.LastIndex(asset, "."); i > 0 {
+		asset = asset[:i]
+		interestBearing = true
+	}
+
+	if symbol, ok := krakenAssetSymbols[asset]; ok {
+		return symbol, interestBearing
+	}
+	return asset, interestBearing
+}
+
+// krakenPairName converts a symbol into the name kraken uses for it in pairs (ie. DOGE is XDG)
+func krakenPairName(symbol string) string {
+	switch strings.ToUpper(symbol) {
+	case "BTC":
+		return "XBT"
+	case "DOGE":
+		return "XDG"
+	}
+	return strings.ToUpper(symbol)
+}
+
+//
+// Response Objects
+////////////////////
+
+// KrakenResp is the envelope of every kraken response, kraken reports errors in the body rather than the status code
+type KrakenResp struct {
+	Error  []string        `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+// KrakenLedgerResp is the result of a ledgers request
+type KrakenLedgerResp struct {
+	Ledger map[string]KrakenLedgerEntry `json:"ledger"`
+	Count  int                          `json:"count"`
+}
+
+// KrakenLedgerEntry is a single change to the balance of an asset, a trade produces an entry for each asset traded
+// sharing the same RefID
+// Ref: https://docs.kraken.com/rest/#tag/Account-Data/operation/getLedgers
+type KrakenLedgerEntry struct {
+	ID      string  `json:"-"`
+	RefID   string  `json:"refid"`
+	Time    float64 `json:"time"`
+	Type    string  `json:"type"`
+	Subtype string  `json:"subtype"`
+	AClass  string  `json:"aclass"`
+	Asset   string  `json:"asset"`
+	Amount  Decimal `json:"amount"`
+	Fee     Decimal `json:"fee"`
+	Balance Decimal `json:"balance"`
+}
+
+// Timestamp converts the entry's unix time into a time.Time
+func (e *KrakenLedgerEntry) Timestamp() time.Time {
+	seconds, fraction := math.Modf(e.Time)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC()
+}
+
+// KrakenTicker is the ticker of a single pair, only the last trade closed is used
+type KrakenTicker struct {
+	Close []string `json:"c"`
+}
+
+//
+// Client
+////////////////////
+
+// KrakenClient is the client used for all kraken API calls
+type KrakenClient struct {
+	BaseURL    string
+	HTTPClient HTTPClient
+	Auth       auth.KrakenAuth
+	UserAgent  string
+	MaxPages   int
+
+	// Fiat is the currency prices are quoted in, ie. XDGUSD
+	Fiat string
+
+	// Prices values deposits, rewards and trades against other coins at their spot price on the day when set, kraken's
+	// ledger doesn't include their value
+	Prices SpotPricer
+
+	mu        sync.Mutex
+	lastNonce int64
+
+	// ledger keeps every entry retrieved so far oldest first, so the ledger is retrieved once for every holding
+	ledgerMu        sync.Mutex
+	ledger          []KrakenLedgerEntry
+	ledgerFetchedAt time.Time
+}
+
+// NewKrakenClient creates a client for the production kraken API using the provided auth and HTTPClient
+func NewKrakenClient(krakenAuth auth.KrakenAuth, client HTTPClient) *KrakenClient {
+	return &KrakenClient{
+		BaseURL:    KrakenBaseURL,
+		HTTPClient: client,
+		Auth:       krakenAuth,
+		UserAgent:  CBUserAgent,
+		MaxPages:   CBMaxPages,
+		Fiat:       KrakenFiat,
+	}
+}
+
+// NewKrakenRetryClient wraps the given HTTPClient with retries and a rate limit suited to kraken, which responds to
+// rate limited requests with a 200 and reports the error in its envelope
+func NewKrakenRetryClient(client HTTPClient) *RetryClient {
+	retryClient := NewRetryClient(&krakenRateLimitClient{Client: client})
+	retryClient.Limiter = NewHostLimiter(KrakenRequestsPerSecond, KrakenBurst)
+	return retryClient
+}
+
+// krakenRateLimitClient is an HTTPClient that reports rate limited kraken responses as a 429, so that they are retried
+// like any other rate limited request
+type krakenRateLimitClient struct {
+	Client HTTPClient
+}
+
+// Do sends the request, turning responses whose envelope reports a rate limit into a 429
+func (c *krakenRateLimitClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	krakenResp := KrakenResp{}
+	if err := json.Unmarshal(body, &krakenResp); err == nil && len(krakenResp.Error) > 0 &&
+		errors.Is(newKrakenError(req.URL.Path, krakenResp.Error), ErrRateLimited) {
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Status = http.StatusText(http.StatusTooManyRequests)
+	}
+	return resp, nil
+}
+
+// nextNonce returns a nonce greater than every nonce before it, kraken rejects requests that reuse one
+func (k *KrakenClient) nextNonce() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	nonce := time.Now().UnixNano() / int64(time.Microsecond)
+	if nonce <= k.lastNonce {
+		nonce = k.lastNonce + 1
+	}
+	k.lastNonce = nonce
+	return strconv.FormatInt(nonce, 10)
+}
+
+// post signs and sends a private request, unmarshalling the result into respObj. Every attempt is signed with a new
+// nonce, kraken rejects a nonce it has already seen.
+func (k *KrakenClient) post(ctx context.Context, path string, form url.Values, respObj interface{}) error {
+
+	build := func() (*http.Request, error) {
+		nonce := k.nextNonce()
+		form.Set("nonce", nonce)
+		postData := form.Encode()
+
+		headers, err := k.Auth.NewAuthMap(path, nonce, postData)
+		if err != nil {
+			log.Printf("Failed to sign request for %s: %s", path, err)
+			return nil, ErrInvalidCredentials
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", k.BaseURL+path, strings.NewReader(postData))
+		if err != nil {
+			log.Printf("Failed to build request for %s: %s", path, err)
+			return nil, ErrConnection
+		}
+		for key, value := range headers {
+			req.Header.Add(key, value)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return k.withUserAgent(req), nil
+	}
+
+	return k.do(ctx, build, path, respObj)
+}
+
+// get sends a public request, unmarshalling the result into respObj
+func (k *KrakenClient) get(ctx context.Context, path string, respObj interface{}) error {
+
+	build := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", k.BaseURL+path, nil)
+		if err != nil {
+			log.Printf("Failed to build request for %s: %s", path, err)
+			return nil, ErrConnection
+		}
+		return k.withUserAgent(req), nil
+	}
+
+	return k.do(ctx, build, path, respObj)
+}
+
+// withUserAgent sets the client's user agent on the request
+func (k *KrakenClient) withUserAgent(req *http.Request) *http.Request {
+	if k.UserAgent != "" {
+		req.Header.Set("User-Agent", k.UserAgent)
+	}
+	return req
+}
+
+// do sends the request built by build and unwraps kraken's response envelope, errors in the envelope are returned as
+// an *APIError
+func (k *KrakenClient) do(ctx context.Context, build RequestBuilder, path string, respObj interface{}) error {
+
+	krakenResp := KrakenResp{}
+	if _, err := doSigned(ctx, KrakenProviderName, k.HTTPClient, nil, build, path, &krakenResp); err != nil {
+		return err
+	}
+
+	if len(krakenResp.Error) > 0 {
+		apiErr := newKrakenError(path, krakenResp.Error)
+		log.Printf("%s", apiErr)
+		return apiErr
+	}
+
+	if err := json.Unmarshal(krakenResp.Result, respObj); err != nil {
+		log.Printf("Couldn't unmarshall %s result: %s", path, err)
+		return ErrOnUnmarshall
+	}
+
+	return nil
+}
+
+// newKrakenError builds an APIError from the errors in kraken's response envelope, kraken responds with a 200 so the
+// status coinbase would have used is assigned instead
+// Ref: https://docs.kraken.com/rest/#section/General-Usage/Requests-Responses-and-Errors
+func newKrakenError(path string, messages []string) *APIError {
+	apiErr := &APIError{Provider: KrakenProviderName, StatusCode: http.StatusBadRequest, Path: path}
+	for _, message := range messages {
+		apiErr.Errors = append(apiErr.Errors, CBError{Message: message})
+	}
+	apiErr.Message = messages[0]
+
+	switch {
+	case strings.Contains(apiErr.Message, "Invalid key"), strings.Contains(apiErr.Message, "Invalid signature"),
+		strings.Contains(apiErr.Message, "Permission denied"):
+		apiErr.StatusCode = http.StatusUnauthorized
+	case strings.Contains(apiErr.Message, "Rate limit exceeded"), strings.Contains(apiErr.Message, "Too many requests"):
+		apiErr.StatusCode = http.StatusTooManyRequests
+	case strings.Contains(apiErr.Message, "Unknown asset"):
+		apiErr.StatusCode = http.StatusNotFound
+	}
+
+	return apiErr
+}
+
+// RetrieveBalances will return the balance of every asset held, keyed by kraken asset code
+func (k *KrakenClient) RetrieveBalances(ctx context.Context) (map[string]Decimal, error) {
+
+	balanceResp := map[string]string{}
+	if err := k.post(ctx, KrakenBalanceURL, url.Values{}, &balanceResp); err != nil {
+		return map[string]Decimal{}, err
+	}
+
+	balances := map[string]Decimal{}
+	for asset, balance := range balanceResp {
+		amount, err := ParseDecimal(balance)
+		if err != nil {
+			log.Printf("Couldn't parse %s balance %q: %s", asset, balance, err)
+			return map[string]Decimal{}, ErrOnUnmarshall
+		}
+		balances[asset] = amount
+	}
+	return balances, nil
+}
+
+// RetrieveLedger will return every ledger entry for the given asset codes oldest first, following the offset through
+// every page of the response. Every asset is included when none are given.
+func (k *KrakenClient) RetrieveLedger(ctx context.Context, assets []string) ([]KrakenLedgerEntry, error) {
+	return k.retrieveLedger(ctx, assets, "")
+}
+
+// Ledger will return every ledger entry oldest first, shared by every holding. The ledger is retrieved once, and once
+// it is KrakenLedgerRefresh old only the entries added since are retrieved. On failure the entries retrieved before
+// are kept for the next attempt.
+func (k *KrakenClient) Ledger(ctx context.Context) ([]KrakenLedgerEntry, error) {
+	k.ledgerMu.Lock()
+	defer k.ledgerMu.Unlock()
+
+	if !k.ledgerFetchedAt.IsZero() && time.Since(k.ledgerFetchedAt) < KrakenLedgerRefresh {
+		return k.ledger, nil
+	}
+
+	start := ""
+	if len(k.ledger) > 0 {
+		start = k.ledger[len(k.ledger)-1].ID
+	}
+
+	entries, err := k.retrieveLedger(ctx, nil, start)
+	if err != nil {
+		return []KrakenLedgerEntry{}, err
+	}
+
+	k.ledger = mergeLedger(k.ledger, entries)
+	k.ledgerFetchedAt = time.Now()
+	return k.ledger, nil
+}
+
+// mergeLedger adds the entries not seen before to the end of the ledger, returning a new ledger
+func mergeLedger(ledger, entries []KrakenLedgerEntry) []KrakenLedgerEntry {
+	seen := map[string]bool{}
+	merged := make([]KrakenLedgerEntry, 0, len(ledger)+len(entries))
+	for _, entry := range ledger {
+		seen[entry.ID] = true
+		merged = append(merged, entry)
+	}
+	for _, entry := range entries {
+		if !seen[entry.ID] {
+			merged = append(merged, entry)
+		}
+	}
+	return merged
+}
+
+// retrieveLedger is an internal helper that retrieves the ledger entries after start (a ledger ID, every entry when
+// empty) for the given asset codes oldest first
+func (k *KrakenClient) retrieveLedger(ctx context.Context, assets []string, start string) ([]KrakenLedgerEntry,
+	error) {
+
+	entries := []KrakenLedgerEntry{}
+	for numPages := 0; ; numPages++ {
+		if numPages >= k.MaxPages {
+			log.Printf("Ledger for %s spans more than %d pages, giving up", assets, k.MaxPages)
+			return []KrakenLedgerEntry{}, ErrTooManyPages
+		}
+
+		form := url.Values{}
+		if len(assets) > 0 {
+			form.Set("asset", strings.Join(assets, ","))
+		}
+		if start != "" {
+			form.Set("start", start)
+		}
+		form.Set("ofs", strconv.Itoa(len(entries)))
+
+		page := KrakenLedgerResp{}
+		if err := k.post(ctx, KrakenLedgersURL, form, &page); err != nil {
+			log.Printf("Failed retrieving ledger for %s: %s", assets, err)
+			return []KrakenLedgerEntry{}, err
+		}
+
+		for id, entry := range page.Ledger {
+			entry.ID = id
+			entries = append(entries, entry)
+		}
+
+		if len(page.Ledger) == 0 || len(entries) >= page.Count {
+			break
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Time == entries[j].Time {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].Time < entries[j].Time
+	})
+	return entries, nil
+}
+
+// Name identifies kraken as a Provider
+func (k *KrakenClient) Name() string {
+	return KrakenProviderName
+}
+
+// Holdings will retrieve the balances associated with the api key as holdings, kraken doesn't have accounts so the
+// asset code (ie. XXDG or DOT.S) identifies the holding
+func (k *KrakenClient) Holdings(ctx context.Context) ([]Holding, error) {
+
+	balances, err := k.RetrieveBalances(ctx)
+	if err != nil {
+		return []Holding{}, err
+	}
+
+	holdings := []Holding{}
+	for asset, balance := range balances {
+		// Fee credits aren't a currency
+		if asset == "KFEE" {
+			continue
+		}
+
+		symbol, interestBearing := krakenSymbol(asset)
+		holdings = append(holdings, Holding{
+			Provider:        KrakenProviderName,
+			AccountID:       asset,
+			Symbol:          symbol,
+			Balance:         balance,
+			Fiat:            containsSymbol(fiatCurrencies, symbol),
+			InterestBearing: interestBearing,
+		})
+	}
+
+	sort.Slice(holdings, func(i, j int) bool {
+		return holdings[i].AccountID < holdings[j].AccountID
+	})
+	return holdings, nil
+}
+
+// Transactions will return the ledger of a holding as CoinTransactions. Trades are priced by the fiat side of the
+// trade, while deposits, rewards and trades against other coins are valued at their spot price on the day. When a
+// spot price isn't available the transaction is still returned without a value, along with ErrUnpricedTransactions.
+func (k *KrakenClient) Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error) {
+
+	// The ledger is shared by every holding, and includes the fiat side of every trade needed to price it
+	entries, err := k.Ledger(ctx)
+	if err != nil {
+		return []CoinTransaction{}, err
+	}
+
+	byRefID := map[string][]KrakenLedgerEntry{}
+	for _, entry := range entries {
+		byRefID[entry.RefID] = append(byRefID[entry.RefID], entry)
+	}
+
+	transactions := []CoinTransaction{}
+	unpriced := 0
+	for _, entry := range entries {
+		if entry.Asset != holding.AccountID {
+			continue
+		}
+		transaction := entry.toCoinTransaction(byRefID[entry.RefID])
+		// Transfers in are valued too, for coins moved from an account that isn't a holding (ie. futures)
+		if transaction.PurchasedPrice.IsZero() && (transaction.Kind.IsAcquisition() ||
+			transaction.Kind == KindTradeOut || transaction.Kind == KindTransferIn) {
+			if !valueAtSpotPrice(ctx, k.Prices, holding.Symbol, k.Fiat, &transaction) {
+				log.Printf("Kraken %s entry %s for %s has no fiat value", entry.Type, entry.ID, holding.Symbol)
+				unpriced++
+			}
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	log.Printf("There are %d kraken ledger entries for %s\n", len(transactions), holding.Symbol)
+	if unpriced > 0 {
+		return transactions, ErrUnpricedTransactions
+	}
+	return transactions, nil
+}
+
+// toCoinTransaction converts a ledger entry into a CoinTransaction, using the other entries sharing its RefID to
+// price trades. Kraken takes fees on top of the amount, so the coins moved include the fee and the purchased price is
+// the value of the coins moved before the fees.
+func (e *KrakenLedgerEntry) toCoinTransaction(related []KrakenLedgerEntry) CoinTransaction {
+	incoming := e.Amount.Sign() > 0
+
+	transaction := CoinTransaction{
+		ID:        e.ID,
+		Status:    StatusCompleted,
+		Provider:  KrakenProviderName,
+		Timestamp: e.Timestamp(),
+		NumCoins:  e.Amount.Sub(e.Fee).Abs(),
+		Kind:      KindUnknown,
+	}
+
+	switch e.Type {
+	case "trade", "spend", "receive":
+		transaction.Kind = KindTradeOut
+		if incoming {
+			transaction.Kind = KindTradeIn
+		}
+
+		for _, fiat := range related {
+			if fiat.ID == e.ID || !containsSymbol(krakenFiatAssets, fiat.Asset) {
+				continue
+			}
+
+			subtotal := fiat.Amount.Abs()
+			transaction.Currency, _ = krakenSymbol(fiat.Asset)
+			transaction.Subtotal = subtotal
+			transaction.UnitPrice = subtotal.Div(e.Amount.Abs())
+			coinFee := e.Fee.Mul(transaction.UnitPrice)
+			transaction.TransactionFee = fiat.Fee.Add(coinFee)
+			transaction.Kind = KindSell
+			transaction.PurchasedPrice = subtotal.Add(coinFee)
+			if incoming {
+				transaction.Kind = KindBuy
+				transaction.PurchasedPrice = subtotal.Sub(coinFee)
+			}
+		}
+	case "deposit":
+		transaction.Kind = KindReceive
+	case "withdrawal":
+		transaction.Kind = KindSend
+	case "staking":
+		transaction.Kind = KindStakingReward
+	case "earn", "transfer":
+		switch {
+		case containsSymbol(krakenTransferSubtypes, e.Subtype) && incoming:
+			transaction.Kind = KindTransferIn
+		case containsSymbol(krakenTransferSubtypes, e.Subtype):
+			transaction.Kind = KindTransferOut
+		case incoming:
+			transaction.Kind = KindReward
+		default:
+			transaction.Kind = KindSend
+		}
+	}
+
+	return transaction
+}
+
+// Rates quotes the last traded price of a symbol against the client's Fiat currency, which is the only
+// currency quoted
+func (k *KrakenClient) Rates(ctx context.Context, symbol string) (CoinRates, error) {
+
+	pair := krakenPairName(symbol) + krakenPairName(k.Fiat)
+
+	tickers := map[string]KrakenTicker{}
+	if err := k.get(ctx, KrakenTickerURL+"?pair="+url.QueryEscape(pair), &tickers); err != nil {
+		return CoinRates{}, err
+	}
+
+	// Kraken answers with its own name for the pair (ie. XXDGZUSD), there's only ever the one
+	rates := CoinRates{}
+	for _, ticker := range tickers {
+		if len(ticker.Close) == 0 {
+			return CoinRates{}, ErrOnUnmarshall
+		}
+		price, err := ParseDecimal(ticker.Close[0])
+		if err != nil {
+			return CoinRates{}, ErrOnUnmarshall
+		}
+		rates[strings.ToUpper(k.Fiat)] = price
+	}
+	return rates, nil
+}
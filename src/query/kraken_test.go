@@ -0,0 +1,504 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+// krakenLedgerPageSize is the number of entries krakenLedgerFixture returns per page
+const krakenLedgerPageSize = 4
+
+// krakenLedgerFixture is a test helper that responds with the recorded ledger the way kraken would, filtering by the
+// requested assets (every asset when none are requested) and the entry to start after, and paging through the entries
+// using the offset
+func krakenLedgerFixture(t *testing.T) httpmock.Responder {
+	byteValue, err := ioutil.ReadFile("./testdata/kraken_ledger.json")
+	assert.Nil(t, err, "fixture should exist")
+
+	fixture := struct {
+		Result KrakenLedgerResp `json:"result"`
+	}{}
+	assert.Nil(t, json.Unmarshal(byteValue, &fixture), "fixture should be valid")
+
+	return func(req *http.Request) (*http.Response, error) {
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		assets := strings.Split(req.PostForm.Get("asset"), ",")
+		offset, _ := strconv.Atoi(req.PostForm.Get("ofs"))
+		start, hasStart := fixture.Result.Ledger[req.PostForm.Get("start")]
+
+		ids := []string{}
+		for id, entry := range fixture.Result.Ledger {
+			if req.PostForm.Get("asset") != "" && !containsSymbol(assets, entry.Asset) {
+				continue
+			}
+			if hasStart && entry.Time <= start.Time {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		page := map[string]json.RawMessage{}
+		for i := offset; i < len(ids) && i < offset+krakenLedgerPageSize; i++ {
+			entry, _ := json.Marshal(fixture.Result.Ledger[ids[i]])
+			page[ids[i]] = entry
+		}
+
+		return httpmock.NewJsonResponse(200, map[string]interface{}{
+			"error":  []string{},
+			"result": map[string]interface{}{"ledger": page, "count": len(ids)},
+		})
+	}
+}
+
+// krakenFixture is a test helper that responds with a recorded kraken response
+func krakenFixture(t *testing.T, path string) httpmock.Responder {
+	byteValue, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "fixture should exist")
+
+	return httpmock.NewBytesResponder(200, byteValue)
+}
+
+// stubSpotPricer quotes the same spot price of a symbol on every day
+type stubSpotPricer map[string]Decimal
+
+func (s stubSpotPricer) RetrieveSpotPrice(ctx context.Context, symbol, fiat string, date time.Time) (Decimal,
+	error) {
+	price, ok := s[symbol]
+	if !ok {
+		return Decimal{}, ErrNotFound
+	}
+	return price, nil
+}
+
+func newTestKrakenClient() *KrakenClient {
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	krakenAuth := auth.KrakenAuth{
+		APIKey:    "TestKey",
+		APISecret: base64.StdEncoding.EncodeToString([]byte("TestSecret")),
+	}
+	kraken := NewKrakenClient(krakenAuth, &client)
+	kraken.Prices = stubSpotPricer{"DOGE": MustDecimal("0.4"), "DOT": NewDecimal(20)}
+	return kraken
+}
+
+func TestKrakenClient(t *testing.T) {
+
+	kraken := newTestKrakenClient()
+
+	t.Run("Holdings", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		var headers http.Header
+		balance := krakenFixture(t, "./testdata/kraken_balance.json")
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+			func(req *http.Request) (*http.Response, error) {
+				headers = req.Header
+				return balance(req)
+			})
+
+		holdings, err := kraken.Holdings(context.Background())
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, []Holding{
+			{Provider: KrakenProviderName, AccountID: "DOT", Symbol: "DOT", Balance: NewDecimal(2)},
+			{Provider: KrakenProviderName, AccountID: "DOT.S", Symbol: "DOT", Balance: MustDecimal("10.05"), InterestBearing: true},
+			{Provider: KrakenProviderName, AccountID: "XXDG", Symbol: "DOGE", Balance: NewDecimal(1146)},
+			{Provider: KrakenProviderName, AccountID: "ZUSD", Symbol: "USD", Balance: MustDecimal("1408.49"), Fiat: true},
+		}, holdings, "fee credits should be skipped and asset codes normalized")
+		assert.Equal(t, "TestKey", headers.Get(auth.KrakenAPIKey), "should be the same")
+		_, err = base64.StdEncoding.DecodeString(headers.Get(auth.KrakenAPISign))
+		assert.Nil(t, err, "the signature should be base64 encoded")
+	})
+
+	t.Run("Nonce always increases", func(t *testing.T) {
+		first, _ := strconv.ParseInt(kraken.nextNonce(), 10, 64)
+		second, _ := strconv.ParseInt(kraken.nextNonce(), 10, 64)
+
+		assert.True(t, second > first, "a nonce should never be reused")
+	})
+
+	t.Run("Ledger across pages", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL, krakenLedgerFixture(t))
+
+		entries, err := kraken.RetrieveLedger(context.Background(), []string{"XXDG", "ZUSD"})
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 7, len(entries), "should be the same")
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "there should be one request per page")
+		for i := 1; i < len(entries); i++ {
+			assert.True(t, entries[i-1].Time <= entries[i].Time, "entries should be oldest first")
+		}
+		assert.Equal(t, "L4UESK-KG3EQ-UFO4T5", entries[0].ID, "should be the same")
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL, krakenLedgerFixture(t))
+
+		transactions, err := kraken.Transactions(context.Background(),
+			Holding{Provider: KrakenProviderName, AccountID: "XXDG", Symbol: "DOGE"})
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 4, len(transactions), "the fiat side of trades shouldn't be a transaction")
+		assert.Equal(t, []TransactionKind{KindBuy, KindSell, KindReceive, KindSend},
+			[]TransactionKind{transactions[0].Kind, transactions[1].Kind, transactions[2].Kind, transactions[3].Kind},
+			"should be the same")
+		assert.Equal(t, NewDecimal(500), transactions[0].PurchasedPrice, "should be the value before the fee")
+		assert.Equal(t, MustDecimal("1.3"), transactions[0].TransactionFee, "should be the same")
+		assert.Equal(t, MustDecimal("0.5"), transactions[0].UnitPrice, "should be the same")
+		assert.Equal(t, NewDecimal(150), transactions[1].PurchasedPrice, "should be the value before the fee")
+		assert.Equal(t, MustDecimal("0.39"), transactions[1].TransactionFee, "should be the same")
+		assert.Equal(t, NewDecimal(498), transactions[2].NumCoins, "the deposit fee should be removed")
+		assert.Equal(t, MustDecimal("199.2"), transactions[2].PurchasedPrice, "should be valued at the spot price")
+		assert.Equal(t, "USD", transactions[2].Currency, "should be the same")
+		assert.Equal(t, NewDecimal(52), transactions[3].NumCoins, "the withdrawal fee should be added")
+	})
+
+	t.Run("Transactions without a spot price", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL, krakenLedgerFixture(t))
+
+		unpriced := newTestKrakenClient()
+		unpriced.Prices = stubSpotPricer{}
+		testCoin := WarchestCoin{AccountID: "DOT.S", Symbol: "DOT"}
+		err := testCoin.UpdateTransactions(context.Background(), unpriced)
+		testCoin.UpdateStatus()
+
+		assert.Equal(t, ErrUnpricedTransactions, err, "should be the same")
+		assert.Equal(t, 2, len(testCoin.Transactions), "unpriced transactions should still be used")
+		assert.True(t, testCoin.Transactions[1].PurchasedPrice.IsZero(), "should be the same")
+		assert.Equal(t, StatusStale, testCoin.Status, "should be the same")
+		assert.Equal(t, ErrUnpricedTransactions.Error(), testCoin.Error, "should be the same")
+	})
+
+	t.Run("Ledger is shared by every holding", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		starts := []string{}
+		ledger := krakenLedgerFixture(t)
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL,
+			func(req *http.Request) (*http.Response, error) {
+				resp, err := ledger(req)
+				if req.PostForm.Get("ofs") == "0" {
+					starts = append(starts, req.PostForm.Get("start"))
+				}
+				return resp, err
+			})
+
+		shared := newTestKrakenClient()
+		doge, err := shared.Transactions(context.Background(),
+			Holding{Provider: KrakenProviderName, AccountID: "XXDG", Symbol: "DOGE"})
+		assert.Nil(t, err, "should not fail")
+		dot, err := shared.Transactions(context.Background(),
+			Holding{Provider: KrakenProviderName, AccountID: "DOT", Symbol: "DOT"})
+		assert.Nil(t, err, "should not fail")
+
+		assert.Equal(t, 4, len(doge), "should be the same")
+		assert.Equal(t, 2, len(dot), "should be the same")
+		assert.Equal(t, []string{""}, starts, "the ledger should only be retrieved once")
+
+		// Once the ledger is old, only the entries added since are retrieved
+		shared.ledgerFetchedAt = time.Now().Add(-KrakenLedgerRefresh)
+		ledgerSize := len(shared.ledger)
+		doge, err = shared.Transactions(context.Background(),
+			Holding{Provider: KrakenProviderName, AccountID: "XXDG", Symbol: "DOGE"})
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, 4, len(doge), "entries shouldn't be counted twice")
+		assert.Equal(t, ledgerSize, len(shared.ledger), "should be the same")
+		assert.Equal(t, []string{"", shared.ledger[ledgerSize-1].ID}, starts, "should be the same")
+	})
+
+	t.Run("Retries are signed with a new nonce", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		nonces := []string{}
+		responses := []string{`{"error":["EAPI:Rate limit exceeded"]}`, `{"error":[],"result":{"XXDG":"1.0"}}`}
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+			func(req *http.Request) (*http.Response, error) {
+				if err := req.ParseForm(); err != nil {
+					return nil, err
+				}
+				nonces = append(nonces, req.PostForm.Get("nonce"))
+				body := responses[0]
+				responses = responses[1:]
+				return httpmock.NewStringResponse(200, body), nil
+			})
+
+		waits := []time.Duration{}
+		retrying := newTestKrakenClient()
+		retrying.HTTPClient = newTestRetryClient(&krakenRateLimitClient{Client: retrying.HTTPClient}, &waits)
+
+		balances, err := retrying.RetrieveBalances(context.Background())
+
+		assert.Nil(t, err, "the rate limited request should have been retried")
+		assert.Equal(t, map[string]Decimal{"XXDG": NewDecimal(1)}, balances, "should be the same")
+		assert.Equal(t, 2, len(nonces), "should be the same")
+		assert.NotEqual(t, nonces[0], nonces[1], "a nonce should never be reused")
+		assert.Equal(t, 1, len(waits), "should be the same")
+	})
+
+	t.Run("Rate limited until the retries run out", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+			httpmock.NewStringResponder(200, `{"error":["EAPI:Rate limit exceeded"]}`))
+
+		waits := []time.Duration{}
+		retrying := newTestKrakenClient()
+		retrying.HTTPClient = newTestRetryClient(&krakenRateLimitClient{Client: retrying.HTTPClient}, &waits)
+
+		_, err := retrying.RetrieveBalances(context.Background())
+
+		assert.True(t, errors.Is(err, ErrRateLimited), "should be the same")
+		assert.Contains(t, err.Error(), KrakenProviderName, "the provider should be named")
+		assert.Equal(t, DefaultMaxRetries+1, httpmock.GetTotalCallCount(), "should be the same")
+	})
+
+	t.Run("Rates", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", KrakenBaseURL+KrakenTickerURL, "pair=XDGUSD",
+			httpmock.NewStringResponder(200, `{"error":[],"result":{"XDGUSD":{"c":["0.25000000","100.0"]}}}`))
+
+		rates, err := kraken.Rates(context.Background(), "DOGE")
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, CoinRates{"USD": MustDecimal("0.25")}, rates, "should be the same")
+	})
+
+	errorTests := []struct {
+		name     string
+		message  string
+		expected error
+	}{
+		{"Invalid key", "EAPI:Invalid key", ErrInvalidCredentials},
+		{"Rate limited", "EAPI:Rate limit exceeded", ErrRateLimited},
+		{"Unknown asset", "EQuery:Unknown asset pair", ErrNotFound},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Establish Mock
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+				httpmock.NewStringResponder(200, `{"error":["`+tt.message+`"]}`))
+
+			holdings, err := kraken.Holdings(context.Background())
+
+			assert.Empty(t, holdings, "no holdings should be returned")
+			assert.True(t, errors.Is(err, tt.expected), "should be the same")
+			assert.Contains(t, err.Error(), KrakenProviderName, "the provider should be named")
+		})
+	}
+}
+
+func TestKrakenLedgerEntry_ToCoinTransaction(t *testing.T) {
+
+	entryTests := []struct {
+		name     string
+		entry    KrakenLedgerEntry
+		expected TransactionKind
+	}{
+		{"Staking reward", KrakenLedgerEntry{Type: "staking", Amount: MustDecimal("0.05")}, KindStakingReward},
+		{"Moved to staking", KrakenLedgerEntry{Type: "transfer", Subtype: "spottostaking", Amount: NewDecimal(-10)},
+			KindTransferOut},
+		{"Staked from spot", KrakenLedgerEntry{Type: "transfer", Subtype: "stakingfromspot", Amount: NewDecimal(10)},
+			KindTransferIn},
+		{"Earn allocation", KrakenLedgerEntry{Type: "earn", Subtype: "allocation", Amount: NewDecimal(10)},
+			KindTransferIn},
+		{"Airdrop", KrakenLedgerEntry{Type: "transfer", Amount: NewDecimal(3)}, KindReward},
+		{"Trade against a coin", KrakenLedgerEntry{Type: "trade", Amount: NewDecimal(3)}, KindTradeIn},
+		{"Unknown type", KrakenLedgerEntry{Type: "margin", Amount: NewDecimal(3)}, KindUnknown},
+	}
+
+	for _, tt := range entryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			transaction := tt.entry.toCoinTransaction([]KrakenLedgerEntry{tt.entry})
+			assert.Equal(t, tt.expected, transaction.Kind, "should be the same")
+			assert.Equal(t, KrakenProviderName, transaction.Provider, "should be the same")
+		})
+	}
+}
+
+// stubProvider is a Provider holding a single coin, used to aggregate alongside kraken
+type stubProvider struct{}
+
+func (s stubProvider) Name() string {
+	return "stub"
+}
+
+func (s stubProvider) Holdings(ctx context.Context) ([]Holding, error) {
+	return []Holding{{Provider: "stub", AccountID: "stub-doge", Symbol: "DOGE", Balance: NewDecimal(100)}}, nil
+}
+
+func (s stubProvider) Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error) {
+	return []CoinTransaction{{ID: "stub-1", Kind: KindBuy, Status: StatusCompleted, Provider: "stub",
+		Timestamp: time.Unix(1619000000, 0).UTC(), NumCoins: NewDecimal(100), PurchasedPrice: NewDecimal(10)}}, nil
+}
+
+func (s stubProvider) Rates(ctx context.Context, symbol string) (CoinRates, error) {
+	if symbol != "DOGE" {
+		return CoinRates{}, ErrNotFound
+	}
+	return CoinRates{"USD": MustDecimal("0.5")}, nil
+}
+
+func TestGetWarchestCoins_Providers(t *testing.T) {
+
+	kraken := newTestKrakenClient()
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+		krakenFixture(t, "./testdata/kraken_balance.json"))
+	httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL, krakenLedgerFixture(t))
+	httpmock.RegisterResponderWithQuery("GET", KrakenBaseURL+KrakenTickerURL, "pair=DOTUSD",
+		httpmock.NewStringResponder(200, `{"error":[],"result":{"DOTUSD":{"c":["25.00000","1.0"]}}}`))
+
+	coins, skipped, err := GetWarchestCoins(context.Background(), []Provider{stubProvider{}, kraken}, false, 2,
+		DefaultCurrency, DefaultCostMethod, CoinFilter{})
+
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, 2, len(coins), "holdings of the same symbol should be aggregated")
+	assert.Equal(t, []SkippedAccount{
+		{Provider: KrakenProviderName, AccountID: "ZUSD", Symbol: "USD", Reason: SkipFiat},
+	}, skipped, "should be the same")
+
+	doge := coins["DOGE"]
+	assert.Equal(t, []string{"stub", KrakenProviderName}, doge.Providers, "should be the same")
+	assert.Equal(t, 5, len(doge.Transactions), "should be the same")
+	assert.Equal(t, NewDecimal(1246), doge.Amount, "should be the same")
+	boughtLot := MustDecimal("501.3").Mul(NewDecimal(700)).Div(NewDecimal(1000))
+	depositLot := MustDecimal("199.2")
+	assert.Equal(t, NewDecimal(10).Add(boughtLot.Mul(NewDecimal(1146)).Div(NewDecimal(1198))).Add(depositLot.Mul(NewDecimal(1146)).Div(NewDecimal(1198))), doge.Cost,
+		"the cost of each account should be added up")
+	assert.Equal(t, 2, len(doge.Accounts), "should be the same")
+	assert.Equal(t, CoinAccount{Provider: "stub", AccountID: "stub-doge", Balance: NewDecimal(100), Cost: NewDecimal(10), Amount: NewDecimal(100),
+		Profit: NewDecimal(40)}, doge.Accounts[0], "should be the same")
+	assert.Equal(t, "XXDG", doge.Accounts[1].AccountID, "should be the same")
+	assert.Equal(t, NewDecimal(1146), doge.Accounts[1].Amount, "should be the same")
+	assert.Equal(t, MustDecimal("0.5"), doge.Rates["USD"], "should be quoted by the first provider")
+
+	dot := coins["DOT"]
+	assert.Equal(t, []string{KrakenProviderName}, dot.Providers, "should be the same")
+	assert.Equal(t, MustDecimal("12.05"), dot.Amount, "moving coins to staking shouldn't change the amount")
+	assert.Equal(t, MustDecimal("241.62"), dot.Cost, "the staking reward should be valued at the spot price")
+	assert.Equal(t, 2, len(dot.Accounts), "should be the same")
+	assert.Equal(t, "DOT", dot.Accounts[0].AccountID, "should be the same")
+	assert.Equal(t, NewDecimal(2), dot.Accounts[0].Amount, "the coins moved to staking should leave spot")
+	assert.Equal(t, MustDecimal("240.62").Mul(NewDecimal(2)).Div(NewDecimal(12)), dot.Accounts[0].Cost,
+		"should be the same")
+	assert.Equal(t, "DOT.S", dot.Accounts[1].AccountID, "should be the same")
+	assert.Equal(t, MustDecimal("10.05"), dot.Accounts[1].Amount, "the coins moved should be staked")
+	assert.Equal(t, NewDecimal(1).Add(MustDecimal("240.62").Mul(NewDecimal(10)).Div(NewDecimal(12))), dot.Accounts[1].Cost,
+		"the lot should move along with the coins")
+	assert.Equal(t, NewDecimal(25), dot.Rates["USD"], "should fall back to kraken's quote")
+}
+
+func TestWallet_QuotedByOwnProvider(t *testing.T) {
+
+	kraken := newTestKrakenClient()
+	providers := []Provider{stubProvider{}, kraken}
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+		krakenFixture(t, "./testdata/kraken_balance.json"))
+	httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenLedgersURL, krakenLedgerFixture(t))
+	httpmock.RegisterResponderWithQuery("GET", KrakenBaseURL+KrakenTickerURL, "pair=DOTUSD",
+		httpmock.NewStringResponder(200, `{"error":[],"result":{"DOTUSD":{"c":["25.00000","1.0"]}}}`))
+
+	coins, _, err := GetWarchestCoins(context.Background(), providers, false, 2, DefaultCurrency, DefaultCostMethod,
+		CoinFilter{})
+	assert.Nil(t, err, "should not fail")
+
+	// DOT is only quoted by kraken, the first provider can't quote it
+	wallet := &Wallet{}
+	wallet.SetCoins(coins)
+
+	dot, _ := wallet.Coin("DOT")
+	assert.Equal(t, StatusOK, dot.Status, "should be the same")
+	assert.False(t, wallet.Partial, "should not be partial")
+	assert.Equal(t, MustDecimal("12.05").Mul(NewDecimal(25)), dot.MarketValue, "should be the same")
+	assert.Equal(t, dot.MarketValue.Add(MustDecimal("1246").Mul(MustDecimal("0.5"))), wallet.MarketValue,
+		"should be the same")
+
+	_, err = wallet.Refresh(context.Background(), providers, false)
+
+	dot, _ = wallet.Coin("DOT")
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, StatusOK, dot.Status, "refreshing should still fall back to kraken's quote")
+	assert.False(t, wallet.Partial, "should not be partial")
+}
+
+func TestGetWarchestCoins_ProviderFails(t *testing.T) {
+
+	kraken := newTestKrakenClient()
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", KrakenBaseURL+KrakenBalanceURL,
+		httpmock.NewStringResponder(200, `{"error":["EAPI:Invalid key"]}`))
+
+	coins, _, err := GetWarchestCoins(context.Background(), []Provider{stubProvider{}, kraken}, false, 2,
+		DefaultCurrency, DefaultCostMethod, CoinFilter{})
+
+	var loadErrs LoadErrors
+	var updateErrs UpdateErrors
+	assert.True(t, errors.As(err, &loadErrs), "should have been LoadErrors")
+	assert.False(t, errors.As(err, &updateErrs), "the providers shouldn't be reported as coins")
+	krakenErr := loadErrs.Providers[KrakenProviderName]
+	assert.True(t, errors.Is(krakenErr, ErrInvalidCredentials), "should have been ErrInvalidCredentials")
+	assert.Equal(t, "failed retrieving the holdings of 1 provider(s): kraken: "+krakenErr.Error(), err.Error(),
+		"should be the same")
+	assert.Equal(t, 1, len(coins), "the other provider's coins should still be returned")
+
+	wallet := &Wallet{}
+	wallet.SetProviderErrors(loadErrs.Providers)
+	wallet.SetCoins(coins)
+
+	doge, _ := wallet.Coin("DOGE")
+	assert.Equal(t, StatusOK, doge.Status, "should be the same")
+	assert.True(t, wallet.Partial, "the wallet should be partial without kraken's coins")
+	assert.Equal(t, "missing the coins of kraken: "+krakenErr.Error(), wallet.Error, "should be the same")
+	assert.Equal(t, wallet.Error, wallet.Clone().Error, "should be the same")
+
+	walletJSON, err := json.Marshal(wallet)
+	assert.Nil(t, err, "should have been marshalled")
+	assert.Contains(t, string(walletJSON), `"partial":true,"error":"missing the coins of kraken`, "should be reported")
+}
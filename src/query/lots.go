@@ -0,0 +1,371 @@
+package query
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CostMethod decides which lots a disposal takes its coins from, and so what the coins disposed of cost
+type CostMethod string
+
+const (
+	// MethodFIFO disposes of the oldest lots first
+	MethodFIFO CostMethod = "fifo"
+
+	// MethodLIFO disposes of the newest lots first
+	MethodLIFO CostMethod = "lifo"
+
+	// MethodHIFO disposes of the lots with the highest unit cost first
+	MethodHIFO CostMethod = "hifo"
+
+	// MethodAverage disposes of every open lot in proportion, so every coin disposed of costs the average
+	MethodAverage CostMethod = "average"
+
+	// MethodSpecificID disposes of the lots listed in the disposal's LotIDs, the rest are disposed of oldest first
+	MethodSpecificID CostMethod = "specific-id"
+)
+
+// DefaultCostMethod is the cost method used when one isn't chosen
+const DefaultCostMethod = MethodAverage
+
+// CostMethods lists every supported cost method
+var CostMethods = []CostMethod{MethodFIFO, MethodLIFO, MethodHIFO, MethodAverage, MethodSpecificID}
+
+// ParseCostMethod parses a cost method regardless of case, DefaultCostMethod is used when the value is empty
+func ParseCostMethod(value string) (CostMethod, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return DefaultCostMethod, nil
+	}
+	for _, method := range CostMethods {
+		if string(method) == value {
+			return method, nil
+		}
+	}
+	return "", ErrUnknownCostMethod
+}
+
+// Lot is the coins acquired by a single transaction, NumCoins, Cost and Fees are what's left of it after disposals.
+// Fees are the part of the acquisition's fee paid for the coins left, whether or not Cost includes them.
+type Lot struct {
+	ID        string          `json:"id"`
+	Kind      TransactionKind `json:"kind,omitempty"`
+	Provider  string          `json:"provider,omitempty"`
+	AccountID string          `json:"account_id,omitempty"`
+	Acquired  time.Time       `json:"acquired"`
+	NumCoins  Decimal         `json:"num_coins"`
+	Cost      Decimal         `json:"cost"`
+	Fees      Decimal         `json:"fees"`
+}
+
+// UnitCost is the cost of a single coin of the lot
+func (l *Lot) UnitCost() Decimal {
+	return l.Cost.Div(l.NumCoins)
+}
+
+// LotDisposal is the part of a disposal taken from a single lot. Coins disposed of without a lot to take them from
+// (ie. the history before the disposal is missing) have no LotID and cost nothing.
+type LotDisposal struct {
+	LotID      string          `json:"lot_id,omitempty"`
+	DisposalID string          `json:"disposal_id,omitempty"`
+	Kind       TransactionKind `json:"kind"`
+	Provider   string          `json:"provider,omitempty"`
+	AccountID  string          `json:"account_id,omitempty"`
+	Acquired   time.Time       `json:"acquired"`
+	Disposed   time.Time       `json:"disposed"`
+	NumCoins   Decimal         `json:"num_coins"`
+	Cost       Decimal         `json:"cost"`
+	Proceeds   Decimal         `json:"proceeds"`
+}
+
+// Gain is what the coins made over what they cost
+func (d *LotDisposal) Gain() Decimal {
+	return d.Proceeds.Sub(d.Cost)
+}
+
+// realizedGain adds up the gains of the disposals that sold or converted coins
+func realizedGain(disposals []LotDisposal) Decimal {
+	gain := Decimal{}
+	for _, disposal := range disposals {
+		if disposal.Kind.IsSale() {
+			gain = gain.Add(disposal.Gain())
+		}
+	}
+	return gain
+}
+
+// soldCost adds up what the coins sold or converted into another coin cost
+func soldCost(disposals []LotDisposal) Decimal {
+	cost := Decimal{}
+	for _, disposal := range disposals {
+		if disposal.Kind.IsSale() {
+			cost = cost.Add(disposal.Cost)
+		}
+	}
+	return cost
+}
+
+// LotLedger is the outcome of matching a coin's disposals to the lots acquired before them
+type LotLedger struct {
+	// Lots are the lots still held, oldest first
+	Lots      []Lot
+	Disposals []LotDisposal
+	Fees      Decimal
+
+	// moving are the lots taken out of an account by a transfer, until the transfer into the other account
+	moving []Lot
+}
+
+// Amount is the number of coins still held
+func (l *LotLedger) Amount() Decimal {
+	amount := Decimal{}
+	for _, lot := range l.Lots {
+		amount = amount.Add(lot.NumCoins)
+	}
+	return amount
+}
+
+// Cost is what the coins still held cost
+func (l *LotLedger) Cost() Decimal {
+	cost := Decimal{}
+	for _, lot := range l.Lots {
+		cost = cost.Add(lot.Cost)
+	}
+	return cost
+}
+
+// AccountLots returns the lots still held in a single account of a provider
+func (l *LotLedger) AccountLots(provider string, accountID string) []Lot {
+	lots := []Lot{}
+	for _, lot := range l.Lots {
+		if lot.Provider == provider && lot.AccountID == accountID {
+			lots = append(lots, lot)
+		}
+	}
+	return lots
+}
+
+// lotFees adds up the fees paid for the coins still held
+func lotFees(lots []Lot) Decimal {
+	fees := Decimal{}
+	for _, lot := range lots {
+		fees = fees.Add(lot.Fees)
+	}
+	return fees
+}
+
+// MatchLots turns every completed acquisition into a lot, and takes every completed disposal from the lots acquired
+// before it in the same account according to method. Transfers between accounts move the lots they take along, so
+// the coins keep their acquisition date and cost. With fees, the fee of an acquisition is part of the lot's cost and
+// the fee of a disposal is taken from its proceeds.
+func MatchLots(symbol string, method CostMethod, transactions []CoinTransaction, fees bool) LotLedger {
+	ledger := LotLedger{Lots: []Lot{}, Disposals: []LotDisposal{}}
+
+	for i, transaction := range chronological(transactions) {
+		if !transaction.IsCompleted() {
+			continue
+		}
+		ledger.Fees = ledger.Fees.Add(transaction.TransactionFee)
+		fee := Decimal{}
+		if fees {
+			fee = transaction.TransactionFee
+		}
+
+		// Transactions from the config file don't have an id
+		id := transaction.ID
+		if id == "" {
+			id = symbol + "-" + strconv.Itoa(i+1)
+		}
+
+		switch {
+		case transaction.Kind.IsAcquisition():
+			ledger.Lots = append(ledger.Lots, Lot{ID: id, Kind: transaction.Kind, Provider: transaction.Provider,
+				AccountID: transaction.AccountID, Acquired: transaction.Timestamp, NumCoins: transaction.NumCoins,
+				Cost: transaction.PurchasedPrice.Add(fee), Fees: transaction.TransactionFee})
+		case transaction.Kind.IsDisposal():
+			ledger.dispose(symbol, method, transaction, transaction.PurchasedPrice.Sub(fee))
+		case transaction.Kind == KindTransferOut:
+			for _, lot := range ledger.take(symbol, method, transaction) {
+				if lot.ID != "" {
+					ledger.moving = append(ledger.moving, lot)
+				}
+			}
+		case transaction.Kind == KindTransferIn:
+			ledger.receive(id, transaction, transaction.PurchasedPrice.Add(fee))
+		default:
+			log.Printf("Ignoring %s transaction %s for %s", transaction.Kind, transaction.ID, symbol)
+		}
+	}
+
+	if len(ledger.moving) > 0 {
+		log.Printf("%s transferred %d lots to accounts that aren't in the wallet", symbol, len(ledger.moving))
+	}
+	return ledger
+}
+
+// dispose takes the coins of a disposal from the open lots of its account, splitting its proceeds between them
+func (l *LotLedger) dispose(symbol string, method CostMethod, disposal CoinTransaction, proceeds Decimal) {
+	total := proceeds
+
+	taken := l.take(symbol, method, disposal)
+	for i, lot := range taken {
+		// The last part of the disposal gets what's left of the proceeds so they add up exactly
+		share := proceeds
+		if i < len(taken)-1 {
+			share = total.Mul(lot.NumCoins).Div(disposal.NumCoins)
+		}
+		l.Disposals = append(l.Disposals, LotDisposal{LotID: lot.ID, DisposalID: disposal.ID, Kind: disposal.Kind,
+			Provider: disposal.Provider, AccountID: disposal.AccountID, Acquired: lot.Acquired,
+			Disposed: disposal.Timestamp, NumCoins: lot.NumCoins, Cost: lot.Cost, Proceeds: share})
+		proceeds = proceeds.Sub(share)
+	}
+}
+
+// take removes the coins of a transaction from the open lots of its account in the order method takes them, and
+// returns the part taken from every lot. Coins taken without a lot to take them from (ie. the history before the
+// transaction is missing) are returned as a part without an ID that cost nothing.
+func (l *LotLedger) take(symbol string, method CostMethod, transaction CoinTransaction) []Lot {
+	taken := []Lot{}
+	remaining := transaction.NumCoins
+	order := l.order(method, transaction)
+
+	// Average cost takes the same share of every lot, worked out from the running total so the shares add up exactly
+	held := Decimal{}
+	for _, i := range order {
+		held = held.Add(l.Lots[i].NumCoins)
+	}
+	proportional := method == MethodAverage && transaction.NumCoins.Cmp(held) < 0
+	cumulative := Decimal{}
+
+	for _, i := range order {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		lot := &l.Lots[i]
+
+		numCoins := remaining
+		if proportional {
+			before := cumulative.Mul(transaction.NumCoins).Div(held)
+			cumulative = cumulative.Add(lot.NumCoins)
+			numCoins = cumulative.Mul(transaction.NumCoins).Div(held).Sub(before)
+		}
+		if numCoins.Cmp(lot.NumCoins) >= 0 {
+			taken = append(taken, *lot)
+			remaining = remaining.Sub(lot.NumCoins)
+			lot.NumCoins, lot.Cost, lot.Fees = Decimal{}, Decimal{}, Decimal{}
+			continue
+		}
+
+		part := *lot
+		part.NumCoins = numCoins
+		part.Cost = lot.Cost.Mul(numCoins).Div(lot.NumCoins)
+		part.Fees = lot.Fees.Mul(numCoins).Div(lot.NumCoins)
+		taken = append(taken, part)
+		remaining = remaining.Sub(numCoins)
+		lot.NumCoins, lot.Cost, lot.Fees = lot.NumCoins.Sub(numCoins), lot.Cost.Sub(part.Cost), lot.Fees.Sub(part.Fees)
+	}
+
+	// History before the transaction is missing, the holdings can't go below nothing
+	if remaining.Sign() > 0 {
+		log.Printf("%s took %s more coins out of an account than it acquired", symbol, remaining)
+		taken = append(taken, Lot{NumCoins: remaining})
+	}
+
+	open := l.Lots[:0]
+	for _, lot := range l.Lots {
+		if !lot.NumCoins.IsZero() {
+			open = append(open, lot)
+		}
+	}
+	l.Lots = open
+	return taken
+}
+
+// receive moves the lots taken out by transfers into the account of the transfer, oldest transfer first. Coins
+// transferred from an account that isn't in the wallet are a lot of their own, which cost their value at the time of
+// the transfer.
+func (l *LotLedger) receive(id string, transfer CoinTransaction, value Decimal) {
+	remaining := transfer.NumCoins
+
+	for len(l.moving) > 0 && remaining.Sign() > 0 {
+		lot := l.moving[0]
+		if lot.NumCoins.Cmp(remaining) > 0 {
+			part := lot
+			part.NumCoins = remaining
+			part.Cost = lot.Cost.Mul(remaining).Div(lot.NumCoins)
+			part.Fees = lot.Fees.Mul(remaining).Div(lot.NumCoins)
+			l.moving[0].NumCoins = lot.NumCoins.Sub(part.NumCoins)
+			l.moving[0].Cost = lot.Cost.Sub(part.Cost)
+			l.moving[0].Fees = lot.Fees.Sub(part.Fees)
+			lot = part
+		} else {
+			l.moving = l.moving[1:]
+		}
+		remaining = remaining.Sub(lot.NumCoins)
+		lot.Provider, lot.AccountID = transfer.Provider, transfer.AccountID
+		l.addLot(lot)
+	}
+
+	if remaining.Sign() > 0 {
+		l.addLot(Lot{ID: id, Kind: transfer.Kind, Provider: transfer.Provider, AccountID: transfer.AccountID,
+			Acquired: transfer.Timestamp, NumCoins: remaining, Cost: value.Mul(remaining).Div(transfer.NumCoins),
+			Fees: transfer.TransactionFee.Mul(remaining).Div(transfer.NumCoins)})
+	}
+}
+
+// addLot adds a lot to the open lots in the order it was acquired, coins of a lot moved back into an account that
+// still holds the rest of it are added to the lot
+func (l *LotLedger) addLot(lot Lot) {
+	for i, open := range l.Lots {
+		if open.ID == lot.ID && open.Provider == lot.Provider && open.AccountID == lot.AccountID {
+			l.Lots[i].NumCoins = open.NumCoins.Add(lot.NumCoins)
+			l.Lots[i].Cost = open.Cost.Add(lot.Cost)
+			l.Lots[i].Fees = open.Fees.Add(lot.Fees)
+			return
+		}
+	}
+
+	l.Lots = append(l.Lots, lot)
+	sort.SliceStable(l.Lots, func(i, j int) bool {
+		return l.Lots[i].Acquired.Before(l.Lots[j].Acquired)
+	})
+}
+
+// order returns the indexes of the open lots of the disposal's account in the order method disposes of them
+func (l *LotLedger) order(method CostMethod, disposal CoinTransaction) []int {
+	order := []int{}
+	for i, lot := range l.Lots {
+		if lot.Provider == disposal.Provider && lot.AccountID == disposal.AccountID {
+			order = append(order, i)
+		}
+	}
+
+	switch method {
+	case MethodLIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			return order[i] > order[j]
+		})
+	case MethodHIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			return l.Lots[order[i]].UnitCost().Cmp(l.Lots[order[j]].UnitCost()) > 0
+		})
+	case MethodSpecificID:
+		chosen := map[string]int{}
+		for i, id := range disposal.LotIDs {
+			chosen[id] = i
+		}
+		rank := func(i int) int {
+			if rank, ok := chosen[l.Lots[i].ID]; ok {
+				return rank
+			}
+			return len(chosen)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return rank(order[i]) < rank(order[j])
+		})
+	}
+	return order
+}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// lotTransactions are three BTC buys at different prices followed by a sale of 1.5 BTC, newest first like coinbase
+func lotTransactions() []CoinTransaction {
+	day := func(month time.Month) time.Time {
+		return time.Date(2021, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return []CoinTransaction{
+		{ID: "sell-1", Kind: KindSell, Timestamp: day(4), NumCoins: MustDecimal("1.5"), PurchasedPrice: NewDecimal(600)},
+		{ID: "buy-3", Kind: KindBuy, Timestamp: day(3), NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(200)},
+		{ID: "buy-2", Kind: KindBuy, Timestamp: day(2), NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(300)},
+		{ID: "buy-1", Kind: KindBuy, Timestamp: day(1), NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(100)},
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+
+	methodTests := []struct {
+		method        CostMethod
+		expectedLots  map[string]Decimal
+		expectedTaken map[string]Decimal
+	}{
+		{MethodFIFO,
+			map[string]Decimal{"buy-2": NewDecimal(150), "buy-3": NewDecimal(200)},
+			map[string]Decimal{"buy-1": NewDecimal(100), "buy-2": NewDecimal(150)}},
+		{MethodLIFO,
+			map[string]Decimal{"buy-1": NewDecimal(100), "buy-2": NewDecimal(150)},
+			map[string]Decimal{"buy-3": NewDecimal(200), "buy-2": NewDecimal(150)}},
+		{MethodHIFO,
+			map[string]Decimal{"buy-1": NewDecimal(100), "buy-3": NewDecimal(100)},
+			map[string]Decimal{"buy-2": NewDecimal(300), "buy-3": NewDecimal(100)}},
+		{MethodAverage,
+			map[string]Decimal{"buy-1": NewDecimal(50), "buy-2": NewDecimal(150), "buy-3": NewDecimal(100)},
+			map[string]Decimal{"buy-1": NewDecimal(50), "buy-2": NewDecimal(150), "buy-3": NewDecimal(100)}},
+	}
+
+	for _, tt := range methodTests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			ledger := MatchLots("BTC", tt.method, lotTransactions(), true)
+
+			lots := map[string]Decimal{}
+			for _, lot := range ledger.Lots {
+				lots[lot.ID] = lot.Cost
+			}
+			taken := map[string]Decimal{}
+			proceeds := Decimal{}
+			for _, disposal := range ledger.Disposals {
+				assert.Equal(t, "sell-1", disposal.DisposalID, "should be the same")
+				taken[disposal.LotID] = disposal.Cost
+				proceeds = proceeds.Add(disposal.Proceeds)
+			}
+
+			assert.Equal(t, tt.expectedLots, lots, "the cost of the lots left should be the same")
+			assert.Equal(t, tt.expectedTaken, taken, "the cost taken from each lot should be the same")
+			assert.Equal(t, MustDecimal("1.5"), ledger.Amount(), "should be the same")
+			assert.Equal(t, NewDecimal(600), proceeds, "the proceeds should be split between the lots")
+		})
+	}
+
+	t.Run("Specific lots are disposed of first", func(t *testing.T) {
+		transactions := lotTransactions()
+		transactions[0].LotIDs = []string{"buy-3"}
+
+		ledger := MatchLots("BTC", MethodSpecificID, transactions, true)
+
+		assert.Equal(t, 2, len(ledger.Disposals), "should be the same")
+		assert.Equal(t, "buy-3", ledger.Disposals[0].LotID, "the chosen lot should be disposed of first")
+		assert.Equal(t, NewDecimal(400), ledger.Disposals[0].Proceeds, "should be the same")
+		assert.Equal(t, "buy-1", ledger.Disposals[1].LotID, "the rest should be disposed of oldest first")
+		assert.Equal(t, MustDecimal("0.5"), ledger.Disposals[1].NumCoins, "should be the same")
+		assert.Equal(t, NewDecimal(350), ledger.Cost(), "should be the same")
+	})
+
+	t.Run("Average cost splits lots exactly", func(t *testing.T) {
+		transactions := lotTransactions()
+		transactions[0].NumCoins = NewDecimal(1)
+
+		ledger := MatchLots("BTC", MethodAverage, transactions, true)
+
+		assert.Equal(t, 3, len(ledger.Lots), "every lot should still be held")
+		assert.Equal(t, NewDecimal(2), ledger.Amount(), "the shares should add up to the coins disposed of")
+		assert.Equal(t, NewDecimal(400), ledger.Cost().Round(9), "should be the same")
+	})
+
+	t.Run("Disposing of more than was acquired", func(t *testing.T) {
+		transactions := lotTransactions()
+		transactions[0].NumCoins = NewDecimal(4)
+
+		ledger := MatchLots("BTC", MethodFIFO, transactions, true)
+
+		assert.Empty(t, ledger.Lots, "nothing should be held")
+		assert.Equal(t, 4, len(ledger.Disposals), "should be the same")
+		assert.Equal(t, LotDisposal{DisposalID: "sell-1", Kind: KindSell, Disposed: transactions[0].Timestamp,
+			NumCoins: NewDecimal(1), Proceeds: NewDecimal(150)}, ledger.Disposals[3],
+			"the coins without a lot should cost nothing")
+	})
+
+	t.Run("Fees", func(t *testing.T) {
+		transactions := lotTransactions()
+		transactions[0].TransactionFee = NewDecimal(6)
+		transactions[3].TransactionFee = NewDecimal(2)
+
+		ledger := MatchLots("BTC", MethodFIFO, transactions, true)
+		assert.Equal(t, NewDecimal(102), ledger.Disposals[0].Cost, "the fee should be part of the lot's cost")
+		assert.Equal(t, NewDecimal(594), realizedGain(ledger.Disposals).Add(NewDecimal(102+150)),
+			"the fee should be taken from the proceeds")
+		assert.Equal(t, NewDecimal(8), ledger.Fees, "should be the same")
+		assert.True(t, lotFees(ledger.Lots).IsZero(), "the fee of buy-1 should have gone with it")
+
+		ledger = MatchLots("BTC", MethodFIFO, transactions, false)
+		assert.Equal(t, NewDecimal(600-(100+150)), realizedGain(ledger.Disposals), "fees should be left out")
+		assert.Equal(t, NewDecimal(8), ledger.Fees, "fees should still be added up")
+	})
+
+	t.Run("Transactions without an id", func(t *testing.T) {
+		ledger := MatchLots("BTC", MethodFIFO, []CoinTransaction{{NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(10)},
+			{Status: "pending", NumCoins: NewDecimal(1)}}, true)
+
+		assert.Equal(t, 1, len(ledger.Lots), "pending transactions shouldn't be lots")
+		assert.Equal(t, "BTC-1", ledger.Lots[0].ID, "should be the same")
+	})
+}
+
+func TestCoinUpdateCost_Lots(t *testing.T) {
+
+	testCoin := WarchestCoin{Symbol: "BTC", CostMethod: MethodFIFO, Rates: CoinRates{"USD": NewDecimal(400)},
+		Accounts: []CoinAccount{{Provider: CBProviderName, AccountID: "wallet"}, {Provider: CBProviderName,
+			AccountID: "vault"}}}
+	for _, transaction := range lotTransactions() {
+		transaction.Provider, transaction.AccountID = CBProviderName, "wallet"
+		if transaction.ID == "buy-2" {
+			transaction.AccountID = "vault"
+		}
+		testCoin.Transactions = append(testCoin.Transactions, transaction)
+	}
+
+	testCoin.UpdateCost()
+	testCoin.UpdateProfit()
+
+	assert.Equal(t, 2, len(testCoin.Lots), "should be the same")
+	assert.Equal(t, "buy-2", testCoin.Lots[0].ID, "the lots of every account should be oldest first")
+	assert.Equal(t, "buy-3", testCoin.Lots[1].ID, "should be the same")
+	assert.Equal(t, MustDecimal("0.5"), testCoin.Lots[1].NumCoins, "the sale should only take from its own account")
+	assert.Equal(t, NewDecimal(400), testCoin.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(200), testCoin.Profit, "should be the same")
+}
+
+func TestCoinUpdateCost_Transfers(t *testing.T) {
+
+	day := func(month time.Month) time.Time {
+		return time.Date(2021, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+	newCoin := func(transactions ...CoinTransaction) WarchestCoin {
+		testCoin := WarchestCoin{Symbol: "BTC", CostMethod: MethodFIFO, Rates: CoinRates{"USD": NewDecimal(400)},
+			Accounts: []CoinAccount{{Provider: CBProviderName, AccountID: "wallet"}, {Provider: CBProviderName,
+				AccountID: "vault"}}}
+		for _, transaction := range transactions {
+			transaction.Provider = CBProviderName
+			testCoin.Transactions = append(testCoin.Transactions, transaction)
+		}
+		return testCoin
+	}
+
+	t.Run("Sold from the vault", func(t *testing.T) {
+		// Both sides of the transfer are made at the same time, newest first like coinbase
+		testCoin := newCoin(
+			CoinTransaction{ID: "sell-1", Kind: KindSell, AccountID: "vault", Timestamp: day(4), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(600)},
+			CoinTransaction{ID: "transfer-in-1", Kind: KindTransferIn, AccountID: "vault", Timestamp: day(3),
+				NumCoins: MustDecimal("1.5"), PurchasedPrice: NewDecimal(450)},
+			CoinTransaction{ID: "transfer-out-1", Kind: KindTransferOut, AccountID: "wallet", Timestamp: day(3),
+				NumCoins: MustDecimal("1.5"), PurchasedPrice: NewDecimal(450)},
+			CoinTransaction{ID: "buy-2", Kind: KindBuy, AccountID: "wallet", Timestamp: day(2), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(300)},
+			CoinTransaction{ID: "buy-1", Kind: KindBuy, AccountID: "wallet", Timestamp: day(1), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(100)})
+
+		testCoin.UpdateCost()
+		testCoin.UpdateProfit()
+
+		assert.Equal(t, 1, len(testCoin.Disposals), "should be the same")
+		assert.Equal(t, "buy-1", testCoin.Disposals[0].LotID, "the sale should take the lot moved into the vault")
+		assert.Equal(t, day(1), testCoin.Disposals[0].Acquired, "the lot should keep its acquisition date")
+		assert.Equal(t, NewDecimal(100), testCoin.Disposals[0].Cost, "the lot should keep its cost")
+		assert.Equal(t, NewDecimal(600-100), testCoin.RealizedGain, "should be the same")
+
+		assert.Equal(t, NewDecimal(1), testCoin.Amount, "should be the same")
+		assert.Equal(t, NewDecimal(300), testCoin.Cost, "should be the same")
+		assert.Equal(t, MustDecimal("0.5"), testCoin.Accounts[0].Amount, "the wallet should only keep what's left")
+		assert.Equal(t, NewDecimal(150), testCoin.Accounts[0].Cost, "should be the same")
+		assert.Equal(t, MustDecimal("0.5"), testCoin.Accounts[1].Amount, "should be the same")
+		assert.Equal(t, NewDecimal(150), testCoin.Accounts[1].Cost, "should be the same")
+		for _, lot := range testCoin.Lots {
+			assert.Equal(t, "buy-2", lot.ID, "should be the same")
+			assert.Equal(t, day(2), lot.Acquired, "should be the same")
+		}
+	})
+
+	t.Run("Transferred from an account that isn't in the wallet", func(t *testing.T) {
+		testCoin := newCoin(CoinTransaction{ID: "transfer-in-1", Kind: KindTransferIn, AccountID: "vault",
+			Timestamp: day(3), NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(500)})
+
+		testCoin.UpdateCost()
+
+		assert.Equal(t, NewDecimal(2), testCoin.Amount, "should be the same")
+		assert.Equal(t, NewDecimal(500), testCoin.Cost, "the coins should cost their value at the time")
+		assert.Equal(t, "transfer-in-1", testCoin.Lots[0].ID, "should be the same")
+		assert.Equal(t, NewDecimal(2), testCoin.Accounts[1].Amount, "should be the same")
+	})
+}
+
+func TestParseCostMethod(t *testing.T) {
+
+	for _, method := range CostMethods {
+		parsed, err := ParseCostMethod(" " + string(method) + " ")
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, method, parsed, "should be the same")
+	}
+
+	parsed, err := ParseCostMethod("")
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, DefaultCostMethod, parsed, "should be the same")
+
+	parsed, err = ParseCostMethod("FIFO")
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, MethodFIFO, parsed, "should be the same")
+
+	_, err = ParseCostMethod("lowest")
+	assert.Equal(t, ErrUnknownCostMethod, err, "should be the same")
+}
@@ -4,7 +4,7 @@ import (
 	"net/http"
 )
 
-// CBBaseURL is the baseurl for all coinbase API calls
+// CBBaseURL is the default baseurl for all coinbase API calls
 const CBBaseURL = "https://api.coinbase.com"
 
 var (
@@ -16,6 +16,9 @@ var (
 
 	// ErrConnection occurs when a request is interupted or fails
 	ErrConnection = Error("error during request")
+
+	// ErrTooManyPages occurs when a paginated response has more pages than the client allows
+	ErrTooManyPages = Error("exceeded maximum number of pages")
 )
 
 // Error is the helper method that produces the errors above
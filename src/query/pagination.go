@@ -0,0 +1,80 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CBPageLimit is the default number of records requested per page for paginated coinbase API calls (coinbase allows
+// 1-100)
+const CBPageLimit = 25
+
+// CBMaxPages is the default maximum number of pages that will be followed for a single paginated coinbase API call
+const CBMaxPages = 100
+
+// CBPagination is the pagination object included in all coinbase list responses
+// Ref: https://developers.coinbase.com/api/v2#pagination
+type CBPagination struct {
+	EndingBefore         interface{} `json:"ending_before"`
+	StartingAfter        interface{} `json:"starting_after"`
+	PreviousEndingBefore interface{} `json:"previous_ending_before"`
+	NextStartingAfter    string      `json:"next_starting_after"`
+	Limit                int         `json:"limit"`
+	Order                string      `json:"order"`
+	PreviousURI          interface{} `json:"previous_uri"`
+	NextURI              string      `json:"next_uri"`
+}
+
+// cbFirstPage is an internal helper that produces the request path for the first page of a paginated call
+func cbFirstPage(path string, limit int) string {
+	if limit <= 0 {
+		return path
+	}
+	return fmt.Sprintf("%s%slimit=%d", path, querySeparator(path), limit)
+}
+
+// cbNextPage is an internal helper that produces the request path for the page following the one described by
+// the pagination object. An empty string is returned when there are no pages left.
+func cbNextPage(path string, pagination CBPagination) string {
+
+	// Coinbase provides the full path (including the cursor) for the next page
+	if pagination.NextURI != "" {
+		return pagination.NextURI
+	}
+
+	// Fallback to building the path from the cursor, keeping any other query parameters (ie. expand)
+	if pagination.NextStartingAfter != "" {
+		next := cbFirstPage(withoutPageParams(path), pagination.Limit)
+		return next + querySeparator(next) + "starting_after=" + pagination.NextStartingAfter
+	}
+
+	return ""
+}
+
+// withoutPageParams is an internal helper that drops the limit and cursor from the query of a request path
+func withoutPageParams(path string) string {
+	parts := strings.SplitN(path, "?", 2)
+	if len(parts) < 2 {
+		return path
+	}
+
+	params := []string{}
+	for _, param := range strings.Split(parts[1], "&") {
+		if param == "" || strings.HasPrefix(param, "limit=") || strings.HasPrefix(param, "starting_after=") {
+			continue
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		return parts[0]
+	}
+	return parts[0] + "?" + strings.Join(params, "&")
+}
+
+// querySeparator returns the character needed to append a query parameter to the given path
+func querySeparator(path string) string {
+	if strings.Contains(path, "?") {
+		return "&"
+	}
+	return "?"
+}
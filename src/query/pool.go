@@ -0,0 +1,116 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrency is the default number of coins that are updated at the same time
+const DefaultConcurrency = 4
+
+// UpdateErrors holds the errors of the coins that failed to update, keyed by coin symbol
+type UpdateErrors map[string]error
+
+// Error lists the coins that failed to update along with their errors
+func (u UpdateErrors) Error() string {
+	return fmt.Sprintf("failed updating %d coin(s): %s", len(u), describeErrors(u))
+}
+
+// LoadErrors holds the providers whose holdings couldn't be retrieved, keyed by provider name, along with the coins of
+// the other providers that failed to update. The coins that were retrieved are still usable, but the wallet is
+// missing the coins of the failed providers.
+type LoadErrors struct {
+	Providers map[string]error
+	Coins     UpdateErrors
+}
+
+// Error lists the providers whose holdings couldn't be retrieved, followed by the coins that failed to update
+func (l LoadErrors) Error() string {
+	message := fmt.Sprintf("failed retrieving the holdings of %d provider(s): %s", len(l.Providers),
+		describeErrors(l.Providers))
+	if len(l.Coins) > 0 {
+		message += "; " + l.Coins.Error()
+	}
+	return message
+}
+
+// Unwrap returns the coins that failed to update, if any
+func (l LoadErrors) Unwrap() error {
+	if len(l.Coins) == 0 {
+		return nil
+	}
+	return l.Coins
+}
+
+// describeErrors lists every error along with its key, sorted by key
+func describeErrors(errs map[string]error) string {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	failures := make([]string, 0, len(keys))
+	for _, key := range keys {
+		failures = append(failures, fmt.Sprintf("%s: %s", key, errs[key]))
+	}
+	return strings.Join(failures, "; ")
+}
+
+// coinUpdate is the work done for a single coin by the worker pool
+type coinUpdate func(ctx context.Context, coin *WarchestCoin) error
+
+// updateCoins runs update for every coin using at most concurrency workers. Each worker updates its own copy of a
+// coin, so the updated coins are returned in the same order they were provided along with the errors of any coins
+// that failed. A failing coin doesn't stop the others from being updated.
+func updateCoins(ctx context.Context, coins []WarchestCoin, concurrency int, update coinUpdate) ([]WarchestCoin,
+	UpdateErrors) {
+
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > len(coins) {
+		concurrency = len(coins)
+	}
+
+	updated := make([]WarchestCoin, len(coins))
+	errs := make([]error, len(coins))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				coin := coins[i]
+				errs[i] = update(ctx, &coin)
+				updated[i] = coin
+			}
+		}()
+	}
+
+	// Hand out the work, coins that never get picked up because the context is done are reported as failed
+	for i := range coins {
+		if err := ctx.Err(); err != nil {
+			updated[i] = coins[i]
+			errs[i] = err
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	updateErrs := UpdateErrors{}
+	for i, err := range errs {
+		if err != nil {
+			updateErrs[coins[i].Symbol] = err
+		}
+	}
+
+	return updated, updateErrs
+}
@@ -0,0 +1,238 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+// concurrentClient is an HTTPClient that serves canned responses by path while tracking how many requests are in
+// flight at the same time
+type concurrentClient struct {
+	responses map[string]string
+	failures  map[string]bool
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *concurrentClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	// Give the other workers a chance to pile up
+	time.Sleep(5 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	if c.failures[req.URL.RequestURI()] {
+		return nil, errors.New("test Connection Error")
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(c.responses[req.URL.RequestURI()])),
+	}, nil
+}
+
+// newConcurrentClient creates a client serving rates for each symbol at the given USD rate
+func newConcurrentClient(usdRates map[string]string) *concurrentClient {
+	client := &concurrentClient{responses: map[string]string{}, failures: map[string]bool{}}
+	for symbol, usdRate := range usdRates {
+		client.responses[CBExchangeRateURL+"?currency="+symbol] =
+			`{"data":{"currency":"` + symbol + `","rates":{"USD":"` + usdRate + `"}}}`
+	}
+	return client
+}
+
+func TestUpdateNetProfit_Concurrent(t *testing.T) {
+
+	newWallet := func(concurrency int) *Wallet {
+		wallet := &Wallet{Coins: map[string]WarchestCoin{}, Concurrency: concurrency}
+		for _, symbol := range []string{"ETH", "DOGE", "SHIB", "ALGO", "BTC"} {
+			wallet.Coins[symbol] = WarchestCoin{Symbol: symbol,
+				Transactions: []CoinTransaction{{NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(10)}}}
+		}
+		return wallet
+	}
+	usdRates := map[string]string{"ETH": "10.0", "DOGE": "6.0", "SHIB": "5.0", "ALGO": "7.0", "BTC": "8.0"}
+
+	t.Run("Concurrency is bounded", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		wallet := newWallet(2)
+
+		netProfit, err := wallet.UpdateNetProfit(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client), true)
+
+		assert.Nil(t, err, "every coin was mocked, there should be no error")
+		assert.Equal(t, NewDecimal(2*(10+6+5+7+8)-5*10), netProfit, "should be the same")
+		assert.Equal(t, 2, client.maxInFlight, "no more than 2 coins should be updated at the same time")
+	})
+
+	t.Run("Results are merged into the wallet", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		wallet := newWallet(3)
+
+		wallet.UpdateNetProfit(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client), true)
+
+		for symbol, coin := range wallet.Coins {
+			assert.Equal(t, symbol, coin.Symbol, "should be the same")
+			assert.Equal(t, NewDecimal(10), coin.Cost, "cost should have been written back for %s", symbol)
+			assert.NotEqual(t, NewDecimal(0), coin.Rates["USD"], "rates should have been written back for %s", symbol)
+		}
+		assert.Equal(t, NewDecimal(2*10-10), wallet.Coins["ETH"].Profit, "should be the same")
+	})
+
+	t.Run("One failing coin does not abort the others", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		client.failures[CBExchangeRateURL+"?currency=SHIB"] = true
+		wallet := newWallet(DefaultConcurrency)
+
+		netProfit, err := wallet.UpdateNetProfit(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client), true)
+
+		var updateErrs UpdateErrors
+		assert.True(t, errors.As(err, &updateErrs), "should have been UpdateErrors")
+		assert.Equal(t, 1, len(updateErrs), "only SHIB should have failed")
+		assert.Equal(t, ErrConnection, updateErrs["SHIB"], "should be the same")
+		assert.Equal(t, NewDecimal(8), wallet.Coins["BTC"].Rates["USD"], "other coins should have been updated")
+		assert.Equal(t, NewDecimal(2*(10+6+7+8)-5*10), netProfit, "should be the same")
+	})
+}
+
+func TestUpdateCoinRates_WriteBack(t *testing.T) {
+
+	newWallet := func() *Wallet {
+		return &Wallet{Coins: map[string]WarchestCoin{
+			"ETH":  {Symbol: "ETH", Amount: NewDecimal(2), Cost: NewDecimal(10), Rates: CoinRates{"USD": NewDecimal(1)}},
+			"DOGE": {Symbol: "DOGE", Amount: NewDecimal(4), Cost: NewDecimal(8), Rates: CoinRates{"USD": NewDecimal(1)}},
+		}}
+	}
+
+	t.Run("Rates and profit are written back", func(t *testing.T) {
+		client := newConcurrentClient(map[string]string{"ETH": "10.0", "DOGE": "3.0"})
+		wallet := newWallet()
+
+		err := wallet.UpdateCoinRates(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client))
+
+		assert.Nil(t, err, "every coin was mocked, there should be no error")
+		eth, _ := wallet.Coin("ETH")
+		assert.Equal(t, NewDecimal(10), eth.Rates["USD"], "should be the same")
+		assert.Equal(t, NewDecimal(2*10-10), eth.Profit, "should be the same")
+		doge, _ := wallet.Coin("DOGE")
+		assert.Equal(t, NewDecimal(3), doge.Rates["USD"], "should be the same")
+		assert.Equal(t, NewDecimal(4*3-8), doge.Profit, "should be the same")
+		netProfit, _ := wallet.Totals()
+		assert.Equal(t, NewDecimal(10+4), netProfit, "the net profit should follow the refreshed coins")
+	})
+
+	t.Run("A second refresh replaces the first", func(t *testing.T) {
+		client := newConcurrentClient(map[string]string{"ETH": "10.0", "DOGE": "3.0"})
+		wallet := newWallet()
+		cb := NewCoinbaseClient(auth.CBAuth{}, client)
+		wallet.UpdateCoinRates(context.Background(), cb)
+
+		client.responses = newConcurrentClient(map[string]string{"ETH": "20.0", "DOGE": "1.0"}).responses
+		wallet.UpdateCoinRates(context.Background(), cb)
+
+		eth, _ := wallet.Coin("ETH")
+		assert.Equal(t, NewDecimal(20), eth.Rates["USD"], "should be the same")
+		assert.Equal(t, NewDecimal(2*20-10), eth.Profit, "should be the same")
+		netProfit, _ := wallet.Totals()
+		assert.Equal(t, NewDecimal(30+(4*1-8)), netProfit, "should be the same")
+	})
+
+	t.Run("Failing coins are reported", func(t *testing.T) {
+		client := newConcurrentClient(map[string]string{"ETH": "10.0"})
+		client.failures[CBExchangeRateURL+"?currency=DOGE"] = true
+		wallet := newWallet()
+
+		err := wallet.UpdateCoinRates(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client))
+
+		var updateErrs UpdateErrors
+		assert.True(t, errors.As(err, &updateErrs), "should have been UpdateErrors")
+		assert.Equal(t, ErrConnection, updateErrs["DOGE"], "should be the same")
+		eth, _ := wallet.Coin("ETH")
+		assert.Equal(t, NewDecimal(10), eth.Rates["USD"], "other coins should have been updated")
+	})
+}
+
+func TestWallet_ConcurrentReads(t *testing.T) {
+
+	client := newConcurrentClient(map[string]string{"ETH": "10.0", "DOGE": "3.0"})
+	cb := NewCoinbaseClient(auth.CBAuth{}, client)
+	wallet := &Wallet{Coins: map[string]WarchestCoin{
+		"ETH":  {Symbol: "ETH", Transactions: []CoinTransaction{{NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(10)}}},
+		"DOGE": {Symbol: "DOGE", Transactions: []CoinTransaction{{NumCoins: NewDecimal(4), PurchasedPrice: NewDecimal(8)}}},
+	}}
+
+	// Readers should never see a coin missing or a torn update while the wallet refreshes, run with -race
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, ok := wallet.Coin("ETH")
+				assert.True(t, ok, "ETH should always be in the wallet")
+				wallet.Totals()
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		wallet.UpdateNetProfit(context.Background(), cb, true)
+		wallet.SetCoin(WarchestCoin{Symbol: "ALGO", Amount: NewDecimal(1), Profit: NewDecimal(1)})
+	}
+	close(done)
+	wg.Wait()
+
+	eth, _ := wallet.Coin("ETH")
+	assert.Equal(t, NewDecimal(2*10-10), eth.Profit, "should be the same")
+	netProfit, _ := wallet.Totals()
+	assert.Equal(t, NewDecimal(10+(4*3-8)+1), netProfit, "should be the same")
+}
+
+func TestGetWarchestCoins_Concurrent(t *testing.T) {
+
+	client := newConcurrentClient(map[string]string{"DOGE": "2.0", "SHIB": "3.0"})
+	client.responses[CBAccountsURL+"?limit=25"] = `{"pagination":{"next_uri":null},"data":[` +
+		`{"id":"account-1","currency":{"code":"DOGE"}},{"id":"account-2","currency":{"code":"SHIB"}},` +
+		`{"id":"account-3","currency":{"code":"CTSI"}}]}`
+	client.responses["/v2/accounts/account-1/transactions?"+CBTransactionExpand+"&limit=25"] = transactionJSON
+	client.failures["/v2/accounts/account-2/transactions?"+CBTransactionExpand+"&limit=25"] = true
+
+	coins, skipped, err := GetWarchestCoins(context.Background(),
+		[]Provider{NewCoinbaseClient(auth.CBAuth{}, client)}, false, 2, DefaultCurrency, DefaultCostMethod,
+		CoinFilter{Deny: []string{"CTSI"}})
+
+	var updateErrs UpdateErrors
+	assert.True(t, errors.As(err, &updateErrs), "should have been UpdateErrors")
+	assert.Contains(t, updateErrs, "SHIB", "SHIB transactions should have failed")
+	assert.Equal(t, 2, len(coins), "failed coins should still be part of the wallet")
+	assert.Equal(t, NewDecimal(1), coins["DOGE"].Amount, "should be the same")
+	assert.Equal(t, NewDecimal(3), coins["SHIB"].Rates["USD"], "rates should still be updated when transactions fail")
+	assert.Equal(t, StatusFailed, coins["SHIB"].Status, "SHIB has no transactions to work out its profit from")
+	assert.Equal(t, StatusOK, coins["DOGE"].Status, "should be the same")
+	assert.False(t, coins["DOGE"].TransactionsFetchedAt.IsZero(), "should know when transactions were retrieved")
+	assert.Contains(t, err.Error(), "SHIB: error during request", "should be the same")
+	assert.Equal(t, []SkippedAccount{{Provider: CBProviderName, AccountID: "account-3", Symbol: "CTSI",
+		Reason: SkipDenied}}, skipped, "should be the same")
+}
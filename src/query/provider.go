@@ -0,0 +1,63 @@
+package query
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Holding is an account holding a single currency with a provider
+type Holding struct {
+	Provider  string  `json:"provider"`
+	AccountID string  `json:"account_id"`
+	Symbol    string  `json:"symbol"`
+	Balance   Decimal `json:"balance"`
+
+	// Exponent is the number of decimal places of the currency's smallest unit, 0 when the provider doesn't say
+	Exponent int `json:"exponent,omitempty"`
+
+	// Fiat and InterestBearing are used by the CoinFilter to skip holdings
+	Fiat            bool `json:"-"`
+	InterestBearing bool `json:"-"`
+}
+
+// Provider is an exchange that feeds the wallet (ie. coinbase or kraken), it lists the holdings of the account,
+// lists their normalized transactions, and quotes their current prices
+type Provider interface {
+	// Name identifies the provider in holdings, transactions and errors
+	Name() string
+
+	// Holdings lists every account held with the provider
+	Holdings(ctx context.Context) ([]Holding, error)
+
+	// Transactions lists the completed transactions of a holding
+	Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error)
+
+	// Rates quotes the current exchange rates of a symbol
+	Rates(ctx context.Context, symbol string) (CoinRates, error)
+}
+
+// SpotPricer retrieves the spot price of a symbol in a fiat currency on a given date, ie. CoinbaseClient
+type SpotPricer interface {
+	RetrieveSpotPrice(ctx context.Context, symbol, fiat string, date time.Time) (Decimal, error)
+}
+
+// valueAtSpotPrice values the transaction's coins at their spot price on the day of the transaction in fiat, false is
+// returned when the spot price isn't available
+func valueAtSpotPrice(ctx context.Context, prices SpotPricer, symbol, fiat string, transaction *CoinTransaction) bool {
+	if prices == nil {
+		return false
+	}
+
+	fiat = strings.ToUpper(fiat)
+	price, err := prices.RetrieveSpotPrice(ctx, symbol, fiat, transaction.Timestamp)
+	if err != nil {
+		log.Printf("Failed retrieving spot price for transaction %s: %s", transaction.ID, err)
+		return false
+	}
+
+	transaction.Currency = fiat
+	transaction.PurchasedPrice = transaction.NumCoins.Mul(price)
+	return true
+}
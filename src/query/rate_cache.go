@@ -0,0 +1,163 @@
+package query
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRateTTL is how long exchange rates are cached before being retrieved again
+const DefaultRateTTL = time.Minute
+
+// DefaultRateFetchTimeout is how long a shared lookup of exchange rates is given, whoever is waiting on it
+const DefaultRateFetchTimeout = 30 * time.Second
+
+// RateCacheStats are the hit/miss statistics of a RateCache
+type RateCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Shared uint64 `json:"shared"`
+}
+
+// RateCache caches exchange rates for TTL, and makes sure concurrent lookups of the same rates share a single
+// request. Coinbase quotes every fiat currency for a symbol in one call, so entries are keyed by symbol and Rate
+// picks a single fiat currency out of them.
+type RateCache struct {
+	TTL time.Duration
+
+	// FetchTimeout limits how long a lookup takes, DefaultRateFetchTimeout is used when unset
+	FetchTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]rateEntry
+	calls   map[string]*rateCall
+	stats   RateCacheStats
+	now     func() time.Time
+}
+
+// rateEntry is a cached set of exchange rates
+type rateEntry struct {
+	rates     CoinRates
+	fetchedAt time.Time
+}
+
+// rateCall is an in-flight lookup that other callers can wait on
+type rateCall struct {
+	done  chan struct{}
+	rates CoinRates
+	err   error
+}
+
+// rateFetcher retrieves the exchange rates for a symbol when they aren't cached
+type rateFetcher func(ctx context.Context, symbol string) (CoinRates, error)
+
+// NewRateCache creates an empty cache that keeps rates for the given TTL
+func NewRateCache(ttl time.Duration) *RateCache {
+	return &RateCache{
+		TTL:          ttl,
+		FetchTimeout: DefaultRateFetchTimeout,
+		entries:      map[string]rateEntry{},
+		calls:        map[string]*rateCall{},
+		now:          time.Now,
+	}
+}
+
+// Get returns the cached rates for the symbol, using fetch to retrieve them when they are missing or expired.
+// Failed lookups aren't cached. The lookup is shared by every caller waiting on it, so it isn't cancelled along with
+// the caller that started it, while every caller stops waiting when their own ctx is done.
+func (r *RateCache) Get(ctx context.Context, symbol string, fetch rateFetcher) (CoinRates, error) {
+
+	r.mu.Lock()
+
+	if entry, ok := r.entries[symbol]; ok && r.now().Sub(entry.fetchedAt) < r.TTL {
+		r.stats.Hits++
+		r.mu.Unlock()
+		return entry.rates, nil
+	}
+
+	// Someone is already retrieving these rates, wait for them instead of making another request
+	if call, ok := r.calls[symbol]; ok {
+		r.stats.Shared++
+		r.mu.Unlock()
+		return call.wait(ctx)
+	}
+
+	r.stats.Misses++
+	call := &rateCall{done: make(chan struct{})}
+	r.calls[symbol] = call
+	r.mu.Unlock()
+
+	go r.fetch(ctx, symbol, fetch, call)
+	return call.wait(ctx)
+}
+
+// fetch runs a lookup on a context of its own, keeping the values of ctx but not its deadline or cancellation
+func (r *RateCache) fetch(ctx context.Context, symbol string, fetch rateFetcher, call *rateCall) {
+	timeout := r.FetchTimeout
+	if timeout <= 0 {
+		timeout = DefaultRateFetchTimeout
+	}
+	fetchCtx, cancel := context.WithTimeout(detachedContext{ctx}, timeout)
+	defer cancel()
+
+	call.rates, call.err = fetch(fetchCtx, symbol)
+
+	r.mu.Lock()
+	if call.err == nil {
+		r.entries[symbol] = rateEntry{rates: call.rates, fetchedAt: r.now()}
+	}
+	delete(r.calls, symbol)
+	r.mu.Unlock()
+	close(call.done)
+}
+
+// wait waits for the lookup to finish unless ctx is done first
+func (c *rateCall) wait(ctx context.Context) (CoinRates, error) {
+	select {
+	case <-ctx.Done():
+		return CoinRates{}, ctx.Err()
+	case <-c.done:
+		return c.rates, c.err
+	}
+}
+
+// detachedContext keeps the values of its parent (ie. the retry budget) without its deadline or cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// Rate returns the cached exchange rate of the symbol in the given fiat currency
+func (r *RateCache) Rate(ctx context.Context, symbol, fiat string, fetch rateFetcher) (Decimal, error) {
+	rates, err := r.Get(ctx, symbol, fetch)
+	if err != nil {
+		return Decimal{}, err
+	}
+	return rates.Rate(fiat), nil
+}
+
+// Stats returns the hit/miss statistics of the cache
+func (r *RateCache) Stats() RateCacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// LogStats logs the hit/miss statistics of the cache
+func (r *RateCache) LogStats() {
+	stats := r.Stats()
+	log.Printf("Rate cache stats: %d hit(s), %d miss(es), %d shared lookup(s)", stats.Hits, stats.Misses,
+		stats.Shared)
+}
@@ -0,0 +1,211 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestRateCache(t *testing.T) {
+
+	ctx := context.Background()
+	ethRates := CoinRates{"EUR": NewDecimal(11), "GBP": NewDecimal(10), "USD": NewDecimal(12)}
+
+	t.Run("Entries expire after the TTL", func(t *testing.T) {
+		now := time.Now()
+		cache := NewRateCache(time.Minute)
+		cache.now = func() time.Time { return now }
+
+		fetches := 0
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			fetches++
+			return ethRates, nil
+		}
+
+		cache.Get(ctx, "ETH", fetch)
+		rates, err := cache.Get(ctx, "ETH", fetch)
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, ethRates, rates, "should be the same")
+		assert.Equal(t, 1, fetches, "the second lookup should have been a hit")
+
+		now = now.Add(time.Minute)
+		cache.Get(ctx, "ETH", fetch)
+		assert.Equal(t, 2, fetches, "the entry should have expired")
+		assert.Equal(t, RateCacheStats{Hits: 1, Misses: 2}, cache.Stats(), "should be the same")
+	})
+
+	t.Run("Rate is keyed by fiat", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			return ethRates, nil
+		}
+
+		usdRate, _ := cache.Rate(ctx, "ETH", "USD", fetch)
+		eurRate, _ := cache.Rate(ctx, "ETH", "eur", fetch)
+		unknownRate, _ := cache.Rate(ctx, "ETH", "JPY", fetch)
+
+		assert.Equal(t, NewDecimal(12), usdRate, "should be the same")
+		assert.Equal(t, NewDecimal(11), eurRate, "should be the same")
+		assert.Equal(t, NewDecimal(0), unknownRate, "should be the same")
+		assert.Equal(t, RateCacheStats{Hits: 2, Misses: 1}, cache.Stats(), "should be the same")
+	})
+
+	t.Run("Failures are not cached", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+		fetches := 0
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			fetches++
+			return CoinRates{}, ErrConnection
+		}
+
+		_, err := cache.Get(ctx, "ETH", fetch)
+		assert.Equal(t, ErrConnection, err, "should be the same")
+		cache.Get(ctx, "ETH", fetch)
+		assert.Equal(t, 2, fetches, "the failure should not have been cached")
+	})
+
+	t.Run("Concurrent lookups share a single request", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+
+		var mu sync.Mutex
+		fetches := 0
+		release := make(chan struct{})
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			mu.Lock()
+			fetches++
+			mu.Unlock()
+			<-release
+			return ethRates, nil
+		}
+
+		// The first lookup blocks until released, every other lookup joins it
+		results := make(chan CoinRates, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rates, _ := cache.Get(ctx, "ETH", fetch)
+				results <- rates
+			}()
+		}
+
+		// Wait for everyone to be waiting on the first lookup
+		for cache.Stats().Shared < 9 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+		close(results)
+
+		for rates := range results {
+			assert.Equal(t, ethRates, rates, "every lookup should have the same rates")
+		}
+		assert.Equal(t, 1, fetches, "should be the same")
+		assert.Equal(t, RateCacheStats{Misses: 1, Shared: 9}, cache.Stats(), "should be the same")
+	})
+
+	t.Run("Waiting lookups respect their context", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+		release := make(chan struct{})
+		defer close(release)
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			<-release
+			return ethRates, nil
+		}
+
+		go cache.Get(ctx, "ETH", fetch)
+		for cache.Stats().Misses == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := cache.Get(cancelled, "ETH", fetch)
+		assert.True(t, errors.Is(err, context.Canceled), "should be the same")
+	})
+
+	t.Run("Cancelling the first lookup doesn't fail the others", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+		release := make(chan struct{})
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			select {
+			case <-ctx.Done():
+				return CoinRates{}, ctx.Err()
+			case <-release:
+				return ethRates, nil
+			}
+		}
+
+		first, cancel := context.WithCancel(ctx)
+		firstErr := make(chan error)
+		go func() {
+			_, err := cache.Get(first, "ETH", fetch)
+			firstErr <- err
+		}()
+		for cache.Stats().Misses == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		waiting := make(chan CoinRates)
+		go func() {
+			rates, _ := cache.Get(ctx, "ETH", fetch)
+			waiting <- rates
+		}()
+		for cache.Stats().Shared == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		assert.True(t, errors.Is(<-firstErr, context.Canceled), "the first lookup should stop waiting")
+		close(release)
+		assert.Equal(t, ethRates, <-waiting, "the shared lookup should have carried on")
+
+		rates, _ := cache.Get(ctx, "ETH", fetch)
+		assert.Equal(t, ethRates, rates, "should be the same")
+		assert.Equal(t, uint64(1), cache.Stats().Misses, "the rates should have been cached")
+	})
+
+	t.Run("Shared lookups time out on their own", func(t *testing.T) {
+		cache := NewRateCache(time.Minute)
+		cache.FetchTimeout = time.Millisecond
+		fetch := func(ctx context.Context, symbol string) (CoinRates, error) {
+			<-ctx.Done()
+			return CoinRates{}, ctx.Err()
+		}
+
+		_, err := cache.Get(ctx, "ETH", fetch)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "should be the same")
+	})
+}
+
+func TestRetrieveCoinRates_Cached(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	cb.RateCache = NewRateCache(time.Minute)
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+CBExchangeRateURL,
+		httpmock.NewStringResponder(200, `{"data":{"currency":"ETH","rates":{"USD":"12.99"}}}`))
+
+	// Refresh the same coin twice, as happens when the wallet is polled
+	testCoin := WarchestCoin{Symbol: "ETH"}
+	testCoin.UpdateRates(context.Background(), cb)
+	testCoin.Rates = CoinRates{}
+	testCoin.UpdateRates(context.Background(), cb)
+
+	assert.Equal(t, MustDecimal("12.99"), testCoin.Rates["USD"], "should be the same")
+	assert.Equal(t, 1, httpmock.GetTotalCallCount(), "the second refresh should have used the cache")
+	assert.Equal(t, RateCacheStats{Hits: 1, Misses: 1}, cb.RateCache.Stats(), "should be the same")
+}
@@ -1,15 +1,17 @@
 package query
 
 import (
+	"context"
 	"encoding/json"
-	"io"
-	"log"
-	"net/http"
+	"strings"
 )
 
 // CBExchangeRateURL is the url path for retrieving exchange rates
 const CBExchangeRateURL = "/v2/exchange-rates"
 
+// DefaultCurrency is the base currency cost and profit are calculated in when one isn't chosen
+const DefaultCurrency = "USD"
+
 // CoinInfoResp is the unmarshalled object created by a get request to retreive a coin's rate
 type CoinInfoResp struct {
 	Info CoinInfo `json:"data"`
@@ -21,43 +23,50 @@ type CoinInfo struct {
 	Rates    CoinRates `json:"rates"`
 }
 
-// CoinRates the object that contains the various exchange rates for a given coin
-type CoinRates struct {
-	EUR float64 `json:"EUR,string"`
-	GBP float64 `json:"GBP,string"`
-	USD float64 `json:"USD,string"`
-}
-
-// CBRetrieveCoinRates will return exchange rates for a given Crypto Currency Symbol
-func CBRetrieveCoinRates(symbol string, client HTTPClient) (CoinRates, error) {
-	url := CBBaseURL + CBExchangeRateURL + "?currency=" + symbol
-
-	req, err := http.NewRequest("GET", url, nil)
+// CoinRates are the exchange rates for a given coin keyed by currency (ie. USD, CHF or SEK)
+type CoinRates map[string]Decimal
 
-	// Retrieve response
-	resp, err := client.Do(req)
+// UnmarshalJSON parses every rate in the response, coinbase quotes rates as strings while numbers are accepted too
+func (c *CoinRates) UnmarshalJSON(data []byte) error {
+	rawRates := map[string]Decimal{}
+	if err := json.Unmarshal(data, &rawRates); err != nil {
+		return err
+	}
 
-	//TODO: Create custom error for connectivity failures
-	if err != nil {
-		log.Printf("Hit error on retrieval: %s", err)
-		return CoinRates{}, ErrConnection
+	rates := CoinRates{}
+	for currency, rate := range rawRates {
+		rates[strings.ToUpper(currency)] = rate
 	}
-	defer resp.Body.Close()
+	*c = rates
+	return nil
+}
 
-	cResp := CoinInfoResp{}
+// Rate returns the exchange rate for the given currency, 0 if the currency isn't quoted
+func (c CoinRates) Rate(currency string) Decimal {
+	return c[strings.ToUpper(currency)]
+}
+
+// Rates quotes the current exchange rates of a symbol, see RetrieveCoinRates
+func (c *CoinbaseClient) Rates(ctx context.Context, symbol string) (CoinRates, error) {
+	return c.RetrieveCoinRates(ctx, symbol)
+}
 
-	//TODO: Create custom error for failures to read respBody as a string
-	bodyAsStr, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed to read body of error: %s", err)
-		return CoinRates{}, ErrDecoding
+// RetrieveCoinRates will return exchange rates for a given Crypto Currency Symbol, using the client's RateCache when
+// one is set
+func (c *CoinbaseClient) RetrieveCoinRates(ctx context.Context, symbol string) (CoinRates, error) {
+	if c.RateCache != nil {
+		return c.RateCache.Get(ctx, symbol, c.fetchCoinRates)
 	}
+	return c.fetchCoinRates(ctx, symbol)
+}
+
+// fetchCoinRates retrieves the exchange rates for a given Crypto Currency Symbol from coinbase
+func (c *CoinbaseClient) fetchCoinRates(ctx context.Context, symbol string) (CoinRates, error) {
 
-	//TODO: Create custom error for unmarshalling issues
-	if err := json.Unmarshal([]byte(bodyAsStr), &cResp); err != nil {
-		log.Printf("%s", err)
-		return CoinRates{}, ErrOnUnmarshall
+	cResp := CoinInfoResp{}
+	if err := c.get(ctx, CBExchangeRateURL+"?currency="+symbol, false, &cResp); err != nil {
+		return CoinRates{}, err
 	}
 
-	return cResp.Info.Rates, err
+	return cResp.Info.Rates, nil
 }
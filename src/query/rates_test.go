@@ -1,11 +1,13 @@
 package query
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/gock.v1"
 	"net/http"
 	"testing"
 	"time"
+	"warchest/src/auth"
 )
 
 // TODO: should be using a mock (spy) so we aren't making http requests
@@ -17,8 +19,7 @@ func TestRetrieveCoinData(t *testing.T) {
 		Timeout: time.Second * 10,
 	}
 
-	var absClient HTTPClient
-	absClient = &client
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
 
 	t.Run("Happy Path", func(t *testing.T) {
 
@@ -30,19 +31,51 @@ func TestRetrieveCoinData(t *testing.T) {
 			Reply(200).
 			BodyString(json)
 
-		coinRates, err := CBRetrieveCoinRates(symbol, absClient)
+		coinRates, err := cb.RetrieveCoinRates(context.Background(), symbol)
 		assert.Nil(t, err, "failed to retrieve rates")
-		assert.Equal(t, 12.00, coinRates.USD, "Should be the same")
-		assert.Equal(t, 11.00, coinRates.EUR, "Should be the same")
-		assert.Equal(t, 10.00, coinRates.GBP, "Should be the same")
+		assert.Equal(t, NewDecimal(12), coinRates["USD"], "Should be the same")
+		assert.Equal(t, NewDecimal(11), coinRates["EUR"], "Should be the same")
+		assert.Equal(t, NewDecimal(10), coinRates["GBP"], "Should be the same")
 		assert.NotNil(t, coinRates, "no rates found!")
 	})
 
+	t.Run("Every currency", func(t *testing.T) {
+
+		json := `{"data": {"currency": "ETH", "rates": {"USD": "12.0", "CHF": "11.5", "SEK": "120.25", "btc": 0.05}}}`
+		// Establish Mock
+		defer gock.Off()
+		gock.New(CBBaseURL).
+			Get(CBExchangeRateURL).
+			Reply(200).
+			BodyString(json)
+
+		coinRates, err := cb.RetrieveCoinRates(context.Background(), symbol)
+		assert.Nil(t, err, "failed to retrieve rates")
+		assert.Equal(t, CoinRates{"USD": NewDecimal(12), "CHF": MustDecimal("11.5"), "SEK": MustDecimal("120.25"), "BTC": MustDecimal("0.05")}, coinRates,
+			"should be the same")
+		assert.Equal(t, MustDecimal("120.25"), coinRates.Rate("sek"), "currencies should be case insensitive")
+		assert.Equal(t, NewDecimal(0), coinRates.Rate("NOK"), "missing currencies should have no rate")
+	})
+
+	t.Run("Unparseable rate", func(t *testing.T) {
+
+		// Establish Mock
+		defer gock.Off()
+		gock.New(CBBaseURL).
+			Get(CBExchangeRateURL).
+			Reply(200).
+			BodyString(`{"data": {"currency": "ETH", "rates": {"USD": "twelve"}}}`)
+
+		_, err := cb.RetrieveCoinRates(context.Background(), symbol)
+
+		assert.Equal(t, ErrOnUnmarshall, err, "This call should have produced a JSON parse error")
+	})
+
 	t.Run("Rainy Day connectivity!", func(t *testing.T) {
 
 		mockClient := &MockClient{}
 
-		_, err := CBRetrieveCoinRates(symbol, mockClient)
+		_, err := NewCoinbaseClient(auth.CBAuth{}, mockClient).RetrieveCoinRates(context.Background(), symbol)
 
 		// There should have been a connection error
 		assert.Equal(t, ErrConnection, err, "this should be a connection error")
@@ -57,7 +90,7 @@ func TestRetrieveCoinData(t *testing.T) {
 			Reply(200).
 			BodyString(`[asdf,[],!}`)
 
-		_, err := CBRetrieveCoinRates(symbol, absClient)
+		_, err := cb.RetrieveCoinRates(context.Background(), symbol)
 
 		// There should have been a connection error
 		assert.Equal(t, ErrOnUnmarshall, err, "This call should have produced a JSON parse error")
@@ -73,7 +106,7 @@ func TestRetrieveCoinData(t *testing.T) {
 			Reply(200).
 			SetHeader("Content-Length", "1")
 
-		_, err := CBRetrieveCoinRates(symbol, absClient)
+		_, err := cb.RetrieveCoinRates(context.Background(), symbol)
 
 		assert.NotNil(t, err, "This call should have produced a read error for the response body")
 	})
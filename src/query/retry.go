@@ -0,0 +1,284 @@
+package query
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRetries is the number of times a request is retried before giving up
+const DefaultMaxRetries = 3
+
+// DefaultBaseDelay is the initial delay between retries, it doubles for every attempt
+const DefaultBaseDelay = 500 * time.Millisecond
+
+// DefaultMaxDelay is the longest a request will wait before being retried, including waits requested by the
+// server via Retry-After. Retries of signed requests are signed again (see RequestBuilder), so waiting doesn't run
+// into coinbase's 30 second signature window.
+const DefaultMaxDelay = 10 * time.Second
+
+// DefaultRequestsPerSecond keeps requests under coinbase's limit of 10,000 requests per hour per api key
+// Ref: https://developers.coinbase.com/api/v2#rate-limiting
+const DefaultRequestsPerSecond = 2.5
+
+// DefaultBurst is the number of requests that can be made back to back before rate limiting kicks in
+const DefaultBurst = 10
+
+// retryBudgetKey is the context key holding a per-call retry budget
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a context that overrides the number of retries a RetryClient makes for requests using it
+func WithRetryBudget(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, retries)
+}
+
+// RequestBuilder builds the request sent by an attempt, signed requests are built for every attempt so that retries
+// carry a fresh timestamp (or nonce) rather than replaying a signature the server may no longer accept
+type RequestBuilder func() (*http.Request, error)
+
+// RetryClient is an HTTPClient that retries failed requests with exponential backoff and jitter while keeping
+// requests to each host under a rate limit
+type RetryClient struct {
+	Client     HTTPClient
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Limiter    *HostLimiter
+
+	// sleep waits for the given duration, returning early with an error if the context is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryClient wraps the given HTTPClient with the default retry and rate limiting behavior
+func NewRetryClient(client HTTPClient) *RetryClient {
+	return &RetryClient{
+		Client:     client,
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBaseDelay,
+		MaxDelay:   DefaultMaxDelay,
+		Limiter:    NewHostLimiter(DefaultRequestsPerSecond, DefaultBurst),
+	}
+}
+
+// Do sends the request, retrying on connection errors, 429s and 5xx responses. The same request is sent by every
+// attempt, use DoBuilder for requests that have to be signed again.
+func (r *RetryClient) Do(req *http.Request) (*http.Response, error) {
+	return r.DoBuilder(req.Context(), reuseRequest(req))
+}
+
+// DoBuilder sends the request built by build, building it again for every attempt and retrying on connection
+// errors, 429s and 5xx responses. The last response (or error) is returned once the retry budget is spent.
+func (r *RetryClient) DoBuilder(ctx context.Context, build RequestBuilder) (*http.Response, error) {
+
+	retries := r.MaxRetries
+	if budget, ok := ctx.Value(retryBudgetKey{}).(int); ok {
+		retries = budget
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+
+		// Requests are signed as they're built, so build the request again after waiting for the rate limit
+		if r.Limiter != nil {
+			if wait := r.Limiter.reserve(req.URL.Host, time.Now()); wait > 0 {
+				if err := r.wait(ctx, wait); err != nil {
+					return nil, err
+				}
+				if req, err = build(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		resp, err := r.Client.Do(req)
+		if !isRetryable(resp, err) || attempt >= retries {
+			return resp, err
+		}
+
+		delay, ok := r.delay(attempt, resp)
+		if !ok {
+			log.Printf("Not retrying %s, server asked to wait longer than %s", req.URL.Path, r.MaxDelay)
+			return resp, err
+		}
+
+		// Release the failed response before trying again
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			log.Printf("Retrying %s in %s after status %d (attempt %d of %d)", req.URL.Path, delay,
+				resp.StatusCode, attempt+1, retries)
+		} else {
+			log.Printf("Retrying %s in %s after error: %s (attempt %d of %d)", req.URL.Path, delay, err,
+				attempt+1, retries)
+		}
+
+		if err := r.wait(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reuseRequest builds the same request for every attempt, with a fresh copy of its body
+func reuseRequest(req *http.Request) RequestBuilder {
+	attempts := 0
+	return func() (*http.Request, error) {
+		attempts++
+		if attempts > 1 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		return req, nil
+	}
+}
+
+// delay determines how long to wait before the next attempt. Retry-After is honored when present, and false is
+// returned if the server asks for a longer wait than MaxDelay allows.
+func (r *RetryClient) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return retryAfter, retryAfter <= r.MaxDelay
+		}
+	}
+
+	// Exponential backoff with jitter, somewhere between half and all of the backoff window
+	backoff := r.BaseDelay << uint(attempt)
+	if backoff <= 0 || backoff > r.MaxDelay {
+		backoff = r.MaxDelay
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1)), true
+}
+
+// wait sleeps for the given duration unless the context finishes first
+func (r *RetryClient) wait(ctx context.Context, d time.Duration) error {
+	if r.sleep != nil {
+		return r.sleep(ctx, d)
+	}
+	return sleepContext(ctx, d)
+}
+
+// isRetryable determines if the outcome of a request is worth trying again
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// sleepContext sleeps for the given duration unless the context finishes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//
+// Rate Limiting
+////////////////////
+
+// HostLimiter keeps a token bucket per host so requests to each host stay under a given rate
+type HostLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the state of the rate limit for a single host
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewHostLimiter creates a limiter allowing rate requests per second to each host, with bursts of up to burst
+// requests
+func NewHostLimiter(rate float64, burst int) *HostLimiter {
+	return &HostLimiter{Rate: rate, Burst: burst, buckets: map[string]*tokenBucket{}}
+}
+
+// Wait blocks until a request to the host is allowed, using sleep to wait for a token to become available
+func (l *HostLimiter) Wait(ctx context.Context, host string, sleep func(context.Context, time.Duration) error) error {
+	delay := l.reserve(host, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	return sleep(ctx, delay)
+}
+
+// reserve takes a token from the host's bucket, returning how long the caller must wait before the token is
+// actually available
+func (l *HostLimiter) reserve(host string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Rate <= 0 {
+		return 0
+	}
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+
+	bucket, ok := l.buckets[host]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[host] = bucket
+	}
+
+	// Refill based on the time passed since the last request, never beyond the burst size
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.Rate
+	if bucket.tokens > float64(l.Burst) {
+		bucket.tokens = float64(l.Burst)
+	}
+	bucket.last = now
+
+	// Tokens can go negative, which queues up callers behind each other
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,268 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+// newTestRetryClient creates a RetryClient that records waits instead of sleeping
+func newTestRetryClient(client HTTPClient, waits *[]time.Duration) *RetryClient {
+	retryClient := NewRetryClient(client)
+	retryClient.Limiter = nil
+	retryClient.sleep = func(_ context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return retryClient
+}
+
+// newStatusResponse creates a response with the given status and Retry-After header
+func newStatusResponse(status int, retryAfter string) *http.Response {
+	resp := httpmock.NewStringResponse(status, `{"errors":[{"id":"rate_limit_exceeded","message":"Too many requests"}]}`)
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestRetryClient(t *testing.T) {
+
+	symbol := "ETH"
+	ratesURL := CBBaseURL + CBExchangeRateURL
+	ratesJSON := `{"data":{"currency":"ETH","rates":{"USD":"12.99","EUR":"11.99","GBP":"10.99"}}}`
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	t.Run("Retries 429 and 5xx until success", func(t *testing.T) {
+		waits := []time.Duration{}
+		cb := NewCoinbaseClient(auth.CBAuth{}, newTestRetryClient(&client, &waits))
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+			newStatusResponse(http.StatusTooManyRequests, "2"),
+			newStatusResponse(http.StatusServiceUnavailable, ""),
+			httpmock.NewStringResponse(200, ratesJSON),
+		}))
+
+		coinRates, err := cb.RetrieveCoinRates(context.Background(), symbol)
+
+		assert.Nil(t, err, "the last attempt succeeded, there should be no error")
+		assert.Equal(t, MustDecimal("12.99"), coinRates["USD"], "should be the same")
+		assert.Equal(t, 3, httpmock.GetTotalCallCount(), "there should have been two retries")
+		assert.Equal(t, 2*time.Second, waits[0], "Retry-After should have been honored")
+		assert.True(t, waits[1] >= DefaultBaseDelay && waits[1] <= 2*DefaultBaseDelay,
+			"backoff should be jittered within the second window")
+	})
+
+	t.Run("Retries connection errors", func(t *testing.T) {
+		waits := []time.Duration{}
+		cb := NewCoinbaseClient(auth.CBAuth{}, newTestRetryClient(&client, &waits))
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		attempts := 0
+		httpmock.RegisterResponder("GET", ratesURL, func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("connection reset by peer")
+			}
+			return httpmock.NewStringResponse(200, ratesJSON), nil
+		})
+
+		_, err := cb.RetrieveCoinRates(context.Background(), symbol)
+
+		assert.Nil(t, err, "the last attempt succeeded, there should be no error")
+		assert.Equal(t, 3, attempts, "should be the same")
+		assert.True(t, waits[0] >= DefaultBaseDelay/2 && waits[0] <= DefaultBaseDelay,
+			"backoff should be jittered within the first window")
+		assert.True(t, waits[1] >= DefaultBaseDelay && waits[1] <= 2*DefaultBaseDelay,
+			"backoff should double for every attempt")
+	})
+
+	t.Run("Gives up once the budget is spent", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL,
+			httpmock.ResponderFromResponse(newStatusResponse(http.StatusBadGateway, "")))
+
+		req, _ := http.NewRequest("GET", ratesURL, nil)
+		resp, err := retryClient.Do(req)
+
+		assert.Nil(t, err, "the last response should be returned as is")
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode, "should be the same")
+		assert.Equal(t, DefaultMaxRetries+1, httpmock.GetTotalCallCount(), "should be the same")
+		assert.Equal(t, DefaultMaxRetries, len(waits), "should be the same")
+	})
+
+	t.Run("Per-call retry budget", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL,
+			httpmock.ResponderFromResponse(newStatusResponse(http.StatusInternalServerError, "")))
+
+		req, _ := http.NewRequestWithContext(WithRetryBudget(context.Background(), 1), "GET", ratesURL, nil)
+		resp, _ := retryClient.Do(req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "should be the same")
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "only one retry should have been made")
+	})
+
+	t.Run("Does not retry client errors", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL, httpmock.NewStringResponder(http.StatusUnauthorized, `{}`))
+
+		req, _ := http.NewRequest("GET", ratesURL, nil)
+		resp, _ := retryClient.Do(req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "should be the same")
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), "should be the same")
+		assert.Empty(t, waits, "should not have waited")
+	})
+
+	t.Run("Retry-After longer than the max delay", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL,
+			httpmock.ResponderFromResponse(newStatusResponse(http.StatusTooManyRequests, "3600")))
+
+		req, _ := http.NewRequest("GET", ratesURL, nil)
+		resp, _ := retryClient.Do(req)
+
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "should be the same")
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), "should have given up instead of waiting an hour")
+	})
+
+	t.Run("Builds the request for every attempt", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		signatures := []string{}
+		httpmock.RegisterResponder("GET", ratesURL, func(req *http.Request) (*http.Response, error) {
+			signatures = append(signatures, req.Header.Get(auth.CBAccessSign))
+			if len(signatures) < 3 {
+				return newStatusResponse(http.StatusServiceUnavailable, ""), nil
+			}
+			return httpmock.NewStringResponse(200, ratesJSON), nil
+		})
+
+		builds := 0
+		resp, err := retryClient.DoBuilder(context.Background(), func() (*http.Request, error) {
+			builds++
+			req, _ := http.NewRequest("GET", ratesURL, nil)
+			req.Header.Set(auth.CBAccessSign, "signature-"+strconv.Itoa(builds))
+			return req, nil
+		})
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "should be the same")
+		assert.Equal(t, []string{"signature-1", "signature-2", "signature-3"}, signatures,
+			"every attempt should be signed again")
+	})
+
+	t.Run("Builds the request again after waiting for the rate limit", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+		retryClient.Limiter = NewHostLimiter(1, 1)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL, httpmock.NewStringResponder(200, ratesJSON))
+
+		builds := 0
+		build := func() (*http.Request, error) {
+			builds++
+			return http.NewRequest("GET", ratesURL, nil)
+		}
+		retryClient.DoBuilder(context.Background(), build)
+		retryClient.DoBuilder(context.Background(), build)
+
+		assert.Equal(t, 1, len(waits), "the second request should have waited for the rate limit")
+		assert.Equal(t, 3, builds, "the second request should have been built again after waiting")
+	})
+
+	t.Run("Failing to build the request", func(t *testing.T) {
+		waits := []time.Duration{}
+		retryClient := newTestRetryClient(&client, &waits)
+
+		_, err := retryClient.DoBuilder(context.Background(), func() (*http.Request, error) {
+			return nil, ErrInvalidCredentials
+		})
+
+		assert.Equal(t, ErrInvalidCredentials, err, "should be the same")
+		assert.Empty(t, waits, "should not have retried")
+	})
+
+	t.Run("Cancelled context stops retries", func(t *testing.T) {
+		retryClient := NewRetryClient(&client)
+		retryClient.Limiter = nil
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", ratesURL,
+			httpmock.ResponderFromResponse(newStatusResponse(http.StatusServiceUnavailable, "")))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", ratesURL, nil)
+		_, err := retryClient.Do(req)
+
+		assert.NotNil(t, err, "a cancelled context should stop the retries")
+	})
+}
+
+func TestHostLimiter(t *testing.T) {
+
+	now := time.Now()
+	limiter := NewHostLimiter(2, 2)
+
+	t.Run("Allows bursts", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), limiter.reserve("api.coinbase.com", now))
+		assert.Equal(t, time.Duration(0), limiter.reserve("api.coinbase.com", now))
+	})
+
+	t.Run("Queues requests past the burst", func(t *testing.T) {
+		assert.Equal(t, 500*time.Millisecond, limiter.reserve("api.coinbase.com", now))
+		assert.Equal(t, time.Second, limiter.reserve("api.coinbase.com", now))
+	})
+
+	t.Run("Hosts have their own bucket", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), limiter.reserve("localhost", now))
+	})
+
+	t.Run("Refills over time", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), limiter.reserve("api.coinbase.com", now.Add(3*time.Second)))
+	})
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshInterval is how often the wallet is refreshed in the background
+const DefaultRefreshInterval = 5 * time.Minute
+
+// DefaultLoadBaseDelay is the wait after the first failed attempt at loading the wallet, it doubles with every failure
+const DefaultLoadBaseDelay = time.Second
+
+// DefaultLoadMaxDelay is the longest wait between attempts at loading the wallet
+const DefaultLoadMaxDelay = 2 * time.Minute
+
+// WalletLoader builds the wallet from scratch, returning an error only when there's no wallet to show at all
+type WalletLoader func(ctx context.Context) (*Wallet, error)
+
+// WalletRefresher brings the coins of a wallet up to date in place
+type WalletRefresher func(ctx context.Context, wallet *Wallet) error
+
+// WalletServiceStatus describes when the wallet was last loaded or refreshed
+type WalletServiceStatus struct {
+	Loaded      bool      `json:"loaded"`
+	LoadedAt    time.Time `json:"loaded_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// WalletService serves a snapshot of the wallet that is refreshed in the background every Interval. A snapshot is
+// never updated once published, a refresh updates a copy and then swaps it in, so readers don't need to lock it.
+type WalletService struct {
+	Interval  time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	load    WalletLoader
+	refresh WalletRefresher
+	now     func() time.Time
+
+	// sleep waits for the given duration, returning early with an error if the context is done
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu       sync.RWMutex
+	snapshot *Wallet
+	status   WalletServiceStatus
+}
+
+// NewWalletService creates a service that loads the wallet with load and then refreshes it with refresh every interval
+func NewWalletService(load WalletLoader, refresh WalletRefresher, interval time.Duration) *WalletService {
+	return &WalletService{
+		Interval:  interval,
+		BaseDelay: DefaultLoadBaseDelay,
+		MaxDelay:  DefaultLoadMaxDelay,
+		load:      load,
+		refresh:   refresh,
+		now:       time.Now,
+	}
+}
+
+// Wallet returns the current snapshot of the wallet, which must not be modified. ErrWalletNotLoaded is returned until
+// the wallet has been loaded.
+func (s *WalletService) Wallet() (*Wallet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.snapshot == nil {
+		return nil, ErrWalletNotLoaded
+	}
+	return s.snapshot, nil
+}
+
+// Status describes when the wallet was last loaded or refreshed
+func (s *WalletService) Status() WalletServiceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Run loads the wallet, retrying with backoff until it succeeds, and then refreshes it every Interval until the
+// context is done
+func (s *WalletService) Run(ctx context.Context) error {
+	if err := s.LoadWithRetry(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s.Refresh(ctx)
+		}
+	}
+}
+
+// LoadWithRetry loads the wallet, waiting longer after every failed attempt until it succeeds or the context is done
+func (s *WalletService) LoadWithRetry(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		err := s.Load(ctx)
+		if err == nil {
+			return nil
+		}
+
+		delay := s.delay(attempt)
+		log.Printf("Failed loading the wallet, trying again in %s: %s", delay, err)
+		if err := s.wait(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Load builds the wallet from scratch and publishes it as the current snapshot
+func (s *WalletService) Load(ctx context.Context) error {
+	wallet, err := s.load(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Attempts++
+	if err != nil {
+		s.status.LastError = err.Error()
+		return err
+	}
+
+	s.snapshot = wallet
+	s.status.Loaded = true
+	s.status.LoadedAt = s.now()
+	s.status.RefreshedAt = s.status.LoadedAt
+	s.status.LastError = ""
+	return nil
+}
+
+// Refresh updates a copy of the current snapshot and publishes it. Coins that failed to update don't stop the copy
+// from being published, but any other failure keeps the current snapshot.
+func (s *WalletService) Refresh(ctx context.Context) error {
+	current, err := s.Wallet()
+	if err != nil {
+		return err
+	}
+
+	wallet := current.Clone()
+	err = s.refresh(ctx, wallet)
+
+	var updateErrs UpdateErrors
+	if err != nil && !errors.As(err, &updateErrs) {
+		log.Printf("Failed refreshing the wallet, keeping the previous one: %s", err)
+		s.mu.Lock()
+		s.status.LastError = err.Error()
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot = wallet
+	s.status.RefreshedAt = s.now()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	return err
+}
+
+// delay determines how long to wait after the given failed attempt at loading the wallet
+func (s *WalletService) delay(attempt int) time.Duration {
+	delay := s.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > s.MaxDelay {
+		delay = s.MaxDelay
+	}
+	return delay
+}
+
+// wait sleeps for the given duration unless the context finishes first
+func (s *WalletService) wait(ctx context.Context, d time.Duration) error {
+	if s.sleep != nil {
+		return s.sleep(ctx, d)
+	}
+	return sleepContext(ctx, d)
+}
@@ -0,0 +1,166 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestWallet creates a wallet holding 2 ETH that cost 10.0 at the given rate
+func newTestWallet(rate float64) *Wallet {
+	coin := WarchestCoin{Symbol: "ETH", Amount: NewDecimal(2), Cost: NewDecimal(10),
+		Rates: CoinRates{"USD": NewDecimalFromFloat(rate)}}
+	coin.UpdateProfit()
+	wallet := &Wallet{}
+	wallet.SetCoin(coin)
+	return wallet
+}
+
+// rateRefresher creates a refresher that sets every coin's rate to the next of the given rates
+func rateRefresher(rates ...float64) WalletRefresher {
+	var mu sync.Mutex
+	return func(ctx context.Context, wallet *Wallet) error {
+		mu.Lock()
+		rate := rates[0]
+		if len(rates) > 1 {
+			rates = rates[1:]
+		}
+		mu.Unlock()
+
+		coin, _ := wallet.Coin("ETH")
+		coin.Rates = CoinRates{"USD": NewDecimalFromFloat(rate)}
+		coin.UpdateProfit()
+		wallet.SetCoin(coin)
+		return nil
+	}
+}
+
+func TestWalletService(t *testing.T) {
+
+	ctx := context.Background()
+
+	t.Run("Nothing is served before the first load", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return newTestWallet(10.0), nil
+		}, rateRefresher(10.0), time.Minute)
+
+		_, err := service.Wallet()
+		assert.Equal(t, ErrWalletNotLoaded, err, "should be the same")
+		assert.Equal(t, ErrWalletNotLoaded, service.Refresh(ctx), "there's nothing to refresh yet")
+
+		assert.Nil(t, service.Load(ctx), "should have loaded")
+		wallet, err := service.Wallet()
+		assert.Nil(t, err, "should have been served")
+		assert.Equal(t, NewDecimal(10), wallet.NetProfit, "should be the same")
+		assert.True(t, service.Status().Loaded, "should have been loaded")
+	})
+
+	t.Run("Failed loads are retried with backoff", func(t *testing.T) {
+		attempts := 0
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			attempts++
+			if attempts < 5 {
+				return nil, ErrConnection
+			}
+			return newTestWallet(10.0), nil
+		}, rateRefresher(10.0), time.Minute)
+		service.BaseDelay = time.Second
+		service.MaxDelay = 5 * time.Second
+
+		var waits []time.Duration
+		service.sleep = func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}
+
+		assert.Nil(t, service.LoadWithRetry(ctx), "should have loaded eventually")
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}, waits,
+			"waits should double up to the max delay")
+		assert.Equal(t, 5, service.Status().Attempts, "should be the same")
+		assert.Equal(t, "", service.Status().LastError, "the last attempt succeeded")
+	})
+
+	t.Run("Retrying stops with the context", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return nil, ErrConnection
+		}, rateRefresher(10.0), time.Minute)
+		service.BaseDelay = time.Millisecond
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		assert.Equal(t, context.DeadlineExceeded, service.LoadWithRetry(ctx), "should be the same")
+		assert.Equal(t, ErrConnection.Error(), service.Status().LastError, "should be the same")
+		_, err := service.Wallet()
+		assert.Equal(t, ErrWalletNotLoaded, err, "should be the same")
+	})
+
+	t.Run("Snapshots are never modified by a refresh", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return newTestWallet(10.0), nil
+		}, rateRefresher(20.0), time.Minute)
+		service.Load(ctx)
+
+		before, _ := service.Wallet()
+		assert.Nil(t, service.Refresh(ctx), "should have refreshed")
+		after, _ := service.Wallet()
+
+		assert.Equal(t, NewDecimal(10), before.NetProfit, "the previous snapshot should be untouched")
+		assert.Equal(t, NewDecimal(10), before.Coins["ETH"].Rates["USD"], "the previous snapshot should be untouched")
+		assert.Equal(t, NewDecimal(30), after.NetProfit, "should be the same")
+		assert.Equal(t, NewDecimal(20), after.Coins["ETH"].Rates["USD"], "should be the same")
+	})
+
+	t.Run("Failed refreshes keep the current snapshot", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return newTestWallet(10.0), nil
+		}, func(ctx context.Context, wallet *Wallet) error {
+			wallet.SetCoin(WarchestCoin{Symbol: "ETH"})
+			return context.Canceled
+		}, time.Minute)
+		service.Load(ctx)
+
+		assert.Equal(t, context.Canceled, service.Refresh(ctx), "should be the same")
+		wallet, _ := service.Wallet()
+		assert.Equal(t, NewDecimal(10), wallet.NetProfit, "the previous snapshot should still be served")
+		assert.Equal(t, context.Canceled.Error(), service.Status().LastError, "should be the same")
+	})
+
+	t.Run("Coins failing to refresh don't keep the snapshot", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return newTestWallet(10.0), nil
+		}, func(ctx context.Context, wallet *Wallet) error {
+			rateRefresher(20.0)(ctx, wallet)
+			return UpdateErrors{"DOGE": ErrConnection}
+		}, time.Minute)
+		service.Load(ctx)
+
+		var updateErrs UpdateErrors
+		assert.True(t, errors.As(service.Refresh(ctx), &updateErrs), "should have been UpdateErrors")
+		wallet, _ := service.Wallet()
+		assert.Equal(t, NewDecimal(30), wallet.NetProfit, "the refreshed coins should be served")
+	})
+
+	t.Run("Run refreshes in the background", func(t *testing.T) {
+		service := NewWalletService(func(ctx context.Context) (*Wallet, error) {
+			return newTestWallet(10.0), nil
+		}, rateRefresher(15.0, 20.0), 5*time.Millisecond)
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() { done <- service.Run(ctx) }()
+
+		// Readers only ever see complete snapshots while the wallet refreshes, run with -race
+		assert.Eventually(t, func() bool {
+			wallet, err := service.Wallet()
+			return err == nil && wallet.NetProfit.Cmp(NewDecimal(30)) == 0 &&
+				wallet.Coins["ETH"].Profit.Cmp(NewDecimal(30)) == 0
+		}, time.Second, time.Millisecond, "the wallet should have been refreshed twice")
+
+		cancel()
+		assert.Equal(t, context.Canceled, <-done, "should be the same")
+	})
+}
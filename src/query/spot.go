@@ -0,0 +1,186 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CBSpotPriceURL is the url path for retrieving the spot price of a currency pair
+const CBSpotPriceURL = "/v2/prices/:currency_pair/spot"
+
+// CBSpotPriceDateFormat is the date format used to request a historical spot price
+const CBSpotPriceDateFormat = "2006-01-02"
+
+// CBSpotPriceResp is the unmarshalled response object for a spot price request
+type CBSpotPriceResp struct {
+	Data struct {
+		Base     string  `json:"base"`
+		Currency string  `json:"currency"`
+		Amount   Decimal `json:"amount"`
+	} `json:"data"`
+}
+
+// RetrieveSpotPrice will return the spot price of a Crypto Currency Symbol in the given fiat currency on the given
+// date, using the client's SpotPriceCache when one is set
+func (c *CoinbaseClient) RetrieveSpotPrice(ctx context.Context, symbol, fiat string, date time.Time) (Decimal, error) {
+
+	if c.SpotPrices != nil {
+		if price, ok := c.SpotPrices.Get(symbol, fiat, date); ok {
+			return price, nil
+		}
+	}
+
+	pricePath := strings.Replace(CBSpotPriceURL, ":currency_pair", symbol+"-"+fiat, -1)
+	pricePath += "?date=" + date.UTC().Format(CBSpotPriceDateFormat)
+
+	spotResp := CBSpotPriceResp{}
+	if err := c.get(ctx, pricePath, false, &spotResp); err != nil {
+		return Decimal{}, err
+	}
+
+	// Prices of past days never change, so they can be kept forever
+	if c.SpotPrices != nil {
+		c.SpotPrices.Set(symbol, fiat, date, spotResp.Data.Amount)
+	}
+
+	return spotResp.Data.Amount, nil
+}
+
+// FairMarketValue determines the value of a transaction's coins on the day the transaction was made, falling back to
+// the native amount coinbase reports when the spot price can't be retrieved
+func (c *CoinbaseClient) FairMarketValue(ctx context.Context, transaction CBTransaction) Decimal {
+
+	fiat := transaction.NativeAmount.Currency
+	if fiat == "" {
+		fiat = DefaultCurrency
+	}
+
+	price, err := c.RetrieveSpotPrice(ctx, transaction.Amount.Currency, fiat, transaction.CreatedAt)
+	if err != nil {
+		log.Printf("Failed retrieving spot price for transaction %s, using native amount: %s", transaction.ID, err)
+		return transaction.NativeAmount.Amount
+	}
+
+	return transaction.Amount.Amount.Mul(price)
+}
+
+// SpotPriceCache keeps historical spot prices, optionally persisting them to a JSON file so they are only ever
+// retrieved once. Only the prices of days before today (UTC) are kept, today's price is still moving.
+type SpotPriceCache struct {
+	Filepath string
+
+	mu     sync.Mutex
+	prices map[string]Decimal
+	dirty  bool
+	now    func() time.Time
+
+	// saveMu makes sure only one save writes the file at a time
+	saveMu sync.Mutex
+}
+
+// NewSpotPriceCache creates a cache backed by the given file, loading any prices already saved to it. An empty
+// filepath keeps the prices in memory only.
+func NewSpotPriceCache(path string) (*SpotPriceCache, error) {
+	cache := &SpotPriceCache{Filepath: path, prices: map[string]Decimal{}, now: time.Now}
+	if path == "" {
+		return cache, nil
+	}
+
+	byteValue, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+
+	if err := json.Unmarshal(byteValue, &cache.prices); err != nil {
+		return cache, ErrOnUnmarshall
+	}
+
+	return cache, nil
+}
+
+// spotPriceKey is an internal helper producing the cache key for a symbol, fiat currency and date
+func spotPriceKey(symbol, fiat string, date time.Time) string {
+	return fmt.Sprintf("%s-%s-%s", strings.ToUpper(symbol), strings.ToUpper(fiat),
+		date.UTC().Format(CBSpotPriceDateFormat))
+}
+
+// Get returns the cached spot price for the symbol and fiat currency on the given date
+func (s *SpotPriceCache) Get(symbol, fiat string, date time.Time) (Decimal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	price, ok := s.prices[spotPriceKey(symbol, fiat, date)]
+	return price, ok
+}
+
+// Set caches the spot price for the symbol and fiat currency on the given date, it is written to the cache's file on
+// the next Save. Prices of today or later aren't final yet and aren't cached.
+func (s *SpotPriceCache) Set(symbol, fiat string, date time.Time, price Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	today := now.UTC().Format(CBSpotPriceDateFormat)
+	if date.UTC().Format(CBSpotPriceDateFormat) >= today {
+		return
+	}
+
+	s.prices[spotPriceKey(symbol, fiat, date)] = price
+	s.dirty = true
+}
+
+// Save writes the cache to its file when prices were cached since the last save. The file is written outside of the
+// lock, so lookups aren't held up by it.
+func (s *SpotPriceCache) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty || s.Filepath == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	byteValue, err := json.MarshalIndent(s.prices, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := s.write(byteValue); err != nil {
+		// Try again on the next save
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// write replaces the cache's file with the given content, the caller must hold saveMu
+func (s *SpotPriceCache) write(byteValue []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.Filepath), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write can't corrupt the existing cache
+	tmpPath := s.Filepath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, byteValue, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.Filepath)
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"context"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestRetrieveSpotPrice(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	date := time.Date(2021, 5, 12, 23, 30, 0, 0, time.UTC)
+	spotURL := CBBaseURL + "/v2/prices/DOGE-USD/spot"
+
+	t.Run("Happy Path", func(t *testing.T) {
+		cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", spotURL, "date=2021-05-12",
+			httpmock.NewStringResponder(200, `{"data":{"base":"DOGE","currency":"USD","amount":"0.4950"}}`))
+
+		price, err := cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date)
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, MustDecimal("0.495"), price, "should be the same")
+	})
+
+	t.Run("Prices are cached on disk", func(t *testing.T) {
+		cachePath := filepath.Join(t.TempDir(), "prices", "spot_prices.json")
+		spotPrices, err := NewSpotPriceCache(cachePath)
+		assert.Nil(t, err, "a missing cache file should not be an error")
+
+		cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+		cb.SpotPrices = spotPrices
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", spotURL, "date=2021-05-12",
+			httpmock.NewStringResponder(200, `{"data":{"base":"DOGE","currency":"USD","amount":"0.4950"}}`))
+
+		cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date)
+		cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date)
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), "the second lookup should have used the cache")
+		_, err = os.Stat(cachePath)
+		assert.True(t, os.IsNotExist(err), "prices should only be written on save")
+
+		assert.Nil(t, spotPrices.Save(), "should not fail")
+
+		// A new cache (ie. the next run) should pick up the saved prices
+		reloaded, err := NewSpotPriceCache(cachePath)
+		assert.Nil(t, err, "should not fail")
+		price, ok := reloaded.Get("doge", "usd", date)
+		assert.True(t, ok, "the price should have been saved")
+		assert.Equal(t, MustDecimal("0.495"), price, "should be the same")
+
+		// Nothing was cached since, so there is nothing to write
+		os.Remove(cachePath)
+		assert.Nil(t, spotPrices.Save(), "should not fail")
+		_, err = os.Stat(cachePath)
+		assert.True(t, os.IsNotExist(err), "the cache shouldn't have been written again")
+	})
+
+	t.Run("Today's price isn't cached", func(t *testing.T) {
+		cachePath := filepath.Join(t.TempDir(), "spot_prices.json")
+		spotPrices, _ := NewSpotPriceCache(cachePath)
+		spotPrices.now = func() time.Time { return date.Add(-time.Hour) }
+
+		cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+		cb.SpotPrices = spotPrices
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", spotURL, "date=2021-05-12",
+			httpmock.NewStringResponder(200, `{"data":{"base":"DOGE","currency":"USD","amount":"0.4950"}}`))
+		httpmock.RegisterResponderWithQuery("GET", spotURL, "date=2021-05-11",
+			httpmock.NewStringResponder(200, `{"data":{"base":"DOGE","currency":"USD","amount":"0.4650"}}`))
+
+		cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date)
+		cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date)
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "today's price should have been retrieved again")
+
+		cb.RetrieveSpotPrice(context.Background(), "DOGE", "USD", date.AddDate(0, 0, -1))
+		spotPrices.Save()
+		reloaded, _ := NewSpotPriceCache(cachePath)
+		_, ok := reloaded.Get("DOGE", "USD", date)
+		assert.False(t, ok, "today's price should not have been saved")
+		price, ok := reloaded.Get("DOGE", "USD", date.AddDate(0, 0, -1))
+		assert.True(t, ok, "yesterday's price should have been saved")
+		assert.Equal(t, MustDecimal("0.465"), price, "should be the same")
+	})
+
+	t.Run("Corrupt cache file", func(t *testing.T) {
+		cachePath := filepath.Join(t.TempDir(), "spot_prices.json")
+		ioutil.WriteFile(cachePath, []byte(`{not json`), 0644)
+
+		spotPrices, err := NewSpotPriceCache(cachePath)
+		assert.Equal(t, ErrOnUnmarshall, err, "should be the same")
+		assert.NotNil(t, spotPrices, "an empty cache should still be usable")
+	})
+}
+
+func TestUpdateTransactions_FairMarketValue(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	accountID := "somethingLong"
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/transactions",
+		httpmock.NewStringResponder(200, rewardTransactionsJSON))
+	httpmock.RegisterResponderWithQuery("GET", CBBaseURL+"/v2/prices/ETH-USD/spot", "date=2021-06-01",
+		httpmock.NewStringResponder(200, `{"data":{"base":"ETH","currency":"USD","amount":"2500.00"}}`))
+	httpmock.RegisterResponderWithQuery("GET", CBBaseURL+"/v2/prices/ETH-USD/spot", "date=2021-07-01",
+		httpmock.NewStringResponder(200, `{"data":{"base":"ETH","currency":"USD","amount":"2000.00"}}`))
+
+	testCoin := WarchestCoin{AccountID: accountID, Symbol: "ETH"}
+	err := testCoin.UpdateTransactions(context.Background(), cb)
+
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, 3, len(testCoin.Transactions), "should be the same")
+	assert.Equal(t, NewDecimal(110), testCoin.Transactions[0].PurchasedPrice, "buys should keep their native amount")
+	assert.Equal(t, MustDecimal("0.02").Mul(NewDecimal(2500)), testCoin.Transactions[1].PurchasedPrice, "rewards should use the spot price")
+	assert.Equal(t, MustDecimal("0.5").Mul(NewDecimal(2000)), testCoin.Transactions[2].PurchasedPrice, "received coins should use the spot price")
+}
+
+const rewardTransactionsJSON = `{"pagination":{"next_uri":null},"data":[
+	{"id":"buy-1","type":"buy","status":"completed","amount":{"amount":"0.05","currency":"ETH"},
+		"native_amount":{"amount":"110.00","currency":"USD"},"created_at":"2021-05-01T10:00:00Z"},
+	{"id":"reward-1","type":"staking_reward","status":"completed","amount":{"amount":"0.02","currency":"ETH"},
+		"native_amount":{"amount":"0.00","currency":"USD"},"created_at":"2021-06-01T10:00:00Z"},
+	{"id":"receive-1","type":"send","status":"completed","amount":{"amount":"0.5","currency":"ETH"},
+		"native_amount":{"amount":"0.00","currency":"USD"},"created_at":"2021-07-01T10:00:00Z"}
+]}`
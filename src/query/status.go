@@ -0,0 +1,65 @@
+package query
+
+import (
+	"strings"
+)
+
+// CoinStatus describes how much a coin's figures can be trusted after its last update
+type CoinStatus string
+
+const (
+	// StatusOK is a coin whose transactions and rates are up to date
+	StatusOK CoinStatus = "ok"
+
+	// StatusStale is a coin that failed to update but still has the transactions and rates fetched before, so its
+	// figures are out of date rather than wrong
+	StatusStale CoinStatus = "stale"
+
+	// StatusFailed is a coin missing the transactions or rates needed to work out its figures, its profit shouldn't
+	// be relied on
+	StatusFailed CoinStatus = "failed"
+)
+
+// UpdateStatus works out the coin's Status and Error from the outcome of the last attempts at retrieving its
+// transactions, converting them into the base currency and retrieving its rates
+func (w *WarchestCoin) UpdateStatus() {
+	errs := []string{}
+	for _, err := range []error{w.transactionsErr, w.currencyErr, w.ratesErr} {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	switch {
+	case len(errs) == 0:
+		w.Status = StatusOK
+	case w.currencyErr != nil,
+		w.transactionsErr != nil && w.TransactionsFetchedAt.IsZero() && len(w.Transactions) == 0,
+		w.ratesErr != nil && w.Rates.Rate(w.BaseCurrency()).IsZero():
+		w.Status = StatusFailed
+	default:
+		w.Status = StatusStale
+	}
+	w.Error = strings.Join(errs, "; ")
+}
+
+// mergeStatus combines the outcome of retrieving another holding of the same coin, the coin is only as up to date as
+// its oldest holding
+func (w *WarchestCoin) mergeStatus(other WarchestCoin) {
+	if w.transactionsErr == nil {
+		w.transactionsErr = other.transactionsErr
+	}
+	if w.currencyErr == nil {
+		w.currencyErr = other.currencyErr
+	}
+	if w.ratesErr == nil {
+		w.ratesErr = other.ratesErr
+	}
+	if other.TransactionsFetchedAt.Before(w.TransactionsFetchedAt) {
+		w.TransactionsFetchedAt = other.TransactionsFetchedAt
+	}
+	if other.RatesFetchedAt.Before(w.RatesFetchedAt) {
+		w.RatesFetchedAt = other.RatesFetchedAt
+	}
+	w.UpdateStatus()
+}
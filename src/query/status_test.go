@@ -0,0 +1,144 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestCoin_UpdateStatus(t *testing.T) {
+
+	fetchedAt := time.Now().Add(-time.Hour)
+	transactions := []CoinTransaction{{NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(5)}}
+
+	statusTests := []struct {
+		name           string
+		coin           WarchestCoin
+		expectedStatus CoinStatus
+		expectedError  string
+	}{
+		{"Up to date", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, Transactions: transactions},
+			StatusOK, ""},
+		{"Rates kept from before", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, RatesFetchedAt: fetchedAt,
+			Transactions: transactions, ratesErr: ErrConnection}, StatusStale, "error during request"},
+		{"Rates never retrieved", WarchestCoin{Transactions: transactions, ratesErr: ErrConnection},
+			StatusFailed, "error during request"},
+		{"Transactions kept from before", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, Transactions: transactions,
+			TransactionsFetchedAt: fetchedAt, transactionsErr: ErrRateLimited}, StatusStale, "rate limit exceeded"},
+		{"Transactions never retrieved", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, Transactions: []CoinTransaction{},
+			transactionsErr: ErrRateLimited}, StatusFailed, "rate limit exceeded"},
+		{"Currency not converted", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, Transactions: transactions,
+			currencyErr: ErrUnknownCurrency}, StatusFailed, "no exchange rate for currency"},
+		{"Every error is listed", WarchestCoin{Rates: CoinRates{"USD": NewDecimal(10)}, Transactions: transactions,
+			TransactionsFetchedAt: fetchedAt, transactionsErr: ErrRateLimited, ratesErr: ErrConnection},
+			StatusStale, "rate limit exceeded; error during request"},
+	}
+
+	for _, tt := range statusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.coin.UpdateStatus()
+			assert.Equal(t, tt.expectedStatus, tt.coin.Status, "should be the same")
+			assert.Equal(t, tt.expectedError, tt.coin.Error, "should be the same")
+		})
+	}
+}
+
+func TestWallet_Partial(t *testing.T) {
+
+	usdRates := map[string]string{"ETH": "10.0", "DOGE": "6.0"}
+	newWallet := func() *Wallet {
+		return &Wallet{Coins: map[string]WarchestCoin{
+			"ETH":  {Symbol: "ETH", Transactions: []CoinTransaction{{NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(10)}}},
+			"DOGE": {Symbol: "DOGE", Transactions: []CoinTransaction{{NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(10)}}},
+		}}
+	}
+
+	t.Run("Every coin updated", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		wallet := newWallet()
+
+		wallet.UpdateNetProfit(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client), true)
+
+		eth, _ := wallet.Coin("ETH")
+		assert.False(t, wallet.Partial, "should not be partial")
+		assert.Equal(t, StatusOK, eth.Status, "should be the same")
+		assert.False(t, eth.RatesFetchedAt.IsZero(), "the rates should know when they were retrieved")
+	})
+
+	t.Run("Failed refresh keeps the last known rates", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		cb := NewCoinbaseClient(auth.CBAuth{}, client)
+		wallet := newWallet()
+		wallet.UpdateNetProfit(context.Background(), cb, true)
+		before, _ := wallet.Coin("DOGE")
+
+		client.failures[CBExchangeRateURL+"?currency=DOGE"] = true
+		_, err := wallet.UpdateNetProfit(context.Background(), cb, true)
+
+		var updateErrs UpdateErrors
+		assert.True(t, errors.As(err, &updateErrs), "should have been UpdateErrors")
+		doge, _ := wallet.Coin("DOGE")
+		assert.True(t, wallet.Partial, "should be partial")
+		assert.True(t, wallet.Clone().Partial, "the clone should be partial too")
+		assert.True(t, wallet.WithFees(false).Partial, "the wallet before fees should be partial too")
+		assert.Equal(t, StatusStale, doge.Status, "should be the same")
+		assert.Equal(t, ErrConnection.Error(), doge.Error, "should be the same")
+		assert.Equal(t, NewDecimal(6), doge.Rates["USD"], "the last known rate should be kept")
+		assert.Equal(t, before.RatesFetchedAt, doge.RatesFetchedAt, "should be the same")
+		assert.Equal(t, NewDecimal(2*6-10), doge.Profit, "there should be no false loss")
+
+		// The coin recovers once its rates can be retrieved again
+		delete(client.failures, CBExchangeRateURL+"?currency=DOGE")
+		wallet.UpdateNetProfit(context.Background(), cb, true)
+		doge, _ = wallet.Coin("DOGE")
+		assert.False(t, wallet.Partial, "should not be partial")
+		assert.Equal(t, StatusOK, doge.Status, "should be the same")
+		assert.Equal(t, "", doge.Error, "should be the same")
+	})
+
+	t.Run("Failed coins are reported in the JSON", func(t *testing.T) {
+		client := newConcurrentClient(usdRates)
+		client.failures[CBExchangeRateURL+"?currency=DOGE"] = true
+		wallet := newWallet()
+
+		wallet.UpdateNetProfit(context.Background(), NewCoinbaseClient(auth.CBAuth{}, client), true)
+
+		walletJSON, err := json.Marshal(wallet)
+		assert.Nil(t, err, "should have been marshalled")
+
+		var reported struct {
+			Partial bool `json:"partial"`
+			Coins   map[string]struct {
+				Status         string    `json:"status"`
+				Error          string    `json:"error"`
+				RatesFetchedAt time.Time `json:"rates_fetched_at"`
+			} `json:"coins"`
+		}
+		json.Unmarshal(walletJSON, &reported)
+		assert.True(t, reported.Partial, "should be partial")
+		assert.Equal(t, "failed", reported.Coins["DOGE"].Status, "should be the same")
+		assert.Equal(t, "error during request", reported.Coins["DOGE"].Error, "should be the same")
+		assert.True(t, reported.Coins["DOGE"].RatesFetchedAt.IsZero(), "the rates were never retrieved")
+		assert.Equal(t, "ok", reported.Coins["ETH"].Status, "should be the same")
+	})
+}
+
+func TestCoin_UpdateTransactions_Cloudy(t *testing.T) {
+
+	fetchedAt := time.Now().Add(-time.Hour)
+	transactions := []CoinTransaction{{NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(5)}}
+	testCoin := WarchestCoin{AccountID: "somethingLong", Symbol: "ETH", Rates: CoinRates{"USD": NewDecimal(10)},
+		Transactions: transactions, TransactionsFetchedAt: fetchedAt}
+
+	err := testCoin.UpdateTransactions(context.Background(), NewCoinbaseClient(auth.CBAuth{}, &MockClient{}))
+	testCoin.UpdateStatus()
+
+	assert.Equal(t, ErrConnection, err, "should be the same")
+	assert.Equal(t, transactions, testCoin.Transactions, "the transactions retrieved before should be kept")
+	assert.Equal(t, fetchedAt, testCoin.TransactionsFetchedAt, "should be the same")
+	assert.Equal(t, StatusStale, testCoin.Status, "should be the same")
+}
@@ -0,0 +1,158 @@
+package query
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TaxTerm is the holding period of a disposal, which decides how its gain is taxed
+type TaxTerm string
+
+const (
+	// TermShort is coins held for a year or less
+	TermShort TaxTerm = "short"
+
+	// TermLong is coins held for more than a year
+	TermLong TaxTerm = "long"
+)
+
+// TaxDateFormat is how dates are written in the tax report, the same as on Form 8949
+const TaxDateFormat = "01/02/2006"
+
+// TaxRow is the part of a sale taken from a single lot. Coins sold without a lot to take them from have no Acquired
+// date, cost nothing and are short-term as their holding period isn't known.
+type TaxRow struct {
+	Symbol    string    `json:"symbol"`
+	NumCoins  Decimal   `json:"num_coins"`
+	Acquired  time.Time `json:"acquired"`
+	Disposed  time.Time `json:"disposed"`
+	Proceeds  Decimal   `json:"proceeds"`
+	CostBasis Decimal   `json:"cost_basis"`
+	Gain      Decimal   `json:"gain"`
+	Term      TaxTerm   `json:"term"`
+}
+
+// TaxTotals are the proceeds, cost basis and gain of the sales of a year with the same term
+type TaxTotals struct {
+	Year      int     `json:"year"`
+	Term      TaxTerm `json:"term"`
+	Proceeds  Decimal `json:"proceeds"`
+	CostBasis Decimal `json:"cost_basis"`
+	Gain      Decimal `json:"gain"`
+}
+
+// TaxReport lists every sale or conversion of coins matched to the lots they were taken from, with totals by year and
+// term. Amounts are in Currency.
+type TaxReport struct {
+	Year     int         `json:"year,omitempty"`
+	Currency string      `json:"currency"`
+	Rows     []TaxRow    `json:"rows"`
+	Totals   []TaxTotals `json:"totals"`
+}
+
+// holdingTerm classifies the holding period by calendar day, coins are long-term from the day after the anniversary
+// of the day they were acquired
+func holdingTerm(acquired time.Time, disposed time.Time) TaxTerm {
+	if acquired.IsZero() {
+		return TermShort
+	}
+	acquired, disposed = acquired.UTC(), disposed.UTC()
+	anniversary := time.Date(acquired.Year()+1, acquired.Month(), acquired.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(disposed.Year(), disposed.Month(), disposed.Day(), 0, 0, 0, 0, time.UTC)
+	if day.After(anniversary) {
+		return TermLong
+	}
+	return TermShort
+}
+
+// NewTaxReport builds the tax report of the coins' sales in the given year, or in every year when year is 0, from
+// the lots their disposals were matched to by UpdateCost. Rows are sorted by the date sold.
+func NewTaxReport(coins map[string]WarchestCoin, year int, currency string) TaxReport {
+	report := TaxReport{Year: year, Currency: currency, Rows: []TaxRow{}, Totals: []TaxTotals{}}
+
+	for symbol, coin := range coins {
+		for _, disposal := range coin.Disposals {
+			if !disposal.Kind.IsSale() || (year != 0 && disposal.Disposed.UTC().Year() != year) {
+				continue
+			}
+			term := holdingTerm(disposal.Acquired, disposal.Disposed)
+			report.Rows = append(report.Rows, TaxRow{Symbol: symbol, NumCoins: disposal.NumCoins,
+				Acquired: disposal.Acquired, Disposed: disposal.Disposed, Proceeds: disposal.Proceeds,
+				CostBasis: disposal.Cost, Gain: disposal.Gain(), Term: term})
+		}
+	}
+
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if !a.Disposed.Equal(b.Disposed) {
+			return a.Disposed.Before(b.Disposed)
+		}
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		return a.Acquired.Before(b.Acquired)
+	})
+
+	// Add up the rows of every year and term
+	index := map[string]int{}
+	for _, row := range report.Rows {
+		key := strconv.Itoa(row.Disposed.UTC().Year()) + string(row.Term)
+		i, ok := index[key]
+		if !ok {
+			i = len(report.Totals)
+			index[key] = i
+			report.Totals = append(report.Totals, TaxTotals{Year: row.Disposed.UTC().Year(), Term: row.Term})
+		}
+		totals := &report.Totals[i]
+		totals.Proceeds = totals.Proceeds.Add(row.Proceeds)
+		totals.CostBasis = totals.CostBasis.Add(row.CostBasis)
+		totals.Gain = totals.Gain.Add(row.Gain)
+	}
+	sort.SliceStable(report.Totals, func(i, j int) bool {
+		if report.Totals[i].Year != report.Totals[j].Year {
+			return report.Totals[i].Year < report.Totals[j].Year
+		}
+		return report.Totals[i].Term == TermShort && report.Totals[j].Term == TermLong
+	})
+
+	return report
+}
+
+// WriteCSV writes the report as a Form 8949 style CSV, a row per lot sold followed by the totals of every year and
+// term. Amounts are rounded to the cent.
+func (r *TaxReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"Description", "Date Acquired", "Date Sold", "Proceeds (" + r.Currency + ")",
+		"Cost Basis (" + r.Currency + ")", "Gain or Loss (" + r.Currency + ")", "Term"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range r.Rows {
+		acquired := ""
+		if !row.Acquired.IsZero() {
+			acquired = row.Acquired.UTC().Format(TaxDateFormat)
+		}
+		err := writer.Write([]string{row.NumCoins.String() + " " + row.Symbol, acquired,
+			row.Disposed.UTC().Format(TaxDateFormat), row.Proceeds.StringFixed(2), row.CostBasis.StringFixed(2),
+			row.Gain.StringFixed(2), string(row.Term)})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, totals := range r.Totals {
+		err := writer.Write([]string{"Total " + strconv.Itoa(totals.Year) + " " + string(totals.Term) + "-term", "",
+			"", totals.Proceeds.StringFixed(2), totals.CostBasis.StringFixed(2), totals.Gain.StringFixed(2),
+			string(totals.Term)})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,117 @@
+package query
+
+import (
+	"bytes"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// updateGolden rewrites the golden files in testdata with the current output, run with go test -run TaxReport -update
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// taxCoins are coins bought and sold over a few years, with their lots matched oldest first
+func taxCoins() map[string]WarchestCoin {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 15, 30, 0, 0, time.UTC)
+	}
+
+	coins := map[string]WarchestCoin{
+		"BTC": {Symbol: "BTC", CostMethod: MethodFIFO, Transactions: []CoinTransaction{
+			{ID: "btc-5", Kind: KindTradeOut, Timestamp: date(2023, 2, 1), NumCoins: MustDecimal("0.5"),
+				PurchasedPrice: NewDecimal(10000)},
+			{ID: "btc-4", Kind: KindSend, Timestamp: date(2022, 3, 1), NumCoins: MustDecimal("0.1"),
+				PurchasedPrice: NewDecimal(4000)},
+			{ID: "btc-3", Kind: KindSell, Timestamp: date(2022, 1, 16), NumCoins: MustDecimal("0.4"),
+				PurchasedPrice: NewDecimal(16000)},
+			{ID: "btc-2", Kind: KindSell, Timestamp: date(2022, 1, 15), NumCoins: MustDecimal("0.6"),
+				PurchasedPrice: NewDecimal(25000)},
+			{ID: "btc-1b", Kind: KindBuy, Timestamp: date(2021, 6, 1), NumCoins: MustDecimal("0.5"),
+				PurchasedPrice: NewDecimal(18000)},
+			{ID: "btc-1a", Kind: KindBuy, Timestamp: date(2021, 1, 15), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(30000)},
+		}},
+		"ETH": {Symbol: "ETH", CostMethod: MethodFIFO, Transactions: []CoinTransaction{
+			{ID: "eth-3", Kind: KindSell, Status: "pending", Timestamp: date(2022, 6, 1), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(1000)},
+			{ID: "eth-2", Kind: KindSell, Timestamp: date(2022, 5, 1), NumCoins: NewDecimal(1),
+				PurchasedPrice: MustDecimal("2000.12345")},
+			{ID: "eth-1", Kind: KindBuy, Timestamp: date(2022, 2, 1), NumCoins: NewDecimal(2),
+				PurchasedPrice: NewDecimal(6000)},
+		}},
+	}
+
+	for symbol, coin := range coins {
+		coin.UpdateCost()
+		coins[symbol] = coin
+	}
+	return coins
+}
+
+func TestTaxReport(t *testing.T) {
+
+	reportTests := []struct {
+		name   string
+		year   int
+		golden string
+	}{
+		{"One year", 2022, "./testdata/tax_report_2022.csv"},
+		{"Every year", 0, "./testdata/tax_report_all.csv"},
+		{"Nothing sold", 2020, "./testdata/tax_report_empty.csv"},
+	}
+
+	for _, tt := range reportTests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewTaxReport(taxCoins(), tt.year, "USD")
+
+			var actual bytes.Buffer
+			assert.Nil(t, report.WriteCSV(&actual), "should not fail")
+
+			if *updateGolden {
+				assert.Nil(t, ioutil.WriteFile(tt.golden, actual.Bytes(), 0644), "should have updated the golden file")
+			}
+			expected, err := ioutil.ReadFile(tt.golden)
+			assert.Nil(t, err, "golden file should exist")
+			assert.Equal(t, string(expected), actual.String(), "should match the golden file")
+		})
+	}
+
+	t.Run("Totals", func(t *testing.T) {
+		report := NewTaxReport(taxCoins(), 2022, "USD")
+
+		assert.Equal(t, []TaxTotals{
+			{Year: 2022, Term: TermShort, Proceeds: MustDecimal("27000.12345"), CostBasis: NewDecimal(21000),
+				Gain: MustDecimal("6000.12345")},
+			{Year: 2022, Term: TermLong, Proceeds: NewDecimal(16000), CostBasis: NewDecimal(12000),
+				Gain: NewDecimal(4000)},
+		}, report.Totals, "sends and pending sales shouldn't be part of the totals")
+	})
+}
+
+func TestHoldingTerm(t *testing.T) {
+
+	acquired := time.Date(2021, 1, 15, 23, 0, 0, 0, time.UTC)
+	leapDay := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+
+	termTests := []struct {
+		name     string
+		acquired time.Time
+		disposed time.Time
+		expected TaxTerm
+	}{
+		{"Same day", acquired, acquired, TermShort},
+		{"Anniversary", acquired, time.Date(2022, 1, 15, 1, 0, 0, 0, time.UTC), TermShort},
+		{"Day after the anniversary", acquired, time.Date(2022, 1, 16, 0, 0, 0, 0, time.UTC), TermLong},
+		{"Leap day anniversary", leapDay, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), TermShort},
+		{"Day after the leap day anniversary", leapDay, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), TermLong},
+		{"Unknown acquisition", time.Time{}, acquired, TermShort},
+	}
+
+	for _, tt := range termTests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, holdingTerm(tt.acquired, tt.disposed), "should be the same")
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// CBBuyURL is the url path for retrieving the details of a buy
+const CBBuyURL = "/v2/accounts/:account_id/buys/:buy_id"
+
+// CBSellURL is the url path for retrieving the details of a sell
+const CBSellURL = "/v2/accounts/:account_id/sells/:sell_id"
+
+// CBMoney is an amount of a given currency
+type CBMoney struct {
+	Amount   Decimal `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// CBTradeResp is the unmarshalled response object for a buy or sell
+type CBTradeResp struct {
+	Trade CBTrade `json:"data"`
+}
+
+// CBTrade is a buy or sell, which unlike its transaction breaks down the fee, subtotal and unit price. Transactions
+// only reference their buy or sell (ID, Resource and ResourcePath) unless it was expanded.
+// Ref: https://developers.coinbase.com/api/v2#buys
+type CBTrade struct {
+	ID            string    `json:"id"`
+	Status        string    `json:"status"`
+	Amount        CBMoney   `json:"amount"`
+	Total         CBMoney   `json:"total"`
+	Subtotal      CBMoney   `json:"subtotal"`
+	Fee           CBMoney   `json:"fee"`
+	UnitPrice     CBMoney   `json:"unit_price"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Resource      string    `json:"resource"`
+	ResourcePath  string    `json:"resource_path"`
+	Committed     bool      `json:"committed"`
+	Instant       bool      `json:"instant"`
+	PayoutAt      time.Time `json:"payout_at"`
+	PaymentMethod struct {
+		ID           string `json:"id"`
+		Resource     string `json:"resource"`
+		ResourcePath string `json:"resource_path"`
+	} `json:"payment_method"`
+}
+
+// expanded determines if the trade's details were included rather than just a reference to it
+func (t *CBTrade) expanded() bool {
+	return t.Subtotal.Currency != "" || t.Fee.Currency != ""
+}
+
+// RetrieveBuy will return the details of a buy made with the given account
+func (c *CoinbaseClient) RetrieveBuy(ctx context.Context, accountID, buyID string) (CBTrade, error) {
+	buyPath := strings.Replace(CBBuyURL, ":account_id", accountID, -1)
+	buyPath = strings.Replace(buyPath, ":buy_id", buyID, -1)
+	return c.retrieveTrade(ctx, buyPath)
+}
+
+// RetrieveSell will return the details of a sell made with the given account
+func (c *CoinbaseClient) RetrieveSell(ctx context.Context, accountID, sellID string) (CBTrade, error) {
+	sellPath := strings.Replace(CBSellURL, ":account_id", accountID, -1)
+	sellPath = strings.Replace(sellPath, ":sell_id", sellID, -1)
+	return c.retrieveTrade(ctx, sellPath)
+}
+
+// retrieveTrade is an internal helper that retrieves a buy or sell from its path, completed trades don't change so
+// they are only retrieved once
+func (c *CoinbaseClient) retrieveTrade(ctx context.Context, tradePath string) (CBTrade, error) {
+
+	c.tradesMu.Lock()
+	trade, ok := c.trades[tradePath]
+	c.tradesMu.Unlock()
+	if ok {
+		return trade, nil
+	}
+
+	tradeResp := CBTradeResp{}
+	if err := c.get(ctx, tradePath, true, &tradeResp); err != nil {
+		return CBTrade{}, err
+	}
+
+	if tradeResp.Trade.Status == StatusCompleted {
+		c.tradesMu.Lock()
+		if c.trades == nil {
+			c.trades = map[string]CBTrade{}
+		}
+		c.trades[tradePath] = tradeResp.Trade
+		c.tradesMu.Unlock()
+	}
+
+	return tradeResp.Trade, nil
+}
+
+// TradeDetails will return the buy or sell behind a transaction made with the given account, false is returned
+// when the transaction isn't a buy or sell. The details expanded into the transaction are used when present, they
+// are only retrieved otherwise.
+func (c *CoinbaseClient) TradeDetails(ctx context.Context, accountID string, transaction CBTransaction) (CBTrade,
+	bool, error) {
+
+	switch {
+	case transaction.Buy.expanded():
+		return transaction.Buy, true, nil
+	case transaction.Sell.expanded():
+		return transaction.Sell, true, nil
+	case transaction.Buy.ID != "":
+		trade, err := c.RetrieveBuy(ctx, accountID, transaction.Buy.ID)
+		return trade, true, err
+	case transaction.Sell.ID != "":
+		trade, err := c.RetrieveSell(ctx, accountID, transaction.Sell.ID)
+		return trade, true, err
+	}
+
+	return CBTrade{}, false, nil
+}
@@ -0,0 +1,181 @@
+package query
+
+import (
+	"context"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestRetrieveTrades(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	accountID := "somethingLong"
+
+	t.Run("Buy", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/buys/buy-resource-1",
+			httpmock.NewStringResponder(200, buyJSON))
+
+		trade, err := cb.RetrieveBuy(context.Background(), accountID, "buy-resource-1")
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, MustDecimal("1.99"), trade.Fee.Amount, "should be the same")
+		assert.Equal(t, MustDecimal("98.01"), trade.Subtotal.Amount, "should be the same")
+		assert.Equal(t, NewDecimal(100), trade.Total.Amount, "should be the same")
+		assert.Equal(t, MustDecimal("9.801"), trade.UnitPrice.Amount, "should be the same")
+	})
+
+	t.Run("Sell", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/sells/sell-resource-1",
+			httpmock.NewStringResponder(200, sellJSON))
+
+		trade, err := cb.RetrieveSell(context.Background(), accountID, "sell-resource-1")
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, MustDecimal("0.5"), trade.Fee.Amount, "should be the same")
+		assert.Equal(t, NewDecimal(25), trade.Subtotal.Amount, "should be the same")
+	})
+
+	t.Run("Expanded into the transaction", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		transaction := CBTransaction{Type: "sell", Sell: CBTrade{ID: "sell-resource-1",
+			Subtotal: CBMoney{Amount: NewDecimal(25), Currency: "USD"},
+			Fee:      CBMoney{Amount: MustDecimal("0.5"), Currency: "USD"}}}
+		trade, isTrade, err := cb.TradeDetails(context.Background(), accountID, transaction)
+
+		assert.Nil(t, err, "should not fail")
+		assert.True(t, isTrade, "should be the same")
+		assert.Equal(t, MustDecimal("0.5"), trade.Fee.Amount, "should be the same")
+		assert.Equal(t, 0, httpmock.GetTotalCallCount(), "expanded trades shouldn't be retrieved again")
+	})
+
+	t.Run("Completed trades are only retrieved once", func(t *testing.T) {
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/buys/buy-resource-1",
+			httpmock.NewStringResponder(200, buyJSON))
+
+		cached := NewCoinbaseClient(auth.CBAuth{}, &client)
+		transaction := CBTransaction{Type: "buy", Buy: CBTrade{ID: "buy-resource-1"}}
+		for i := 0; i < 2; i++ {
+			trade, _, err := cached.TradeDetails(context.Background(), accountID, transaction)
+			assert.Nil(t, err, "should not fail")
+			assert.Equal(t, MustDecimal("1.99"), trade.Fee.Amount, "should be the same")
+		}
+
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), "should be the same")
+	})
+
+	t.Run("Not a trade", func(t *testing.T) {
+		_, isTrade, err := cb.TradeDetails(context.Background(), accountID, CBTransaction{Type: "send"})
+
+		assert.Nil(t, err, "should not fail")
+		assert.False(t, isTrade, "sends don't have trade details")
+	})
+}
+
+func TestCoinUpdateTransactions_Fees(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	accountID := "somethingLong"
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/transactions",
+		httpmock.NewStringResponder(200, tradeTransactionsJSON))
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/buys/buy-resource-1",
+		httpmock.NewStringResponder(200, buyJSON))
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/sells/sell-resource-1",
+		httpmock.NewStringResponder(200, sellJSON))
+	httpmock.RegisterResponder("GET", CBBaseURL+CBExchangeRateURL,
+		httpmock.NewStringResponder(200, `{"data":{"currency":"DOGE","rates":{"USD":"10.0"}}}`))
+
+	wallet := Wallet{Coins: map[string]WarchestCoin{"DOGE": {AccountID: accountID, Symbol: "DOGE"}}}
+	wallet.UpdateNetProfit(context.Background(), cb, false)
+	coin := wallet.Coins["DOGE"]
+
+	assert.Equal(t, 2, len(coin.Transactions), "should be the same")
+	// Coinbase returns the newest transactions first
+	assert.Equal(t, MustDecimal("0.5"), coin.Transactions[0].TransactionFee, "should be the same")
+	assert.Equal(t, MustDecimal("1.99"), coin.Transactions[1].TransactionFee, "should be the same")
+	assert.Equal(t, MustDecimal("98.01"), coin.Transactions[1].Subtotal, "should be the same")
+	assert.Equal(t, MustDecimal("9.801"), coin.Transactions[1].UnitPrice, "should be the same")
+	assert.Equal(t, MustDecimal("98.01"), coin.Transactions[1].PurchasedPrice,
+		"the native amount includes the fee, the purchased price shouldn't")
+	assert.Equal(t, MustDecimal("2.49"), coin.Fees, "fees should be reported on their own")
+	assert.Equal(t, MustDecimal("2.49"), wallet.TotalFees, "fees should be reported on their own")
+	assert.Equal(t, 0, httpmock.GetCallCountInfo()["GET "+CBBaseURL+"/v2/accounts/"+accountID+"/sells/sell-resource-1"],
+		"the sell was expanded into its transaction")
+}
+
+func TestCoinUpdateTransactions_MissingFee(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	accountID := "somethingLong"
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/transactions",
+		httpmock.NewStringResponder(200, tradeTransactionsJSON))
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/buys/buy-resource-1",
+		httpmock.NewStringResponder(500, `{"errors":[{"id":"internal_server_error","message":"boom"}]}`))
+
+	testCoin := WarchestCoin{AccountID: accountID, Symbol: "DOGE"}
+	err := testCoin.UpdateTransactions(context.Background(), cb)
+	testCoin.UpdateStatus()
+
+	assert.Equal(t, ErrMissingTradeDetails, err, "should be the same")
+	assert.Equal(t, 2, len(testCoin.Transactions), "transactions missing their fee should still be used")
+	assert.Equal(t, NewDecimal(100), testCoin.Transactions[1].PurchasedPrice,
+		"the fee is left in the price rather than counted twice")
+	assert.True(t, testCoin.Transactions[1].TransactionFee.IsZero(), "should be the same")
+	assert.False(t, testCoin.TransactionsFetchedAt.IsZero(), "should know when transactions were retrieved")
+	assert.Equal(t, StatusStale, testCoin.Status, "should be the same")
+	assert.Equal(t, ErrMissingTradeDetails.Error(), testCoin.Error, "should be the same")
+}
+
+const buyJSON = `{"data":{"id":"buy-resource-1","status":"completed","amount":{"amount":"10.00","currency":"DOGE"},
+	"total":{"amount":"100.00","currency":"USD"},"subtotal":{"amount":"98.01","currency":"USD"},
+	"fee":{"amount":"1.99","currency":"USD"},"unit_price":{"amount":"9.801","currency":"USD"},
+	"created_at":"2021-05-01T10:00:00Z","resource":"buy","committed":true,"instant":true}}`
+
+const sellJSON = `{"data":{"id":"sell-resource-1","status":"completed","amount":{"amount":"2.50","currency":"DOGE"},
+	"total":{"amount":"24.50","currency":"USD"},"subtotal":{"amount":"25.00","currency":"USD"},
+	"fee":{"amount":"0.50","currency":"USD"},"unit_price":{"amount":"10.00","currency":"USD"},
+	"created_at":"2021-06-01T10:00:00Z","resource":"sell","committed":true,"instant":true}}`
+
+const tradeTransactionsJSON = `{"pagination":{"next_uri":null},"data":[
+	{"id":"sell-1","type":"sell","status":"completed","amount":{"amount":"-2.50","currency":"DOGE"},
+		"native_amount":{"amount":"-24.50","currency":"USD"},"created_at":"2021-06-01T10:00:00Z",
+		"sell":{"id":"sell-resource-1","resource":"sell","resource_path":"/v2/accounts/somethingLong/sells/sell-resource-1",
+			"status":"completed","amount":{"amount":"2.50","currency":"DOGE"},"total":{"amount":"24.50","currency":"USD"},
+			"subtotal":{"amount":"25.00","currency":"USD"},"fee":{"amount":"0.50","currency":"USD"},
+			"unit_price":{"amount":"10.00","currency":"USD"}}},
+	{"id":"buy-1","type":"buy","status":"completed","amount":{"amount":"10.00","currency":"DOGE"},
+		"native_amount":{"amount":"100.00","currency":"USD"},"created_at":"2021-05-01T10:00:00Z",
+		"buy":{"id":"buy-resource-1","resource":"buy","resource_path":"/v2/accounts/somethingLong/buys/buy-resource-1"}}
+]}`
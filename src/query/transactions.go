@@ -1,32 +1,27 @@
 package query
 
 import (
-	"encoding/json"
-	"io"
+	"context"
 	"log"
-	"net/http"
 	"strings"
 	"time"
-	"warchest/src/auth"
 )
 
 // CBTransactionURL is the url path for retrieving a coins transactions
 const CBTransactionURL = "/v2/accounts/:account_id/transactions"
 
+// CBTransactionExpand asks for the buy or sell behind every transaction to be included, rather than retrieving each
+// of them on their own
+// Ref: https://developers.coinbase.com/api/v2#expanding-resources
+const CBTransactionExpand = "expand[]=buy&expand[]=sell"
+
 //
 // Response Objects
 ////////////////////
 
 // CBTransactionResp is the unmarshalled response object containing a coins transactions
 type CBTransactionResp struct {
-	Pagination struct {
-		EndingBefore  interface{} `json:"ending_before"`
-		StartingAfter interface{} `json:"starting_after"`
-		Limit         int         `json:"limit"`
-		Order         string      `json:"order"`
-		PreviousURI   interface{} `json:"previous_uri"`
-		NextURI       interface{} `json:"next_uri"`
-	} `json:"pagination"`
+	Pagination   CBPagination    `json:"pagination"`
 	Transactions []CBTransaction `json:"data"`
 }
 
@@ -36,11 +31,11 @@ type CBTransaction struct {
 	Type   string `json:"type"`
 	Status string `json:"status"`
 	Amount struct {
-		Amount   float64 `json:"amount,string"`
+		Amount   Decimal `json:"amount"`
 		Currency string  `json:"currency"`
 	} `json:"amount"`
 	NativeAmount struct {
-		Amount   float64 `json:"amount,string"`
+		Amount   Decimal `json:"amount"`
 		Currency string  `json:"currency"`
 	} `json:"native_amount"`
 	Description  *string   `json:"description"`
@@ -48,12 +43,9 @@ type CBTransaction struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	Resource     string    `json:"resource"`
 	ResourcePath string    `json:"resource_path"`
-	Buy          struct {
-		ID           string `json:"id"`
-		Resource     string `json:"resource"`
-		ResourcePath string `json:"resource_path"`
-	} `json:"buy,omitempty"`
-	Details struct {
+	Buy          CBTrade   `json:"buy,omitempty"`
+	Sell         CBTrade   `json:"sell,omitempty"`
+	Details      struct {
 		Title    string `json:"title"`
 		Subtitle string `json:"subtitle"`
 	} `json:"details"`
@@ -69,48 +61,158 @@ type CBTransaction struct {
 	} `json:"network,omitempty"`
 }
 
-// ToCoinTransaction will take a CBTransaction and convert relevant information into a CoinTransaction
+// Kind classifies the coinbase transaction type, using the direction of the amount where the type doesn't say
+// which way the coins went
+func (c *CBTransaction) Kind() TransactionKind {
+	incoming := c.Amount.Amount.Sign() >= 0
+
+	switch c.Type {
+	case "buy":
+		return KindBuy
+	case "sell":
+		return KindSell
+	case "send":
+		// Coins received from elsewhere show up as sends with a positive amount
+		if incoming {
+			return KindReceive
+		}
+		return KindSend
+	case "trade":
+		if incoming {
+			return KindTradeIn
+		}
+		return KindTradeOut
+	case "interest":
+		return KindInterest
+	case "staking_reward":
+		return KindStakingReward
+	case "inflation_reward", "earn_payout", "incentives_rewards_payout", "airdrop":
+		return KindReward
+	case "transfer", "vault_withdrawal", "exchange_deposit", "exchange_withdrawal", "pro_deposit", "pro_withdrawal":
+		// Coins moved between the user's own accounts (ie. a wallet and a vault, or into the exchange), one
+		// transaction in each
+		if incoming {
+			return KindTransferIn
+		}
+		return KindTransferOut
+	case "fiat_deposit":
+		return KindFiatDeposit
+	case "fiat_withdrawal":
+		return KindFiatWithdrawal
+	}
+	return KindUnknown
+}
+
+// NeedsFairMarketValue determines if the transaction's cost should come from the spot price on the day it was made,
+// which is the case for coins that were acquired without paying for them
+func (c *CBTransaction) NeedsFairMarketValue() bool {
+	switch c.Kind() {
+	case KindReceive, KindInterest, KindStakingReward, KindReward:
+		return true
+	}
+	return false
+}
+
+// ToCoinTransaction will take a CBTransaction and convert relevant information into a CoinTransaction. Coinbase
+// reports coins leaving the account as negative amounts, the kind captures the direction instead so amounts are
+// always positive.
 func (c *CBTransaction) ToCoinTransaction() CoinTransaction {
-	// TODO: Add error handling for values that don't exist
-	return CoinTransaction{NumCoins: c.Amount.Amount, PurchasedPrice: c.NativeAmount.Amount}
+	return CoinTransaction{
+		ID:             c.ID,
+		Kind:           c.Kind(),
+		Status:         c.Status,
+		Provider:       CBProviderName,
+		Currency:       c.NativeAmount.Currency,
+		Timestamp:      c.CreatedAt,
+		NumCoins:       c.Amount.Amount.Abs(),
+		PurchasedPrice: c.NativeAmount.Amount.Abs(),
+	}
 }
 
-// CBCoinTransactions will return transactions for all coins the apikey has access to
-func CBCoinTransactions(accountID string, cbAuth auth.CBAuth, client HTTPClient) ([]CBTransaction, error) {
+// pagination returns the pagination object for the page of transactions
+func (r *CBTransactionResp) pagination() CBPagination {
+	return r.Pagination
+}
 
-	transactionPath := strings.Replace(CBTransactionURL, ":account_id", accountID, -1)
+// Transactions will return the completed transactions of a coinbase account as CoinTransactions. Rewards and received
+// coins are valued at their fair market value, and buys and sells are valued before their fees.
+func (c *CoinbaseClient) Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error) {
 
-	url := CBBaseURL + transactionPath
+	transactions, err := c.CoinTransactions(ctx, holding.AccountID)
+	if err != nil {
+		return []CoinTransaction{}, err
+	}
 
-	authHeaders := cbAuth.NewAuthMap("GET", "", transactionPath)
-	req, err := http.NewRequest("GET", url, nil)
+	coinTransactions := []CoinTransaction{}
+	missingDetails := 0
+
+	log.Printf("There are %d transactions for %s\n", len(transactions), holding.Symbol)
+
+	for _, cbTransaction := range transactions {
+		// Pending, failed and cancelled transactions haven't affected the holdings
+		if cbTransaction.Status != StatusCompleted {
+			log.Printf("Skipping %s transaction %s for %s\n", cbTransaction.Status, cbTransaction.ID, holding.Symbol)
+			continue
+		}
+
+		log.Printf("Adding %s transaction for %s\n", cbTransaction.Type, cbTransaction.Amount.Currency)
+		log.Printf("NumCoins: %s\n", cbTransaction.Amount.Amount)
+		log.Printf("PurchasedPrices: %s\n", cbTransaction.NativeAmount.Amount)
+		coinTransaction := cbTransaction.ToCoinTransaction()
+
+		// Rewards and received coins are valued at the price on the day they arrived
+		if cbTransaction.NeedsFairMarketValue() {
+			coinTransaction.PurchasedPrice = c.FairMarketValue(ctx, cbTransaction)
+			log.Printf("Fair market value of %s transaction: %s\n", cbTransaction.Type,
+				coinTransaction.PurchasedPrice)
+		}
+
+		// Buys and sells break down what was paid to coinbase, the native amount includes the fee
+		trade, isTrade, err := c.TradeDetails(ctx, holding.AccountID, cbTransaction)
+		if err != nil {
+			log.Printf("Failed retrieving fee for transaction %s, its fee is left in its price: %s\n",
+				cbTransaction.ID, err)
+			missingDetails++
+		} else if isTrade {
+			if trade.Subtotal.Currency != "" {
+				coinTransaction.Currency = trade.Subtotal.Currency
+			}
+			coinTransaction.PurchasedPrice = trade.Subtotal.Amount
+			coinTransaction.TransactionFee = trade.Fee.Amount
+			coinTransaction.Subtotal = trade.Subtotal.Amount
+			coinTransaction.UnitPrice = trade.UnitPrice.Amount
+			log.Printf("Fee for %s transaction: %s\n", cbTransaction.Type, coinTransaction.TransactionFee)
+		}
+		coinTransactions = append(coinTransactions, coinTransaction)
+	}
 
-	// Set auth headers
-	for key, value := range authHeaders {
-		log.Printf("Setting %s to %s", key, value)
-		req.Header.Add(key, value)
+	if missingDetails > 0 {
+		log.Printf("%d %s transaction(s) are missing their fee", missingDetails, holding.Symbol)
+		return coinTransactions, ErrMissingTradeDetails
 	}
+	return coinTransactions, nil
+}
 
-	// Retrieve response
-	resp, err := client.Do(req)
+// CoinTransactions will return the transactions for the given account, following every page of the response
+func (c *CoinbaseClient) CoinTransactions(ctx context.Context, accountID string) ([]CBTransaction, error) {
 
+	transactionPath := strings.Replace(CBTransactionURL, ":account_id", accountID, -1) + "?" + CBTransactionExpand
+
+	pages := []*CBTransactionResp{}
+	err := c.getPages(ctx, transactionPath, func() cbPage {
+		page := &CBTransactionResp{}
+		pages = append(pages, page)
+		return page
+	})
 	if err != nil {
-		log.Printf("%s", err)
-		return []CBTransaction{}, ErrDecoding
+		log.Printf("Failed retrieving transactions for account %s: %s", accountID, err)
+		return []CBTransaction{}, err
 	}
-	defer resp.Body.Close()
-
-	bodyAsStr, err := io.ReadAll(resp.Body)
-	var transactions CBTransactionResp
-
-	if err := json.Unmarshal([]byte(bodyAsStr), &transactions); err != nil {
-		log.Printf("transaction_path: %s", transactionPath)
-		log.Printf("url: %s", url)
-		log.Printf("account_id: %s", accountID)
-		log.Printf("error: %s", err)
-		log.Printf("Body of response: %s", bodyAsStr)
-		return []CBTransaction{}, ErrOnUnmarshall
+
+	transactions := []CBTransaction{}
+	for _, page := range pages {
+		transactions = append(transactions, page.Transactions...)
 	}
 
-	return transactions.Transactions, nil
+	return transactions, nil
 }
@@ -0,0 +1,134 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+	"warchest/src/auth"
+)
+
+func TestCoinTransactions(t *testing.T) {
+
+	accountID := "somethingLong"
+	transactionURL := CBBaseURL + "/v2/accounts/" + accountID + "/transactions"
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+
+	t.Run("Multiple pages", func(t *testing.T) {
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25",
+			httpmock.NewStringResponder(200, transactionsPageOneJSON))
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25&starting_after=transaction-2",
+			httpmock.NewStringResponder(200, transactionsPageTwoJSON))
+
+		transactions, err := cb.CoinTransactions(context.Background(), accountID)
+
+		assert.Nil(t, err, "all pages were mocked, this shouldn't have had an err")
+		assert.Equal(t, 3, len(transactions), "transactions from every page should be returned")
+		assert.Equal(t, "transaction-1", transactions[0].ID, "should be the same")
+		assert.Equal(t, "transaction-3", transactions[2].ID, "should be the same")
+		assert.Equal(t, 2, httpmock.GetTotalCallCount(), "there should be one request per page")
+	})
+
+	t.Run("Follow cursor without next_uri", func(t *testing.T) {
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25",
+			httpmock.NewStringResponder(200, `{"pagination":{"next_starting_after":"transaction-2","limit":25},"data":[{"id":"transaction-1"},{"id":"transaction-2"}]}`))
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25&starting_after=transaction-2",
+			httpmock.NewStringResponder(200, transactionsPageTwoJSON))
+
+		transactions, err := cb.CoinTransactions(context.Background(), accountID)
+
+		assert.Nil(t, err, "all pages were mocked, this shouldn't have had an err")
+		assert.Equal(t, 3, len(transactions), "transactions from every page should be returned")
+	})
+
+	t.Run("Error part way through", func(t *testing.T) {
+
+		// Establish Mock
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25",
+			httpmock.NewStringResponder(200, transactionsPageOneJSON))
+		httpmock.RegisterResponderWithQuery("GET", transactionURL, CBTransactionExpand+"&limit=25&starting_after=transaction-2",
+			httpmock.NewStringResponder(200, `[asdf,[],!}`))
+
+		transactions, err := cb.CoinTransactions(context.Background(), accountID)
+
+		assert.Equal(t, ErrOnUnmarshall, err, "the second page should have produced a JSON parse error")
+		assert.Empty(t, transactions, "a partial list of transactions should not be returned")
+	})
+}
+
+const transactionsPageOneJSON = `{"pagination":{"limit":25,"order":"desc","next_uri":"/v2/accounts/somethingLong/transactions?expand[]=buy&expand[]=sell&limit=25&starting_after=transaction-2"},"data":[{"id":"transaction-1","type":"buy","status":"completed","amount":{"amount":"1.00","currency":"ETH"},"native_amount":{"amount":"10.00","currency":"USD"}},{"id":"transaction-2","type":"buy","status":"completed","amount":{"amount":"2.00","currency":"ETH"},"native_amount":{"amount":"20.00","currency":"USD"}}]}`
+
+const transactionsPageTwoJSON = `{"pagination":{"limit":25,"order":"desc","next_uri":null},"data":[{"id":"transaction-3","type":"buy","status":"completed","amount":{"amount":"3.00","currency":"ETH"},"native_amount":{"amount":"30.00","currency":"USD"}}]}`
+
+func TestCBTransaction_Kind(t *testing.T) {
+
+	byteValue, err := ioutil.ReadFile("./testdata/transaction_types.json")
+	assert.Nil(t, err, "fixture should exist")
+
+	var transactionResp CBTransactionResp
+	assert.Nil(t, json.Unmarshal(byteValue, &transactionResp), "fixture should be valid")
+
+	expected := map[string]struct {
+		kind     TransactionKind
+		needsFMV bool
+	}{
+		"buy-1":                 {KindBuy, false},
+		"sell-1":                {KindSell, false},
+		"send-1":                {KindSend, false},
+		"receive-1":             {KindReceive, true},
+		"trade-in-1":            {KindTradeIn, false},
+		"trade-out-1":           {KindTradeOut, false},
+		"interest-1":            {KindInterest, true},
+		"staking-1":             {KindStakingReward, true},
+		"airdrop-1":             {KindReward, true},
+		"fiat-deposit-1":        {KindFiatDeposit, false},
+		"fiat-withdrawal-1":     {KindFiatWithdrawal, false},
+		"transfer-out-1":        {KindTransferOut, false},
+		"vault-withdrawal-1":    {KindTransferIn, false},
+		"exchange-deposit-1":    {KindTransferOut, false},
+		"exchange-withdrawal-1": {KindTransferIn, false},
+		"pro-deposit-1":         {KindTransferOut, false},
+		"pro-withdrawal-1":      {KindTransferIn, false},
+		"pending-1":             {KindBuy, false},
+		"failed-1":              {KindSell, false},
+	}
+
+	for _, cbTransaction := range transactionResp.Transactions {
+		t.Run(cbTransaction.ID, func(t *testing.T) {
+			coinTransaction := cbTransaction.ToCoinTransaction()
+
+			assert.Equal(t, expected[cbTransaction.ID].kind, coinTransaction.Kind, "should be the same")
+			assert.Equal(t, expected[cbTransaction.ID].needsFMV, cbTransaction.NeedsFairMarketValue(),
+				"should be the same")
+			assert.Equal(t, cbTransaction.Status, coinTransaction.Status, "should be the same")
+			assert.Equal(t, cbTransaction.CreatedAt, coinTransaction.Timestamp, "should be the same")
+			assert.True(t, coinTransaction.NumCoins.Sign() > 0, "amounts should always be positive")
+			assert.True(t, coinTransaction.PurchasedPrice.Sign() > 0, "native amounts should always be positive")
+		})
+	}
+
+	t.Run("Unknown type", func(t *testing.T) {
+		cbTransaction := CBTransaction{Type: "request"}
+		assert.Equal(t, KindUnknown, cbTransaction.Kind(), "should be the same")
+		assert.False(t, cbTransaction.Kind().IsAcquisition(), "should not count towards holdings")
+		assert.False(t, cbTransaction.Kind().IsDisposal(), "should not count towards holdings")
+	})
+}
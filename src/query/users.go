@@ -1,11 +1,7 @@
 package query
 
 import (
-	"encoding/json"
-	"io"
-	"log"
-	"net/http"
-	"warchest/src/auth"
+	"context"
 )
 
 // CBUserURL is the url path for retreiving a user object
@@ -26,37 +22,13 @@ type CBUserResp struct {
 	} `json:"data"`
 }
 
-// CBRetrieveUserID will return the userID associated with the given api key
-func CBRetrieveUserID(cbAuth auth.CBAuth, client HTTPClient) (string, error) {
+// RetrieveUserID will return the userID associated with the client's api key
+func (c *CoinbaseClient) RetrieveUserID(ctx context.Context) (string, error) {
 
-	url := CBBaseURL + CBUserURL
-
-	authHeaders := cbAuth.NewAuthMap("GET", "", CBUserURL)
-	req, err := http.NewRequest("GET", url, nil)
-
-	// Set auth headers
-	for key, value := range authHeaders {
-		log.Printf("Setting %s to %s", key, value)
-		req.Header.Add(key, value)
-	}
-
-	// Retrieve response
-	resp, err := client.Do(req)
-
-	if err != nil {
-		log.Printf("%s", err)
-		return "", ErrDecoding
-	}
-	defer resp.Body.Close()
-
-	bodyAsStr, err := io.ReadAll(resp.Body)
 	userResp := CBUserResp{}
-
-	if err := json.Unmarshal([]byte(bodyAsStr), &userResp); err != nil {
-		log.Printf("error: %s", err)
-		return "", ErrOnUnmarshall
+	if err := c.get(ctx, CBUserURL, true, &userResp); err != nil {
+		return "", err
 	}
 
-	log.Printf("Body as string: %s", bodyAsStr)
 	return userResp.Data.ID, nil
 }
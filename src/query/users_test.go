@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/gock.v1"
 	"net/http"
@@ -9,13 +10,11 @@ import (
 	"warchest/src/auth"
 )
 
-func TestCBRetrieveUserID(t *testing.T) {
+func TestRetrieveUserID(t *testing.T) {
 
 	client := http.Client{
 		Timeout: time.Second * 10,
 	}
-	var absClient HTTPClient
-	absClient = &client
 
 	t.Run("Happy Path", func(t *testing.T) {
 		// Setup Test specifics
@@ -29,7 +28,7 @@ func TestCBRetrieveUserID(t *testing.T) {
 			Reply(200).
 			BodyString(json)
 
-		actualResp, _ := CBRetrieveUserID(cbAuth, absClient)
+		actualResp, _ := NewCoinbaseClient(cbAuth, &client).RetrieveUserID(context.Background())
 		expectedResp := "9da7a204-544e-5fd1-9a12-61176c5d4cd8"
 		assert.Equal(t, expectedResp, actualResp)
 	})
@@ -46,7 +45,7 @@ func TestCBRetrieveUserID(t *testing.T) {
 			Reply(200).
 			BodyString(json)
 
-		actualResp, _ := CBRetrieveUserID(cbAuth, absClient)
+		actualResp, _ := NewCoinbaseClient(cbAuth, &client).RetrieveUserID(context.Background())
 		expectedResp := ""
 		assert.Equal(t, expectedResp, actualResp)
 	})
@@ -1,8 +1,13 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"log"
-	"warchest/src/auth"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 //
@@ -12,126 +17,485 @@ import (
 // Wallet is the main object consumed by warchest that includes all coins and their transactions
 type Wallet struct {
 	Coins     map[string]WarchestCoin `json:"coins"`
-	NetProfit float64                 `json:"net_profit"`
+	NetProfit Decimal                 `json:"net_profit"`
+	TotalFees Decimal                 `json:"total_fees"`
+
+	// The realized and unrealized gains, and the total return, of every coin added up
+	RealizedGain   Decimal `json:"realized_gain"`
+	UnrealizedGain Decimal `json:"unrealized_gain"`
+	TotalReturn    Decimal `json:"total_return"`
+
+	// The market value, cost and investment of every coin added up, and the total return as a percentage of what was
+	// invested
+	MarketValue Decimal `json:"market_value"`
+	Cost        Decimal `json:"cost"`
+	Invested    Decimal `json:"invested"`
+	ROIPercent  Decimal `json:"roi_percent"`
+
+	// Currency is the base currency every coin is valued in, DefaultCurrency is used when unset
+	Currency string `json:"currency,omitempty"`
+
+	// CostMethod decides what the coins disposed of cost for every coin, DefaultCostMethod is used when unset
+	CostMethod CostMethod `json:"cost_method,omitempty"`
+
+	// BeforeFees leaves fees out of the cost and profit of every coin, they're still added up in TotalFees
+	BeforeFees bool `json:"before_fees"`
+
+	// Partial is set when any coin is stale or failed to update, or when the Error left coins out of the wallet, so
+	// the totals aren't complete
+	Partial bool   `json:"partial"`
+	Error   string `json:"error,omitempty"`
+
+	// Concurrency is the number of coins updated at the same time, DefaultConcurrency is used when unset
+	Concurrency int `json:"-"`
+
+	// mu guards the coins and totals as updated coins are written back, a Wallet must not be copied once in use
+	mu sync.RWMutex
 }
 
-// WarchestCoin a coin object that includes stats and transactions for purchased coins
+// WarchestCoin a coin object that includes stats and transactions for purchased coins. A coin held in several
+// accounts (ie. a wallet and a vault, or with several providers) is the sum of its Accounts.
 // TODO: is there a better way to keep this DRY? ref PurchasedCoins
 type WarchestCoin struct {
 	AccountID    string            `json:"account_id"`
-	Cost         float64           `json:"cost"`
-	Amount       float64           `json:"amount"`
-	Profit       float64           `json:"profit"`
+	Accounts     []CoinAccount     `json:"accounts,omitempty"`
+	Providers    []string          `json:"providers,omitempty"`
+	Cost         Decimal           `json:"cost"`
+	Fees         Decimal           `json:"fees"`
+	Amount       Decimal           `json:"amount"`
+	Profit       Decimal           `json:"profit"`
 	Rates        CoinRates         `json:"rates"`
+	Currency     string            `json:"currency,omitempty"`
+	Exponent     int               `json:"exponent,omitempty"`
 	Symbol       string            `json:"symbol"`
 	Transactions []CoinTransaction `json:"transactions"`
 	Image        string            `json:"image_uri"`
+
+	// Lots are the coins still held by the transaction that acquired them, and Disposals the lots every disposal
+	// took its coins from according to the CostMethod
+	CostMethod CostMethod    `json:"cost_method,omitempty"`
+	Lots       []Lot         `json:"lots,omitempty"`
+	Disposals  []LotDisposal `json:"disposals,omitempty"`
+
+	// BeforeFees leaves fees out of the cost of the lots and the proceeds of the disposals, they're still added up in
+	// Fees
+	BeforeFees bool `json:"before_fees"`
+
+	// RealizedGain is what the coins sold or converted made over what they cost, UnrealizedGain is what the coins
+	// still held would make at the current rate (the same as Profit) and TotalReturn is both added up
+	RealizedGain   Decimal `json:"realized_gain"`
+	UnrealizedGain Decimal `json:"unrealized_gain"`
+	TotalReturn    Decimal `json:"total_return"`
+
+	// MarketValue is what the coins held are worth at the current rate. AverageEntryPrice is what a coin held cost
+	// before fees, and BreakEvenPrice the rate the coins held make back what they cost with fees at. Invested is
+	// what the coins held and the coins sold cost, ROIPercent is the TotalReturn as a percentage of it, and
+	// PortfolioShare the percentage of the wallet's MarketValue the coin makes up.
+	MarketValue       Decimal `json:"market_value"`
+	AverageEntryPrice Decimal `json:"average_entry_price"`
+	BreakEvenPrice    Decimal `json:"break_even_price"`
+	Invested          Decimal `json:"invested"`
+	ROIPercent        Decimal `json:"roi_percent"`
+	PortfolioShare    Decimal `json:"portfolio_share"`
+
+	// Status tells whether the figures above are up to date, along with the Error that made them stale or failed
+	Status                CoinStatus `json:"status,omitempty"`
+	Error                 string     `json:"error,omitempty"`
+	RatesFetchedAt        time.Time  `json:"rates_fetched_at"`
+	TransactionsFetchedAt time.Time  `json:"transactions_fetched_at"`
+
+	// The outcome of the last attempt at each part of the update, which make up the Status
+	transactionsErr error
+	currencyErr     error
+	ratesErr        error
 }
 
-// CoinTransaction is an individual transaction made for a given type of coin
+// CoinAccount is the breakdown of a coin held in a single account, Balance is what the provider reports while the
+// rest is calculated from the account's transactions
+type CoinAccount struct {
+	Provider  string  `json:"provider"`
+	AccountID string  `json:"account_id"`
+	Balance   Decimal `json:"balance"`
+	Cost      Decimal `json:"cost"`
+	Fees      Decimal `json:"fees"`
+	Amount    Decimal `json:"amount"`
+	Profit    Decimal `json:"profit"`
+}
+
+// key identifies the account across providers, account ids are only unique within a provider
+func (a *CoinAccount) key() string {
+	return a.Provider + "/" + a.AccountID
+}
+
+// CoinTransaction is an individual transaction made for a given type of coin. PurchasedPrice is the fiat value of the
+// coins before the TransactionFee, which is added to it for the cost of acquisitions and taken from it for the
+// proceeds of disposals, both in the transaction's Currency. LotIDs are the lots a disposal takes its coins from with
+// MethodSpecificID.
 type CoinTransaction struct {
-	NumCoins       float64 `json:"num_coins"`
-	PurchasedPrice float64 `json:"purchased_price"`
-	TransactionFee float64 `json:"transaction_fee"`
+	ID             string          `json:"id,omitempty"`
+	Kind           TransactionKind `json:"kind,omitempty"`
+	Status         string          `json:"status,omitempty"`
+	Provider       string          `json:"provider,omitempty"`
+	AccountID      string          `json:"account_id,omitempty"`
+	Currency       string          `json:"currency,omitempty"`
+	Timestamp      time.Time       `json:"timestamp"`
+	NumCoins       Decimal         `json:"num_coins"`
+	PurchasedPrice Decimal         `json:"purchased_price"`
+	TransactionFee Decimal         `json:"transaction_fee"`
+	Subtotal       Decimal         `json:"subtotal"`
+	UnitPrice      Decimal         `json:"unit_price"`
+	LotIDs         []string        `json:"lot_ids,omitempty"`
 }
 
-// getSupportedCoins is an internal helper function that returns the currently supported coins for warchest
-// TODO: this list will expand, this is just a way of bypassing coins that may have interest accruing
-func getSupportedCoins() []string {
-	return []string{"DOGE", "SHIB"}
+// Convert returns a copy of the transaction with its fiat values converted into the given currency at exchangeRate
+func (c CoinTransaction) Convert(currency string, exchangeRate Decimal) CoinTransaction {
+	c.Currency = currency
+	c.PurchasedPrice = c.PurchasedPrice.Mul(exchangeRate)
+	c.TransactionFee = c.TransactionFee.Mul(exchangeRate)
+	c.Subtotal = c.Subtotal.Mul(exchangeRate)
+	c.UnitPrice = c.UnitPrice.Mul(exchangeRate)
+	return c
 }
 
-// IsSupportedCoin is a helper method to determine if a coin is supported or not
-func (w *WarchestCoin) IsSupportedCoin() bool {
-	supportedCoins := getSupportedCoins()
-	for _, supportedCoin := range supportedCoins {
-		if w.Symbol == supportedCoin {
-			return true
+// UpdateTransactions method will retrieve the transactions for every account of a given coin held with the provider,
+// on failure the coin keeps the transactions retrieved before. Transactions missing the details of their fee or their
+// value are still used, but the error is kept so the coin's status shows it.
+func (w *WarchestCoin) UpdateTransactions(ctx context.Context, provider Provider) error {
+	return w.updateTransactions(ctx, []Provider{provider})
+}
+
+// RefreshTransactions retrieves the transactions of every account of the coin again from the provider it is held
+// with, so that transactions made since they were last retrieved are included. Coins without providers (ie. from the
+// config file) only retrieve them from the fallback when they don't have any yet.
+func (w *WarchestCoin) RefreshTransactions(ctx context.Context, byName map[string]Provider, fallback Provider) error {
+	if len(w.Providers) == 0 {
+		if len(w.Transactions) > 0 {
+			return nil
 		}
+		return w.UpdateTransactions(ctx, fallback)
 	}
-	return false
+
+	providers := []Provider{}
+	for _, name := range w.Providers {
+		if provider, ok := byName[name]; ok {
+			providers = append(providers, provider)
+		}
+	}
+	return w.updateTransactions(ctx, providers)
 }
 
-// UpdateTransactions method will retrieve the transactions for a given coin
-func (w *WarchestCoin) UpdateTransactions(cbAuth auth.CBAuth, client HTTPClient) {
+// updateTransactions is an internal helper that retrieves the transactions of every account of the coin held with the
+// given providers, the transactions of any other provider are kept as they are
+func (w *WarchestCoin) updateTransactions(ctx context.Context, providers []Provider) error {
 
-	transactions, err := CBCoinTransactions(w.AccountID, cbAuth, client)
-	if err != nil {
-		log.Printf("Failed retreiving transactions: %s", err)
-		w.Transactions = []CoinTransaction{}
-	}
+	transactions := []CoinTransaction{}
+	names := []string{}
+	var incompleteErr error
+	for _, provider := range providers {
+		names = append(names, provider.Name())
 
-	coinTransactions := []CoinTransaction{}
+		accounts := w.Accounts
+		if len(accounts) == 0 {
+			accounts = []CoinAccount{{Provider: provider.Name(), AccountID: w.AccountID}}
+		}
 
-	log.Printf("There are %d transactions for %s\n", len(transactions), w.Symbol)
+		for _, account := range accounts {
+			if account.Provider != provider.Name() {
+				continue
+			}
+
+			holding := Holding{Provider: provider.Name(), AccountID: account.AccountID, Symbol: w.Symbol}
+			accountTransactions, err := provider.Transactions(ctx, holding)
+			if errors.Is(err, ErrMissingTradeDetails) || errors.Is(err, ErrUnpricedTransactions) {
+				incompleteErr = err
+			} else if err != nil {
+				log.Printf("Failed retreiving transactions, keeping %d transaction(s) retrieved before: %s",
+					len(w.Transactions), err)
+				w.transactionsErr = err
+				return err
+			}
+
+			// Keep track of the account so the cost can be broken down by account
+			for _, transaction := range accountTransactions {
+				transaction.AccountID = account.AccountID
+				transactions = append(transactions, transaction)
+			}
+		}
+	}
 
-	for _, cbTransaction := range transactions {
-		log.Printf("Adding transaction for %s\n", cbTransaction.Amount.Currency)
-		log.Printf("NumCoins: %.14f\n", cbTransaction.Amount.Amount)
-		log.Printf("PurchasedPrices: %.14f\n", cbTransaction.NativeAmount.Amount)
-		coinTransactions = append(coinTransactions, cbTransaction.ToCoinTransaction())
+	for _, transaction := range w.Transactions {
+		if transaction.Provider != "" && !containsSymbol(names, transaction.Provider) {
+			transactions = append(transactions, transaction)
+		}
 	}
-	w.Transactions = coinTransactions
+
+	w.Transactions = transactions
+	w.TransactionsFetchedAt = time.Now()
+	w.transactionsErr = incompleteErr
+	return incompleteErr
 }
 
-//UpdateRates updates a coin's current exchange rate
-func (w *WarchestCoin) UpdateRates(client HTTPClient) {
+//UpdateRates updates a coin's current exchange rate, on failure the last known rates are kept
+func (w *WarchestCoin) UpdateRates(ctx context.Context, provider Provider) error {
 
-	coinRates, err := CBRetrieveCoinRates(w.Symbol, client)
+	coinRates, err := provider.Rates(ctx, w.Symbol)
+	w.ratesErr = err
 	if err != nil {
-		log.Printf("Failed to retrieve market rates for %s\n", w.Symbol)
-		// Reset instead of erroring
-		w.Rates.EUR = 0.0
-		w.Rates.GBP = 0.0
-		w.Rates.USD = 0.0
-		return
+		log.Printf("Failed to retrieve market rates for %s, keeping the rates from %s\n", w.Symbol,
+			w.RatesFetchedAt.Format(time.RFC3339))
+		return err
 	}
 
-	log.Printf("Rates for %s are:\n\tEUR: %.6f\n\tGBP: %.6f\n\tUSD: %.6f\n",
-		w.Symbol, coinRates.EUR, coinRates.GBP, coinRates.USD)
+	log.Printf("Rates for %s are quoted in %d currencies, %s: %s\n", w.Symbol, len(coinRates), w.BaseCurrency(),
+		coinRates.Rate(w.BaseCurrency()))
 	// Update the rates
-	w.Rates.EUR = coinRates.EUR
-	w.Rates.GBP = coinRates.GBP
-	w.Rates.USD = coinRates.USD
+	w.Rates = coinRates
+	w.RatesFetchedAt = time.Now()
+	return nil
+}
+
+// BaseCurrency is the currency the coin's cost and profit are calculated in
+func (w *WarchestCoin) BaseCurrency() string {
+	if w.Currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(w.Currency)
+}
+
+// Method is the cost method used to work out what the coins disposed of cost
+func (w *WarchestCoin) Method() CostMethod {
+	if w.CostMethod == "" {
+		return DefaultCostMethod
+	}
+	return w.CostMethod
+}
+
+// UpdateCurrency converts transactions made in another currency into the coin's BaseCurrency, using the exchange rate
+// between the two on the day of the transaction when the provider quotes spot prices (ie. coinbase), and the
+// provider's current exchange rate otherwise. Transactions without a currency are assumed to be in the base currency.
+func (w *WarchestCoin) UpdateCurrency(ctx context.Context, provider Provider) error {
+
+	base := w.BaseCurrency()
+	pricer, historical := provider.(SpotPricer)
+	exchangeRates := map[string]Decimal{}
+	converted := make([]CoinTransaction, len(w.Transactions))
+	for i, transaction := range w.Transactions {
+		converted[i] = transaction
+		if transaction.Currency == "" || strings.EqualFold(transaction.Currency, base) {
+			continue
+		}
+
+		// Transactions without a date (ie. from the config file) can only be converted at the current rate
+		currency := strings.ToUpper(transaction.Currency)
+		onDate := historical && !transaction.Timestamp.IsZero()
+		key := currency
+		if onDate {
+			key = spotPriceKey(currency, base, transaction.Timestamp)
+		}
+
+		exchangeRate, ok := exchangeRates[key]
+		if !ok {
+			var err error
+			if onDate {
+				exchangeRate, err = pricer.RetrieveSpotPrice(ctx, currency, base, transaction.Timestamp)
+			} else {
+				exchangeRate, err = currentExchangeRate(ctx, provider, currency, base)
+			}
+			if err != nil {
+				log.Printf("Failed to retrieve %s exchange rates for %s: %s", currency, w.Symbol, err)
+				w.currencyErr = err
+				return err
+			}
+			if exchangeRate.IsZero() {
+				log.Printf("There's no exchange rate from %s to %s for %s", currency, base, w.Symbol)
+				w.currencyErr = ErrUnknownCurrency
+				return ErrUnknownCurrency
+			}
+			exchangeRates[key] = exchangeRate
+		}
+
+		converted[i] = transaction.Convert(base, exchangeRate)
+	}
+
+	w.Transactions = converted
+	w.currencyErr = nil
+	return nil
+}
+
+// currentExchangeRate is an internal helper that returns the provider's current exchange rate from one currency into
+// another, 0 when it isn't quoted
+func currentExchangeRate(ctx context.Context, provider Provider, currency, base string) (Decimal, error) {
+	currencyRates, err := provider.Rates(ctx, currency)
+	if err != nil {
+		return Decimal{}, err
+	}
+	return currencyRates.Rate(base), nil
 }
 
-//UpdateCost updates a coin's initial purchase cost from the coins transactions
+//UpdateCost updates a coin's initial purchase cost from the coins transactions. Acquisitions become lots, while
+// disposals take their coins from the lots according to the coin's Method, and the coins and cost of the lots left
+// are what the coin holds. The lots of a coin held in several accounts are worked out together, disposals take their
+// coins from the lots of their own account and transfers move lots from one account to another.
 func (w *WarchestCoin) UpdateCost() {
-	totalNumCoins := 0.0
-	totalExpense := 0.0
 
-	for _, transaction := range w.Transactions {
-		totalNumCoins += transaction.NumCoins
-		// TODO: need 'purchased rate' instead
-		// NOTE: CB API - fee is in the total price
-		totalExpense += transaction.PurchasedPrice
+	if len(w.Accounts) == 0 {
+		ledger := MatchLots(w.Symbol, w.Method(), w.Transactions, !w.BeforeFees)
+		w.Amount, w.Cost, w.Fees = w.round(ledger.Amount()), ledger.Cost(), ledger.Fees
+		w.Lots, w.Disposals = ledger.Lots, ledger.Disposals
+		w.RealizedGain = realizedGain(w.Disposals)
+		log.Printf("Cost for %s by %s: %s (fees: %s)", w.Symbol, w.Method(), w.Cost, w.Fees)
+		return
+	}
+
+	// Transactions that can't be matched to an account belong to the coin's first account
+	transactions := make([]CoinTransaction, len(w.Transactions))
+	fees := map[string]Decimal{}
+	for i, transaction := range w.Transactions {
+		account := w.Accounts[0]
+		for _, candidate := range w.Accounts {
+			if candidate.AccountID == transaction.AccountID && candidate.Provider == transaction.Provider {
+				account = candidate
+				break
+			}
+		}
+		transaction.Provider, transaction.AccountID = account.Provider, account.AccountID
+		transactions[i] = transaction
+		if transaction.IsCompleted() {
+			fees[account.key()] = fees[account.key()].Add(transaction.TransactionFee)
+		}
 	}
 
-	log.Printf("Cost for %s: %.6f", w.Symbol, totalExpense)
-	w.Amount = totalNumCoins
-	w.Cost = totalExpense
+	// Lots are matched across every account at once, so that transfers between them can move lots along
+	ledger := MatchLots(w.Symbol, w.Method(), transactions, !w.BeforeFees)
+	w.Amount, w.Cost, w.Fees = Decimal{}, Decimal{}, ledger.Fees
+	w.Lots, w.Disposals = ledger.Lots, ledger.Disposals
+	accounts := make([]CoinAccount, len(w.Accounts))
+	for i, account := range w.Accounts {
+		lots := LotLedger{Lots: ledger.AccountLots(account.Provider, account.AccountID)}
+		account.Amount, account.Cost, account.Fees = w.round(lots.Amount()), lots.Cost(), fees[account.key()]
+		w.Amount = w.Amount.Add(account.Amount)
+		w.Cost = w.Cost.Add(account.Cost)
+		accounts[i] = account
+	}
+	w.Accounts = accounts
+	w.RealizedGain = realizedGain(w.Disposals)
+
+	log.Printf("Cost for %s by %s across %d accounts: %s (fees: %s)", w.Symbol, w.Method(), len(w.Accounts), w.Cost,
+		w.Fees)
+}
+
+// round rounds an amount of the coin to its smallest unit, amounts are left as they are when the exponent isn't known
+func (w *WarchestCoin) round(amount Decimal) Decimal {
+	if w.Exponent <= 0 {
+		return amount
+	}
+	return amount.Round(w.Exponent)
 }
 
-//UpdateProfit updates a coin's net profit value
+// chronological is an internal helper that returns a copy of the transactions sorted oldest first, coinbase returns
+// the newest transactions first. Both sides of a transfer are made at the same time, the coins go out of one account
+// before they come into the other.
+func chronological(transactions []CoinTransaction) []CoinTransaction {
+	sorted := make([]CoinTransaction, len(transactions))
+	copy(sorted, transactions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Timestamp.Equal(sorted[j].Timestamp) {
+			return sorted[i].Kind != KindTransferIn && sorted[j].Kind == KindTransferIn
+		}
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	return sorted
+}
+
+//UpdateProfit updates a coin's net profit value, along with the net profit of each of its accounts and the coin's
+// performance at the current rate
 func (w *WarchestCoin) UpdateProfit() {
-	currentValue := w.Rates.USD*w.Amount - w.Cost
+	rate := w.Rates.Rate(w.BaseCurrency())
+	currentValue := rate.Mul(w.Amount).Sub(w.Cost)
+
+	if len(w.Accounts) > 0 {
+		accounts := make([]CoinAccount, len(w.Accounts))
+		for i, account := range w.Accounts {
+			account.Profit = rate.Mul(account.Amount).Sub(account.Cost)
+			accounts[i] = account
+		}
+		w.Accounts = accounts
+	}
 
-	log.Printf("Net Profit for %s: %.6f", w.Symbol, currentValue)
+	log.Printf("Net Profit for %s: %s (realized: %s)", w.Symbol, currentValue, w.RealizedGain)
 	w.Profit = currentValue
+	w.UnrealizedGain = currentValue
+	w.TotalReturn = w.RealizedGain.Add(currentValue)
+
+	// Cost only includes the fees paid for the lots held when they aren't left out
+	fees := lotFees(w.Lots)
+	entryCost := w.Cost
+	if !w.BeforeFees {
+		entryCost = entryCost.Sub(fees)
+	}
+	w.MarketValue = rate.Mul(w.Amount)
+	w.AverageEntryPrice = entryCost.Div(w.Amount)
+	w.BreakEvenPrice = entryCost.Add(fees).Div(w.Amount)
+	w.Invested = w.Cost.Add(soldCost(w.Disposals))
+	w.ROIPercent = percent(w.TotalReturn, w.Invested)
 }
 
-//Update runs all internal updates to get the latest value of a particular coin in a wallet
-func (w *WarchestCoin) Update(cbAuth auth.CBAuth, client HTTPClient, demoMode bool) {
+//Update runs all internal updates to get the latest value of a particular coin in a wallet. Every update is run
+// even if an earlier one fails, the first error encountered is returned.
+func (w *WarchestCoin) Update(ctx context.Context, provider Provider, demoMode bool) error {
 
+	var transactionsErr error
 	if !demoMode {
-		w.UpdateTransactions(cbAuth, client)
+		transactionsErr = w.UpdateTransactions(ctx, provider)
 	}
+	currencyErr := w.UpdateCurrency(ctx, provider)
 	w.UpdateCost()
-	w.UpdateRates(client)
+	ratesErr := w.UpdateRates(ctx, provider)
 	w.UpdateProfit()
+	w.UpdateImage()
+	w.UpdateStatus()
 
-	// Set the URI path for coin's image
-	// TODO: This should probably be it's own method
+	if transactionsErr != nil {
+		return transactionsErr
+	}
+	if currencyErr != nil {
+		return currencyErr
+	}
+	return ratesErr
+}
+
+// updateQuotes converts the coin's transactions into the base currency, works out its cost and updates its rates and
+// profit. Rates are quoted by quotes, falling back to the provider the coin is held with. The first error encountered
+// is returned.
+func (w *WarchestCoin) updateQuotes(ctx context.Context, quotes Provider, byName map[string]Provider) error {
+	var own Provider
+	if len(w.Providers) > 0 && w.Providers[0] != quotes.Name() {
+		own = byName[w.Providers[0]]
+	}
+
+	currencyErr := w.UpdateCurrency(ctx, quotes)
+	if currencyErr != nil && own != nil {
+		log.Printf("%s couldn't convert %s into %s, trying %s", quotes.Name(), w.Symbol, w.BaseCurrency(),
+			own.Name())
+		currencyErr = w.UpdateCurrency(ctx, own)
+	}
+	w.UpdateCost()
+
+	err := w.UpdateRates(ctx, quotes)
+	if err != nil && own != nil {
+		log.Printf("%s couldn't quote %s, trying %s", quotes.Name(), w.Symbol, own.Name())
+		err = w.UpdateRates(ctx, own)
+	}
+	w.UpdateProfit()
+
+	if currencyErr != nil {
+		return currencyErr
+	}
+	return err
+}
+
+// UpdateImage sets the URI path for the coin's image
+func (w *WarchestCoin) UpdateImage() {
 	switch w.Symbol {
 	case "ETH":
 		w.Image = "eth.png"
@@ -148,92 +512,431 @@ func (w *WarchestCoin) Update(cbAuth auth.CBAuth, client HTTPClient, demoMode bo
 
 //Banner prints out a stats banner for the coin
 func (w *WarchestCoin) Banner() {
-	log.Printf("\tCurrent rate for %s: %.14f\n", w.Symbol, w.Rates.USD)
-	log.Printf("\tInitial Cost of %s: %.14f\n", w.Symbol, w.Cost)
-	log.Printf("\tTotal Amount of %s: %.14f\n", w.Symbol, w.Amount)
-	log.Printf("\tCurrent cost of %s: %.14f\n", w.Symbol, w.Amount*w.Rates.USD)
-	log.Printf("\tTotal profit for %s: %.14f\n", w.Symbol, w.Profit)
+	log.Printf("\tCurrent rate for %s: %s %s\n", w.Symbol, w.Rates.Rate(w.BaseCurrency()), w.BaseCurrency())
+	log.Printf("\tInitial Cost of %s: %s\n", w.Symbol, w.Cost)
+	log.Printf("\tTotal Amount of %s: %s\n", w.Symbol, w.Amount)
+	log.Printf("\tCurrent cost of %s: %s\n", w.Symbol, w.Amount.Mul(w.Rates.Rate(w.BaseCurrency())))
+	log.Printf("\tTotal profit for %s: %s\n", w.Symbol, w.Profit)
 }
 
-// UpdateNetProfit will calculate the total profit for the coins in the provided Wallet
-func (w *Wallet) UpdateNetProfit(cbAuth auth.CBAuth, client HTTPClient, demoMode bool) (float64, error) {
-	netProfit := 0.0
+// UpdateNetProfit will calculate the total profit for the coins in the provided Wallet. Coins are updated in
+// parallel, and coins that fail to update are reported through UpdateErrors while the remaining coins still count
+// towards the Net Profit.
+func (w *Wallet) UpdateNetProfit(ctx context.Context, provider Provider, demoMode bool) (Decimal, error) {
 
-	log.Printf("There are %d coin(s) in your wallet, calculating...\n", len(w.Coins))
-	for _, coin := range w.Coins {
+	err := w.updateEachCoin(ctx, func(ctx context.Context, coin *WarchestCoin) error {
 
 		// If there aren't transactions for this coin, retrieve them
+		var transactionsErr error
 		if !demoMode && len(coin.Transactions) < 1 {
-			coin.UpdateTransactions(cbAuth, client)
+			transactionsErr = coin.UpdateTransactions(ctx, provider)
 		}
 
 		log.Printf("Updating Cost, Current Rates, and Profit for %s", coin.Symbol)
-		// Make sure cost is calculated
+		// Make sure cost is calculated in the base currency
+		currencyErr := coin.UpdateCurrency(ctx, provider)
 		coin.UpdateCost()
 
 		// Make sure we have the latest rates
-		coin.UpdateRates(client)
+		ratesErr := coin.UpdateRates(ctx, provider)
 
 		// Now recalculate based on the updated rates
 		coin.UpdateProfit()
+		coin.UpdateStatus()
 
-		//coin.Update(cbAuth, client)
+		if transactionsErr != nil {
+			return transactionsErr
+		}
+		if currencyErr != nil {
+			return currencyErr
+		}
+		return ratesErr
+	})
+
+	netProfit, _ := w.Totals()
+	log.Printf("Wallet's calculated Net Profit: %s", netProfit)
+	return netProfit, err
+}
 
-		// Present stats for coin
-		// coin.Banner()
-		netProfit += coin.Profit
+// Refresh brings every coin in the Wallet up to date, retrieving the transactions of every coin again from the
+// providers it is held with before updating its cost, rates and profit. Like GetWarchestCoins, rates are quoted by the
+// first provider falling back to the coin's own provider. Coins that fail to update keep the transactions retrieved
+// before, and are reported through UpdateErrors while the remaining coins still count towards the Net Profit.
+func (w *Wallet) Refresh(ctx context.Context, providers []Provider, demoMode bool) (Decimal, error) {
+
+	byName := map[string]Provider{}
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
 	}
+	quotes := providers[0]
 
-	// Make sure objects value is updated
-	w.NetProfit = netProfit
-	log.Printf("Wallet's calculated Net Profit: %.6f", netProfit)
-	return netProfit, nil
+	err := w.updateEachCoin(ctx, func(ctx context.Context, coin *WarchestCoin) error {
+		var transactionsErr error
+		if !demoMode {
+			transactionsErr = coin.RefreshTransactions(ctx, byName, quotes)
+		}
+
+		log.Printf("Updating Cost, Current Rates, and Profit for %s", coin.Symbol)
+		err := coin.updateQuotes(ctx, quotes, byName)
+		coin.UpdateStatus()
+
+		if transactionsErr != nil {
+			return transactionsErr
+		}
+		return err
+	})
+
+	netProfit, _ := w.Totals()
+	log.Printf("Wallet's refreshed Net Profit: %s", netProfit)
+	return netProfit, err
 }
 
-// UpdateCoinRates will update the rates for all coins in a given wallet
-func (w *Wallet) UpdateCoinRates(client HTTPClient) {
+// UpdateCoinRates will update the rates, and the profit that depends on them, for all coins in a given wallet
+func (w *Wallet) UpdateCoinRates(ctx context.Context, provider Provider) error {
+	return w.updateEachCoin(ctx, func(ctx context.Context, coin *WarchestCoin) error {
+		err := coin.UpdateRates(ctx, provider)
+		coin.UpdateProfit()
+		coin.UpdateStatus()
+		return err
+	})
+}
+
+// updateEachCoin runs update on a copy of every coin in parallel, then writes the updated coins back into the wallet
+// and recalculates its totals. The wallet is only locked while the coins are copied and written back, so it can still
+// be read while the coins are updating.
+func (w *Wallet) updateEachCoin(ctx context.Context, update coinUpdate) error {
+
+	w.mu.RLock()
+	log.Printf("There are %d coin(s) in your wallet, calculating...\n", len(w.Coins))
+	coins := make([]WarchestCoin, 0, len(w.Coins))
 	for _, coin := range w.Coins {
-		coin.UpdateRates(client)
+		if w.Currency != "" {
+			coin.Currency = w.Currency
+		}
+		if w.CostMethod != "" {
+			coin.CostMethod = w.CostMethod
+		}
+		coin.BeforeFees = w.BeforeFees
+		coins = append(coins, coin)
+	}
+	concurrency := w.Concurrency
+	w.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("Stopped updating coins: %s", err)
+		return err
+	}
+
+	updated, updateErrs := updateCoins(ctx, coins, concurrency, update)
+
+	// Merge the updated coins back into the wallet
+	w.mu.Lock()
+	if w.Coins == nil {
+		w.Coins = map[string]WarchestCoin{}
 	}
+	for _, coin := range updated {
+		w.Coins[coin.Symbol] = coin
+	}
+	w.updateTotals()
+	w.mu.Unlock()
+
+	if len(updateErrs) > 0 {
+		log.Printf("%s", updateErrs)
+		return updateErrs
+	}
+	return nil
 }
 
-// GetWarchestCoins will retrieve all of the 'accounts' and convert them into a map of WarchestCoins
-func GetWarchestCoins(cbAuth auth.CBAuth, client HTTPClient, demoMode bool) (map[string]WarchestCoin, error) {
+// updateTotals recalculates the wallet's totals from its coins, the caller must hold the lock
+func (w *Wallet) updateTotals() {
+	netProfit := Decimal{}
+	totalFees := Decimal{}
+	realized, unrealized, total := Decimal{}, Decimal{}, Decimal{}
+	marketValue, cost, invested := Decimal{}, Decimal{}, Decimal{}
+	partial := false
+	for _, coin := range w.Coins {
+		netProfit = netProfit.Add(coin.Profit)
+		totalFees = totalFees.Add(coin.Fees)
+		realized = realized.Add(coin.RealizedGain)
+		unrealized = unrealized.Add(coin.UnrealizedGain)
+		total = total.Add(coin.TotalReturn)
+		marketValue = marketValue.Add(coin.MarketValue)
+		cost = cost.Add(coin.Cost)
+		invested = invested.Add(coin.Invested)
+		partial = partial || (coin.Status != "" && coin.Status != StatusOK)
+	}
+	partial = partial || w.Error != ""
+	w.NetProfit = netProfit
+	w.TotalFees = totalFees
+	w.RealizedGain, w.UnrealizedGain, w.TotalReturn = realized, unrealized, total
+	w.MarketValue, w.Cost, w.Invested, w.ROIPercent = marketValue, cost, invested, percent(total, invested)
+	w.Partial = partial
+
+	for symbol, coin := range w.Coins {
+		coin.PortfolioShare = percent(coin.MarketValue, marketValue)
+		w.Coins[symbol] = coin
+	}
+}
 
-	accountResp, err := CBRetrieveAccounts(cbAuth, client)
-	if err != nil {
-		log.Printf("Failed to retrieve accounts: %s", err)
-		return map[string]WarchestCoin{}, err
+// percent is part as a percentage of whole, or 0 when whole is 0
+func percent(part Decimal, whole Decimal) Decimal {
+	return part.Mul(NewDecimal(100)).Div(whole)
+}
+
+// Totals returns the wallet's Net Profit and Total Fees
+func (w *Wallet) Totals() (Decimal, Decimal) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.NetProfit, w.TotalFees
+}
+
+// Coin returns a copy of the coin with the given symbol
+func (w *Wallet) Coin(symbol string) (WarchestCoin, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	coin, ok := w.Coins[symbol]
+	return coin, ok
+}
+
+// SetCoin adds the coin to the wallet, replacing any coin with the same symbol, and recalculates the wallet's totals
+func (w *Wallet) SetCoin(coin WarchestCoin) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Coins == nil {
+		w.Coins = map[string]WarchestCoin{}
+	}
+	w.Coins[coin.Symbol] = coin
+	w.updateTotals()
+}
+
+// SetCoins replaces the wallet's coins with coins that are already up to date (ie. from GetWarchestCoins), and
+// recalculates the wallet's totals
+func (w *Wallet) SetCoins(coins map[string]WarchestCoin) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Coins = make(map[string]WarchestCoin, len(coins))
+	for symbol, coin := range coins {
+		w.Coins[symbol] = coin
 	}
+	w.updateTotals()
+}
 
-	log.Printf("There are %d accounts to look through", len(accountResp.Accounts))
+// SetProviderErrors records the providers whose holdings couldn't be retrieved as the wallet's Error, which marks the
+// wallet as Partial since their coins are missing
+func (w *Wallet) SetProviderErrors(errs map[string]error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Error = ""
+	if len(errs) > 0 {
+		w.Error = "missing the coins of " + describeErrors(errs)
+	}
+	w.updateTotals()
+}
+
+// WithFees returns a copy of the wallet with the cost and profit of every coin worked out after fees, or before them
+// when fees is false. The coins are recalculated from the transactions they already have.
+func (w *Wallet) WithFees(fees bool) *Wallet {
+	clone := w.Clone()
+	clone.BeforeFees = !fees
+	for symbol, coin := range clone.Coins {
+		coin.BeforeFees = !fees
+		coin.UpdateCost()
+		coin.UpdateProfit()
+		clone.Coins[symbol] = coin
+	}
+	clone.updateTotals()
+	return clone
+}
+
+// Clone returns a copy of the wallet that can be updated without affecting this one
+func (w *Wallet) Clone() *Wallet {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	clone := &Wallet{Coins: make(map[string]WarchestCoin, len(w.Coins)), NetProfit: w.NetProfit,
+		TotalFees: w.TotalFees, RealizedGain: w.RealizedGain, UnrealizedGain: w.UnrealizedGain,
+		TotalReturn: w.TotalReturn, MarketValue: w.MarketValue, Cost: w.Cost, Invested: w.Invested,
+		ROIPercent: w.ROIPercent, Currency: w.Currency, CostMethod: w.CostMethod, BeforeFees: w.BeforeFees,
+		Partial: w.Partial, Error: w.Error, Concurrency: w.Concurrency}
+	for symbol, coin := range w.Coins {
+		coin.Accounts = append([]CoinAccount(nil), coin.Accounts...)
+		coin.Providers = append([]string(nil), coin.Providers...)
+		coin.Transactions = append(make([]CoinTransaction, 0, len(coin.Transactions)), coin.Transactions...)
+		coin.Lots = append([]Lot(nil), coin.Lots...)
+		coin.Disposals = append([]LotDisposal(nil), coin.Disposals...)
+		rates := make(CoinRates, len(coin.Rates))
+		for currency, rate := range coin.Rates {
+			rates[currency] = rate
+		}
+		coin.Rates = rates
+		clone.Coins[symbol] = coin
+	}
+	return clone
+}
+
+// hasAccount determines if the account is already one of the coin's accounts
+func (w *WarchestCoin) hasAccount(account CoinAccount) bool {
+	for _, existing := range w.Accounts {
+		if existing.key() == account.key() {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeCoins adds coins from another source (ie. another provider) to the wallet, a coin already in the wallet keeps
+// its account and gains the other coin's accounts, providers and transactions
+func (w *Wallet) MergeCoins(coins map[string]WarchestCoin) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.Coins == nil {
+		w.Coins = map[string]WarchestCoin{}
+	}
+
+	for symbol, coin := range coins {
+		existing, ok := w.Coins[symbol]
+		if !ok {
+			w.Coins[symbol] = coin
+			continue
+		}
+
+		log.Printf("Merging %d transaction(s) into %s", len(coin.Transactions), symbol)
+		existing.Transactions = append(existing.Transactions, coin.Transactions...)
+		for _, account := range coin.Accounts {
+			if !existing.hasAccount(account) {
+				existing.Accounts = append(existing.Accounts, account)
+			}
+		}
+		for _, provider := range coin.Providers {
+			if !containsSymbol(existing.Providers, provider) {
+				existing.Providers = append(existing.Providers, provider)
+			}
+		}
+		if existing.Exponent == 0 {
+			existing.Exponent = coin.Exponent
+		}
+		existing.mergeStatus(coin)
+		w.Coins[symbol] = existing
+	}
+}
+
+// GetWarchestCoins will retrieve the holdings of every provider included by the filter and convert them into a map of
+// WarchestCoins, holdings of the same symbol are aggregated into a single coin across providers. At most concurrency
+// coins are updated at the same time, and rates are quoted by the first provider (falling back to the coin's own
+// provider). Coins are valued in the given base currency, transactions made in other currencies are converted into
+// it, and the cost of the coins disposed of is worked out by the given cost method. Coins that fail to update are
+// reported through UpdateErrors, and providers whose holdings can't be retrieved through LoadErrors, while the
+// remaining coins are still returned, along with the holdings left out by the filter.
+func GetWarchestCoins(ctx context.Context, providers []Provider, demoMode bool, concurrency int, currency string,
+	method CostMethod, filter CoinFilter) (map[string]WarchestCoin, []SkippedAccount, error) {
 
 	coins := map[string]WarchestCoin{}
-	for _, account := range accountResp.Accounts {
-		coinToAdd := WarchestCoin{
-			AccountID:    account.ID,
-			Cost:         0.0,
-			Amount:       0.0,
-			Profit:       0.0,
-			Rates:        CoinRates{},
-			Symbol:       account.Currency.Code,
-			Transactions: []CoinTransaction{},
-		}
-
-		// Check if this is a supported coin first
-		if !coinToAdd.IsSupportedCoin() {
-			log.Printf("Skipping unsupported coin: %s\n", coinToAdd.Symbol)
+	skipped := []SkippedAccount{}
+	if len(providers) == 0 {
+		return coins, skipped, nil
+	}
+
+	updateErrs := UpdateErrors{}
+	providerErrs := map[string]error{}
+	byName := map[string]Provider{}
+	holdingCoins := []WarchestCoin{}
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+
+		holdings, err := provider.Holdings(ctx)
+		if err != nil {
+			log.Printf("Failed to retrieve %s holdings: %s", provider.Name(), err)
+			providerErrs[provider.Name()] = err
 			continue
 		}
 
-		// Make sure coin updates appropriately
-		// TODO: Add error handling around this as update _could_ fail
-		coinToAdd.Update(cbAuth, client, demoMode)
+		log.Printf("There are %d %s accounts to look through", len(holdings), provider.Name())
+
+		included, providerSkipped := filter.FilterHoldings(holdings)
+		for _, skippedAccount := range providerSkipped {
+			log.Printf("Skipping %s %s account %s: %s\n", provider.Name(), skippedAccount.Symbol,
+				skippedAccount.AccountID, skippedAccount.Reason)
+		}
+		skipped = append(skipped, providerSkipped...)
+
+		for _, holding := range included {
+			account := CoinAccount{Provider: provider.Name(), AccountID: holding.AccountID, Balance: holding.Balance}
+			holdingCoins = append(holdingCoins, WarchestCoin{
+				AccountID:    holding.AccountID,
+				Accounts:     []CoinAccount{account},
+				Providers:    []string{provider.Name()},
+				Currency:     currency,
+				CostMethod:   method,
+				Exponent:     holding.Exponent,
+				Symbol:       holding.Symbol,
+				Transactions: []CoinTransaction{},
+			})
+		}
+	}
+
+	// There's nothing to show when every provider failed
+	if len(providerErrs) == len(providers) {
+		return coins, skipped, providerErrs[providers[0].Name()]
+	}
+
+	// Retrieve the transactions of every holding from its provider
+	fetched, transactionErrs := updateCoins(ctx, holdingCoins, concurrency, func(ctx context.Context,
+		coin *WarchestCoin) error {
+		if demoMode {
+			return nil
+		}
+		return coin.UpdateTransactions(ctx, byName[coin.Providers[0]])
+	})
+
+	// Aggregate holdings of the same symbol
+	wallet := Wallet{Coins: coins}
+	for _, coin := range fetched {
+		wallet.MergeCoins(map[string]WarchestCoin{coin.Symbol: coin})
+	}
+
+	merged := make([]WarchestCoin, 0, len(wallet.Coins))
+	for _, coin := range wallet.Coins {
+		merged = append(merged, coin)
+	}
 
+	// Make sure coins update appropriately
+	quotes := providers[0]
+	updated, ratesErrs := updateCoins(ctx, merged, concurrency, func(ctx context.Context, coin *WarchestCoin) error {
+		err := coin.updateQuotes(ctx, quotes, byName)
+		coin.UpdateImage()
+		coin.UpdateStatus()
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		log.Printf("Stopped retrieving coins: %s", err)
+		return map[string]WarchestCoin{}, skipped, err
+	}
+
+	for _, coinToAdd := range updated {
 		// Add to the map!
 		log.Printf("Adding coin %s to the list of coins", coinToAdd.Symbol)
-		coins[account.Currency.Code] = coinToAdd
+		coins[coinToAdd.Symbol] = coinToAdd
 	}
 
-	return coins, nil
+	// Transactions failing is reported over the rates failing
+	for symbol, err := range transactionErrs {
+		updateErrs[symbol] = err
+	}
+	for symbol, err := range ratesErrs {
+		if _, ok := updateErrs[symbol]; !ok {
+			updateErrs[symbol] = err
+		}
+	}
+
+	if len(providerErrs) > 0 {
+		loadErrs := LoadErrors{Providers: providerErrs, Coins: updateErrs}
+		log.Printf("%s", loadErrs)
+		return coins, skipped, loadErrs
+	}
+	if len(updateErrs) > 0 {
+		log.Printf("%s", updateErrs)
+		return coins, skipped, updateErrs
+	}
+	return coins, skipped, nil
 }
@@ -1,12 +1,14 @@
 package query
 
 import (
-	"fmt"
+	"context"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/gock.v1"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 	"warchest/src/auth"
@@ -15,16 +17,127 @@ import (
 func TestCoinUpdateProfit(t *testing.T) {
 
 	symbol := "ETH"
-	testRateUSD := 30.0
-	testCoin := WarchestCoin{"somethingLong", 50.0, 5.0,
-		0.0, CoinRates{0.0, 0.0, testRateUSD}, symbol, []CoinTransaction{}, ""}
-	expectedNetProfit := 5*testRateUSD - 50
+	testRateUSD := NewDecimal(30)
+	testCoin := WarchestCoin{AccountID: "somethingLong", Cost: NewDecimal(50), Amount: NewDecimal(5),
+		Rates: CoinRates{"USD": testRateUSD}, Symbol: symbol, Transactions: []CoinTransaction{}}
+	expectedNetProfit := NewDecimal(5).Mul(testRateUSD).Sub(NewDecimal(50))
 
 	testCoin.UpdateProfit()
 
 	assert.Equal(t, expectedNetProfit, testCoin.Profit, "should be the same")
 }
 
+func TestCoinUpdateProfit_Gains(t *testing.T) {
+
+	// Sending coins elsewhere doesn't realize a gain
+	send := CoinTransaction{ID: "send-1", Kind: KindSend, Timestamp: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		NumCoins: MustDecimal("0.5"), PurchasedPrice: NewDecimal(200)}
+	testCoin := WarchestCoin{Symbol: "BTC", CostMethod: MethodFIFO, Rates: CoinRates{"USD": NewDecimal(400)},
+		Transactions: append(lotTransactions(), send)}
+
+	testCoin.UpdateCost()
+	testCoin.UpdateProfit()
+
+	assert.Equal(t, NewDecimal(600-(100+150)), testCoin.RealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(400-200), testCoin.UnrealizedGain, "should be the same")
+	assert.Equal(t, testCoin.Profit, testCoin.UnrealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(350+200), testCoin.TotalReturn, "should be the same")
+
+	wallet := Wallet{}
+	wallet.SetCoin(testCoin)
+	wallet.SetCoin(WarchestCoin{Symbol: "ETH", RealizedGain: NewDecimal(-50), UnrealizedGain: NewDecimal(10),
+		TotalReturn: NewDecimal(-40)})
+
+	assert.Equal(t, NewDecimal(300), wallet.RealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(210), wallet.UnrealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(510), wallet.TotalReturn, "should be the same")
+	assert.Equal(t, wallet.TotalReturn, wallet.Clone().TotalReturn, "should be the same")
+}
+
+func TestWallet_WithFees(t *testing.T) {
+
+	transactions := lotTransactions()
+	transactions[0].TransactionFee = NewDecimal(6)
+	transactions[3].TransactionFee = NewDecimal(2)
+	wallet := Wallet{}
+	testCoin := WarchestCoin{Symbol: "BTC", CostMethod: MethodFIFO, Rates: CoinRates{"USD": NewDecimal(400)},
+		Transactions: transactions}
+	testCoin.UpdateCost()
+	testCoin.UpdateProfit()
+	wallet.SetCoin(testCoin)
+
+	beforeFees := wallet.WithFees(false)
+
+	assert.True(t, beforeFees.BeforeFees, "should be the same")
+	assert.Equal(t, NewDecimal(600-(100+150)), beforeFees.RealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(350+250), beforeFees.TotalReturn, "should be the same")
+	assert.Equal(t, NewDecimal(8), beforeFees.TotalFees, "fees should still be reported")
+
+	assert.False(t, wallet.BeforeFees, "the wallet itself should be left alone")
+	assert.Equal(t, NewDecimal(594-(102+150)), wallet.RealizedGain, "should be the same")
+	assert.Equal(t, NewDecimal(350+250-8), wallet.TotalReturn, "should be the same")
+	assert.Equal(t, wallet.TotalReturn, beforeFees.WithFees(true).TotalReturn, "should be the same")
+}
+
+func TestCoinUpdateProfit_Performance(t *testing.T) {
+
+	transactions := lotTransactions()
+	transactions[0].TransactionFee = NewDecimal(6)
+	transactions[2].TransactionFee = NewDecimal(3)
+	testCoin := WarchestCoin{Symbol: "BTC", CostMethod: MethodFIFO, Rates: CoinRates{"USD": NewDecimal(400)},
+		Transactions: transactions}
+
+	testCoin.UpdateCost()
+	testCoin.UpdateProfit()
+
+	// Half of buy-2 and all of buy-3 are left, along with half of buy-2's fee
+	assert.Equal(t, MustDecimal("351.5"), testCoin.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(600), testCoin.MarketValue, "should be the same")
+	assert.Equal(t, NewDecimal(350).Div(MustDecimal("1.5")), testCoin.AverageEntryPrice, "fees should be left out")
+	assert.Equal(t, MustDecimal("351.5").Div(MustDecimal("1.5")), testCoin.BreakEvenPrice, "fees should be included")
+	// The sale took buy-1 and the other half of buy-2 with its fee, and made 594 after its own fee
+	assert.Equal(t, MustDecimal("351.5").Add(MustDecimal("251.5")), testCoin.Invested, "should be the same")
+	assert.Equal(t, MustDecimal("342.5").Add(MustDecimal("248.5")), testCoin.TotalReturn, "should be the same")
+	assert.Equal(t, NewDecimal(591).Mul(NewDecimal(100)).Div(NewDecimal(603)), testCoin.ROIPercent,
+		"should be the total return over what was invested")
+
+	beforeFees := testCoin
+	beforeFees.BeforeFees = true
+	beforeFees.UpdateCost()
+	beforeFees.UpdateProfit()
+
+	assert.Equal(t, testCoin.AverageEntryPrice, beforeFees.AverageEntryPrice, "should be the same")
+	assert.Equal(t, testCoin.BreakEvenPrice, beforeFees.BreakEvenPrice, "should be the same")
+	assert.Equal(t, NewDecimal(600), beforeFees.Invested, "should be the same")
+	assert.Equal(t, NewDecimal(100), beforeFees.ROIPercent, "should be the same")
+
+	wallet := Wallet{}
+	wallet.SetCoin(testCoin)
+	wallet.SetCoin(WarchestCoin{Symbol: "ETH", Cost: NewDecimal(300), Invested: NewDecimal(300),
+		MarketValue: NewDecimal(200), UnrealizedGain: NewDecimal(-100), TotalReturn: NewDecimal(-100)})
+
+	btc, _ := wallet.Coin("BTC")
+	eth, _ := wallet.Coin("ETH")
+	assert.Equal(t, NewDecimal(75), btc.PortfolioShare, "should be the same")
+	assert.Equal(t, NewDecimal(25), eth.PortfolioShare, "should be the same")
+	assert.Equal(t, NewDecimal(800), wallet.MarketValue, "should be the same")
+	assert.Equal(t, MustDecimal("651.5"), wallet.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(903), wallet.Invested, "should be the same")
+	assert.Equal(t, NewDecimal(491).Mul(NewDecimal(100)).Div(NewDecimal(903)), wallet.ROIPercent,
+		"should be the same")
+	assert.Equal(t, wallet.Invested, wallet.Clone().Invested, "should be the same")
+}
+
+func TestCoinUpdateProfit_NothingHeld(t *testing.T) {
+
+	testCoin := WarchestCoin{Symbol: "BTC", Rates: CoinRates{"USD": NewDecimal(400)}}
+	testCoin.UpdateProfit()
+
+	assert.True(t, testCoin.AverageEntryPrice.IsZero(), "should be the same")
+	assert.True(t, testCoin.BreakEvenPrice.IsZero(), "should be the same")
+	assert.True(t, testCoin.ROIPercent.IsZero(), "should be the same")
+}
+
 // This is one function purely for the coverage stats ;) for 'Update' method
 func TestCoin_Update(t *testing.T) {
 
@@ -33,8 +146,7 @@ func TestCoin_Update(t *testing.T) {
 		Timeout: time.Second * 10,
 	}
 
-	var absClient HTTPClient
-	absClient = &client
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
 
 	// Establish Mock
 	json := `{"data":{"currency":"ETH","rates":{"USD":"12.99","EUR":"11.99","GBP": "10.99"}}}`
@@ -44,14 +156,14 @@ func TestCoin_Update(t *testing.T) {
 		Reply(200).
 		BodyString(json)
 
-	testAmount := 1.0
-	testCost := 10.0
-	testFee := 1.0
-	testRateUSD := 30.0
+	testAmount := NewDecimal(1)
+	testCost := NewDecimal(10)
+	testFee := NewDecimal(1)
+	testRateUSD := NewDecimal(30)
 	accountID := "somethingLong"
-	testTransactions := []CoinTransaction{{testAmount, testCost, testFee}}
-	testCoin := WarchestCoin{"somethingLong", 5.0, 0.0,
-		0.0, CoinRates{0.0, 0.0, testRateUSD}, symbol, testTransactions, ""}
+	testTransactions := []CoinTransaction{{NumCoins: testAmount, PurchasedPrice: testCost, TransactionFee: testFee}}
+	testCoin := WarchestCoin{AccountID: "somethingLong", Cost: NewDecimal(5),
+		Rates: CoinRates{"USD": testRateUSD}, Symbol: symbol, Transactions: testTransactions}
 
 	transactionURL := "/v2/accounts/" + accountID + "/transactions"
 	log.Printf("Transaction URL to mock: %s\n", transactionURL)
@@ -61,15 +173,15 @@ func TestCoin_Update(t *testing.T) {
 		BodyString(transactionJSON)
 
 	// Set Expectations for dem noty bits
-	expectedRate := 12.99
-	expectedCost := testCost*testAmount + testFee
-	expectedProfit := expectedRate*testAmount - expectedCost
+	expectedRate := MustDecimal("12.99")
+	expectedCost := testCost.Mul(testAmount).Add(testFee)
+	expectedProfit := expectedRate.Mul(testAmount).Sub(expectedCost)
 
 	// Do the thing (ie. run the 3 update methods)
-	testCoin.Update(auth.CBAuth{}, absClient, false)
+	testCoin.Update(context.Background(), cb, false)
 
 	// Make sure the algo translated the response correctly
-	assert.Equal(t, expectedRate, testCoin.Rates.USD, "should be the same")
+	assert.Equal(t, expectedRate, testCoin.Rates["USD"], "should be the same")
 	assert.Equal(t, expectedCost, testCoin.Cost, "should be the same")
 	assert.Equal(t, expectedProfit, testCoin.Profit, "should be the same")
 }
@@ -83,17 +195,23 @@ func TestCoin_UpdateRates_Cloudy(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	expectedResp := 0.0
-	testTransactions := []CoinTransaction{}
-	testCoin := WarchestCoin{"somethingLong", 5.0, 0.0,
-		0.0, CoinRates{USD: -10.0}, symbol, testTransactions, ""}
+	ratesFetchedAt := time.Now().Add(-time.Hour)
+	testTransactions := []CoinTransaction{{NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(5)}}
+	testCoin := WarchestCoin{AccountID: "somethingLong", Cost: NewDecimal(5), Amount: NewDecimal(1), Rates: CoinRates{"USD": NewDecimal(10)},
+		RatesFetchedAt: ratesFetchedAt, Symbol: symbol, Transactions: testTransactions}
 
-	// Update the rates, but since there is an error we should _silently_ ignore and leave the rate at 0
-	// TODO: better error handling around requests maybe needed
-	testCoin.UpdateRates(mockClient)
+	// Update the rates, but since there is an error the last known rates should be kept and the coin marked stale
+	err := testCoin.UpdateRates(context.Background(), NewCoinbaseClient(auth.CBAuth{}, mockClient))
+	testCoin.UpdateProfit()
+	testCoin.UpdateStatus()
 
 	// Verify method corralled the bits
-	assert.Equal(t, expectedResp, testCoin.Rates.USD, "should be the same")
+	assert.Equal(t, ErrConnection, err, "should be the same")
+	assert.Equal(t, NewDecimal(10), testCoin.Rates["USD"], "the last known rate should be kept")
+	assert.Equal(t, ratesFetchedAt, testCoin.RatesFetchedAt, "the rates are as old as they were")
+	assert.Equal(t, NewDecimal(5), testCoin.Profit, "should be the same")
+	assert.Equal(t, StatusStale, testCoin.Status, "should be the same")
+	assert.Equal(t, ErrConnection.Error(), testCoin.Error, "should be the same")
 }
 
 func TestCalculateNetProfit(t *testing.T) {
@@ -102,22 +220,21 @@ func TestCalculateNetProfit(t *testing.T) {
 	client := http.Client{
 		Timeout: time.Second * 10,
 	}
-	var absClient HTTPClient
-	absClient = &client
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
 
 	// Test variables, pedantic for extensibility
-	testAmount := 1.0
-	testCost := 10.0
-	testFee := 1.0
+	testAmount := NewDecimal(1)
+	testCost := NewDecimal(10)
+	testFee := NewDecimal(1)
 	accountID := "somethingLong"
-	testTransactions := []CoinTransaction{{testAmount, testCost, testFee}}
-	testCoin := WarchestCoin{"somethingLong", 5.0, 0.0,
-		0.0, CoinRates{USD: -10.0}, symbol, testTransactions, ""}
+	testTransactions := []CoinTransaction{{NumCoins: testAmount, PurchasedPrice: testCost, TransactionFee: testFee}}
+	testCoin := WarchestCoin{AccountID: "somethingLong", Cost: NewDecimal(5),
+		Rates: CoinRates{"USD": NewDecimal(-10)}, Symbol: symbol, Transactions: testTransactions}
 
-	wallet := Wallet{map[string]WarchestCoin{symbol: testCoin}, 0.0}
+	wallet := Wallet{Coins: map[string]WarchestCoin{symbol: testCoin}, NetProfit: NewDecimal(0)}
 
-	// Criteria
-	expectedProfit := "2.99000000000000"
+	// Criteria, the fee is part of the cost
+	expectedProfit := "1.99000000000000"
 
 	// Establish Mock for Exchange Rate
 	exchangeJSON := `{"data":{"currency":"ETH","rates":{"USD":"12.99","EUR":"11.99","GBP": "10.99"}}}`
@@ -136,14 +253,283 @@ func TestCalculateNetProfit(t *testing.T) {
 		BodyString(transactionJSON)
 
 	// Do the things then set threshold for easier comparison of float values
-	actualResp, err := wallet.UpdateNetProfit(auth.CBAuth{}, absClient, false)
-	actualProfit := fmt.Sprintf("%.14f", actualResp)
+	actualResp, err := wallet.UpdateNetProfit(context.Background(), cb, false)
+	actualProfit := actualResp.StringFixed(14)
 
 	// Make sure there was only 1 call to the remote API, we don't want to be banned!
 	assert.Nil(t, err, "this was mocked, and should not fail")
 	assert.Equal(t, expectedProfit, actualProfit, "should be the same")
 }
 
+func TestCoinUpdateCost_Kinds(t *testing.T) {
+
+	buy := CoinTransaction{Kind: KindBuy, Status: StatusCompleted, NumCoins: NewDecimal(10), PurchasedPrice: NewDecimal(1000),
+		Timestamp: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	later := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	costTests := []struct {
+		name           string
+		transaction    CoinTransaction
+		expectedAmount Decimal
+		expectedCost   Decimal
+	}{
+		{"Buy", CoinTransaction{Kind: KindBuy, NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(300)}, NewDecimal(12), NewDecimal(1300)},
+		{"Sell", CoinTransaction{Kind: KindSell, NumCoins: NewDecimal(2), PurchasedPrice: NewDecimal(300)}, NewDecimal(8), NewDecimal(800)},
+		{"Send", CoinTransaction{Kind: KindSend, NumCoins: NewDecimal(5), PurchasedPrice: NewDecimal(750)}, NewDecimal(5), NewDecimal(500)},
+		{"Receive", CoinTransaction{Kind: KindReceive, NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(150)}, NewDecimal(11), NewDecimal(1150)},
+		{"Trade in", CoinTransaction{Kind: KindTradeIn, NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(120)}, NewDecimal(11), NewDecimal(1120)},
+		{"Trade out", CoinTransaction{Kind: KindTradeOut, NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(120)}, NewDecimal(9), NewDecimal(900)},
+		{"Interest", CoinTransaction{Kind: KindInterest, NumCoins: MustDecimal("0.1"), PurchasedPrice: NewDecimal(15)}, MustDecimal("10.1"), NewDecimal(1015)},
+		{"Staking reward", CoinTransaction{Kind: KindStakingReward, NumCoins: MustDecimal("0.2"), PurchasedPrice: NewDecimal(30)}, MustDecimal("10.2"), NewDecimal(1030)},
+		{"Reward", CoinTransaction{Kind: KindReward, NumCoins: MustDecimal("0.5"), PurchasedPrice: NewDecimal(75)}, MustDecimal("10.5"), NewDecimal(1075)},
+		{"Fiat deposit", CoinTransaction{Kind: KindFiatDeposit, NumCoins: NewDecimal(500), PurchasedPrice: NewDecimal(500)}, NewDecimal(10), NewDecimal(1000)},
+		{"Unknown", CoinTransaction{Kind: KindUnknown, NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(100)}, NewDecimal(10), NewDecimal(1000)},
+		{"Pending", CoinTransaction{Kind: KindBuy, Status: "pending", NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(100)}, NewDecimal(10), NewDecimal(1000)},
+		{"Sell everything and more", CoinTransaction{Kind: KindSell, NumCoins: NewDecimal(11), PurchasedPrice: NewDecimal(1650)}, NewDecimal(0), NewDecimal(0)},
+	}
+
+	for _, tt := range costTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.transaction.Timestamp = later
+
+			// Coinbase returns the newest transactions first
+			testCoin := WarchestCoin{Symbol: "ETH", Transactions: []CoinTransaction{tt.transaction, buy}}
+			testCoin.UpdateCost()
+
+			assert.Equal(t, tt.expectedAmount, testCoin.Amount, "should be the same")
+			assert.Equal(t, tt.expectedCost, testCoin.Cost, "should be the same")
+		})
+	}
+}
+
+func TestCoinUpdateTransactions_Kinds(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+	accountID := "somethingLong"
+
+	byteValue, err := ioutil.ReadFile("./testdata/transaction_types.json")
+	assert.Nil(t, err, "fixture should exist")
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/accounts/"+accountID+"/transactions",
+		httpmock.NewBytesResponder(200, byteValue))
+	httpmock.RegisterResponder("GET", CBBaseURL+"/v2/prices/ETH-USD/spot",
+		httpmock.NewStringResponder(200, `{"data":{"base":"ETH","currency":"USD","amount":"150.00"}}`))
+
+	testCoin := WarchestCoin{AccountID: accountID, Symbol: "ETH"}
+	testCoin.UpdateTransactions(context.Background(), cb)
+	testCoin.UpdateCost()
+
+	// Pending and failed transactions should have been dropped
+	assert.Equal(t, 17, len(testCoin.Transactions), "should be the same")
+	for _, transaction := range testCoin.Transactions {
+		assert.Equal(t, StatusCompleted, transaction.Status, "should be the same")
+	}
+
+	// buy 10, sell 2, send 1, receive 3, trade in 2, trade out 1, interest 0.1, staking 0.2, reward 0.3, and
+	// transfers out of the account and back in
+	assert.Equal(t, MustDecimal("11.6"), testCoin.Amount, "should be the same")
+	// 1000 -> 800 -> 700 -> 1150 -> 1450 -> 1450*11/12 -> +15 -> +30 -> +45
+	assert.InDelta(t, 1450.0*11/12+90.0, testCoin.Cost.Float64(), 1e-9, "should be the same")
+}
+
+func TestCoin_UpdateCurrency(t *testing.T) {
+
+	client := http.Client{
+		Timeout: time.Second * 10,
+	}
+	cb := NewCoinbaseClient(auth.CBAuth{}, &client)
+
+	// Establish Mock
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponderWithQuery("GET", CBBaseURL+CBExchangeRateURL, "currency=EUR",
+		httpmock.NewStringResponder(200, `{"data":{"currency":"EUR","rates":{"CHF":"0.95","USD":"1.1"}}}`))
+	httpmock.RegisterResponderWithQuery("GET", CBBaseURL+CBExchangeRateURL, "currency=ETH",
+		httpmock.NewStringResponder(200, `{"data":{"currency":"ETH","rates":{"CHF":"1900.0","USD":"2200.0"}}}`))
+
+	testTransactions := []CoinTransaction{
+		{ID: "eur-1", Kind: KindBuy, Currency: "EUR", NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(990), TransactionFee: NewDecimal(10),
+			Subtotal: NewDecimal(990), UnitPrice: NewDecimal(990)},
+		{ID: "chf-1", Kind: KindBuy, Currency: "chf", NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(1500)},
+		{ID: "config-1", Kind: KindBuy, NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(500)},
+	}
+
+	t.Run("Converted into the base currency", func(t *testing.T) {
+		testCoin := WarchestCoin{Symbol: "ETH", Currency: "CHF", Transactions: testTransactions}
+
+		err := testCoin.Update(context.Background(), cb, true)
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, CoinTransaction{ID: "eur-1", Kind: KindBuy, Currency: "CHF", NumCoins: NewDecimal(1),
+			PurchasedPrice: MustDecimal("940.5"), TransactionFee: MustDecimal("9.5"), Subtotal: MustDecimal("940.5"), UnitPrice: MustDecimal("940.5")}, testCoin.Transactions[0],
+			"should be the same")
+		assert.Equal(t, NewDecimal(1500), testCoin.Transactions[1].PurchasedPrice, "the base currency shouldn't be converted")
+		assert.Equal(t, NewDecimal(500), testCoin.Transactions[2].PurchasedPrice, "should be the same")
+		assert.Equal(t, NewDecimal(2950), testCoin.Cost, "should be the same")
+		assert.Equal(t, NewDecimal(1900*3-2950), testCoin.Profit, "profit should be in the base currency")
+		assert.Equal(t, "EUR", testTransactions[0].Currency, "the original transactions should be left alone")
+	})
+
+	t.Run("Converted at the rate on the day", func(t *testing.T) {
+		httpmock.RegisterResponderWithQuery("GET", CBBaseURL+"/v2/prices/EUR-CHF/spot", "date=2021-03-01",
+			httpmock.NewStringResponder(200, `{"data":{"base":"EUR","currency":"CHF","amount":"1.10"}}`))
+		spotPrices, _ := NewSpotPriceCache("")
+		historical := NewCoinbaseClient(auth.CBAuth{}, &client)
+		historical.SpotPrices = spotPrices
+
+		bought := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+		testCoin := WarchestCoin{Symbol: "ETH", Currency: "CHF", Transactions: []CoinTransaction{
+			{ID: "eur-1", Kind: KindBuy, Currency: "EUR", Timestamp: bought, NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(990), TransactionFee: NewDecimal(10)},
+			{ID: "eur-2", Kind: KindBuy, Currency: "EUR", Timestamp: bought.Add(time.Hour), NumCoins: NewDecimal(1),
+				PurchasedPrice: NewDecimal(1000)},
+		}}
+
+		err := testCoin.UpdateCurrency(context.Background(), historical)
+
+		assert.Nil(t, err, "should not fail")
+		assert.Equal(t, NewDecimal(1089), testCoin.Transactions[0].PurchasedPrice,
+			"should be converted at the rate on the day rather than today's 0.95")
+		assert.Equal(t, NewDecimal(11), testCoin.Transactions[0].TransactionFee, "should be the same")
+		assert.Equal(t, NewDecimal(1100), testCoin.Transactions[1].PurchasedPrice, "should be the same")
+		price, ok := spotPrices.Get("EUR", "CHF", bought)
+		assert.True(t, ok, "the rate should be cached")
+		assert.Equal(t, MustDecimal("1.1"), price, "should be the same")
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+CBBaseURL+"/v2/prices/EUR-CHF/spot?date=2021-03-01"],
+			"the rate should only be retrieved once for the day")
+	})
+
+	t.Run("No exchange rate", func(t *testing.T) {
+		testCoin := WarchestCoin{Symbol: "ETH", Currency: "SEK", Transactions: testTransactions}
+
+		err := testCoin.UpdateCurrency(context.Background(), cb)
+
+		assert.Equal(t, ErrUnknownCurrency, err, "should be the same")
+		assert.Equal(t, testTransactions, testCoin.Transactions, "the transactions should be left alone")
+	})
+}
+
+func TestGetWarchestCoins_Accounts(t *testing.T) {
+
+	buyJSON := func(id, coins, price string) string {
+		return `{"pagination":{"next_uri":null},"data":[{"id":"` + id + `","type":"buy","status":"completed",` +
+			`"amount":{"amount":"` + coins + `","currency":"ETH"},` +
+			`"native_amount":{"amount":"` + price + `","currency":"USD"}}]}`
+	}
+
+	client := newConcurrentClient(map[string]string{"ETH": "150.0"})
+	client.responses[CBAccountsURL+"?limit=25"] = `{"pagination":{"next_uri":null},"data":[` +
+		`{"id":"eth-wallet","type":"wallet","currency":{"code":"ETH"},"balance":{"amount":"2.0","currency":"ETH"}},` +
+		`{"id":"eth-vault","type":"vault","currency":{"code":"ETH"},"balance":{"amount":"1.5","currency":"ETH"}}]}`
+	client.responses["/v2/accounts/eth-wallet/transactions?"+CBTransactionExpand+"&limit=25"] =
+		buyJSON("wallet-buy", "2.0", "200.00")
+	client.responses["/v2/accounts/eth-vault/transactions?"+CBTransactionExpand+"&limit=25"] =
+		buyJSON("vault-buy", "1.5", "300.00")
+
+	coins, _, err := GetWarchestCoins(context.Background(), []Provider{NewCoinbaseClient(auth.CBAuth{}, client)},
+		false, 2, DefaultCurrency, DefaultCostMethod, CoinFilter{})
+
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, 1, len(coins), "both accounts should be the same coin")
+
+	eth := coins["ETH"]
+	assert.Equal(t, []CoinAccount{
+		{Provider: CBProviderName, AccountID: "eth-wallet", Balance: NewDecimal(2), Amount: NewDecimal(2), Cost: NewDecimal(200), Profit: NewDecimal(100)},
+		{Provider: CBProviderName, AccountID: "eth-vault", Balance: MustDecimal("1.5"), Amount: MustDecimal("1.5"), Cost: NewDecimal(300), Profit: NewDecimal(-75)},
+	}, eth.Accounts, "should be the same")
+	assert.Equal(t, 2, len(eth.Transactions), "the transactions of both accounts should be kept")
+	assert.Equal(t, MustDecimal("3.5"), eth.Amount, "should be the same")
+	assert.Equal(t, NewDecimal(500), eth.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(25), eth.Profit, "should be the same")
+
+	for _, transaction := range eth.Transactions {
+		assert.Equal(t, "eth-"+transaction.ID[:strings.Index(transaction.ID, "-")], transaction.AccountID,
+			"transactions should know their account")
+	}
+}
+
+// growingProvider is a Provider holding a single DOGE account, whose transactions can be added to between updates
+type growingProvider struct {
+	transactions []CoinTransaction
+}
+
+func (g *growingProvider) Name() string {
+	return "growing"
+}
+
+func (g *growingProvider) Holdings(ctx context.Context) ([]Holding, error) {
+	return []Holding{{Provider: "growing", AccountID: "growing-doge", Symbol: "DOGE", Balance: NewDecimal(10)}}, nil
+}
+
+func (g *growingProvider) Transactions(ctx context.Context, holding Holding) ([]CoinTransaction, error) {
+	return append([]CoinTransaction{}, g.transactions...), nil
+}
+
+func (g *growingProvider) Rates(ctx context.Context, symbol string) (CoinRates, error) {
+	return CoinRates{"USD": NewDecimal(1)}, nil
+}
+
+func TestWallet_Refresh(t *testing.T) {
+
+	bought := time.Unix(1620000000, 0).UTC()
+	growing := &growingProvider{transactions: []CoinTransaction{{ID: "growing-1", Kind: KindBuy,
+		Status: StatusCompleted, Provider: "growing", Timestamp: bought, NumCoins: NewDecimal(10),
+		PurchasedPrice: NewDecimal(5)}}}
+	providers := []Provider{growing, stubProvider{}}
+
+	coins, _, err := GetWarchestCoins(context.Background(), providers, false, 2, DefaultCurrency, DefaultCostMethod,
+		CoinFilter{})
+	assert.Nil(t, err, "should not fail")
+	wallet := Wallet{Coins: coins}
+	assert.Equal(t, 2, len(wallet.Coins["DOGE"].Transactions), "should be the same")
+
+	// A transaction made between refreshes
+	growing.transactions = append(growing.transactions, CoinTransaction{ID: "growing-2", Kind: KindBuy,
+		Status: StatusCompleted, Provider: "growing", Timestamp: bought.Add(time.Hour), NumCoins: NewDecimal(5),
+		PurchasedPrice: NewDecimal(4)})
+
+	netProfit, err := wallet.Refresh(context.Background(), providers, false)
+
+	doge := wallet.Coins["DOGE"]
+	assert.Nil(t, err, "should not fail")
+	assert.Equal(t, 3, len(doge.Transactions), "the new transaction should have been retrieved")
+	assert.Equal(t, NewDecimal(115), doge.Amount, "should be the same")
+	assert.Equal(t, NewDecimal(19), doge.Cost, "should be the same")
+	assert.Equal(t, NewDecimal(115-19), netProfit, "should be the same")
+	assert.Equal(t, StatusOK, doge.Status, "should be the same")
+
+	t.Run("Demo mode doesn't retrieve transactions", func(t *testing.T) {
+		growing.transactions = append(growing.transactions, CoinTransaction{ID: "growing-3", Kind: KindBuy,
+			Status: StatusCompleted, Provider: "growing", Timestamp: bought.Add(2 * time.Hour),
+			NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(1)})
+
+		wallet.Refresh(context.Background(), providers, true)
+
+		assert.Equal(t, 3, len(wallet.Coins["DOGE"].Transactions), "should be the same")
+	})
+}
+
+func TestCalculateNetProfit_Cancelled(t *testing.T) {
+
+	testTransactions := []CoinTransaction{{NumCoins: NewDecimal(1), PurchasedPrice: NewDecimal(10)}}
+	testCoin := WarchestCoin{AccountID: "somethingLong", Symbol: "ETH", Transactions: testTransactions}
+	wallet := Wallet{Coins: map[string]WarchestCoin{"ETH": testCoin}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The calculation should stop before any request is made
+	_, err := wallet.UpdateNetProfit(ctx, NewCoinbaseClient(auth.CBAuth{}, &MockClient{}), false)
+
+	assert.Equal(t, context.Canceled, err, "a cancelled context should stop the calculation")
+}
+
 const transactionJSON = `{
 	"pagination": {
 		"ending_before": null,
@@ -174,7 +560,24 @@ const transactionJSON = `{
 			"buy": {
 				"id": "9e14d574-30fa-5d85-b02c-6be0d851d61d",
 				"resource": "buy",
-				"resource_path": "/v2/accounts/2bbf394c-193b-5b2a-9155-3b4732659ede/buys/9e14d574-30fa-5d85-b02c-6be0d851d61d"
+				"resource_path": "/v2/accounts/2bbf394c-193b-5b2a-9155-3b4732659ede/buys/9e14d574-30fa-5d85-b02c-6be0d851d61d",
+				"status": "completed",
+				"total": {
+					"amount": "11.00",
+					"currency": "USD"
+				},
+				"subtotal": {
+					"amount": "10.00",
+					"currency": "USD"
+				},
+				"fee": {
+					"amount": "1.00",
+					"currency": "USD"
+				},
+				"unit_price": {
+					"amount": "10.00",
+					"currency": "USD"
+				}
 			},
 			"details": {
 				"title": "Bought ETH",